@@ -0,0 +1,46 @@
+package cransaction
+
+import (
+	"context"
+	"fmt"
+)
+
+// WithAuditContextKey Attach the acting user stored in the context under key to every write run by ExecQuery
+//
+// Inside a Postgres transaction the value is set in the run-time parameter setting, such as
+// app.current_user_id, with set_config scoped to the transaction right before each write, so triggers can read
+// it with current_setting. Elsewhere it is appended to the statement as a sqlcommenter comment named setting.
+// Writes issued with a context without the value are left alone
+func WithAuditContextKey(key interface{}, setting string) Option {
+	return func(o *options) {
+		o.auditKey = key
+		o.auditSetting = setting
+	}
+}
+
+// audit Apply WithAuditContextKey to query, set runs a statement in the transaction st of ctx
+func (o *options) audit(ctx context.Context, st *txState, d dialecter, query string, set func(stmt string, args ...interface{}) error) (string, error) {
+	if o.auditKey == nil || !isWrite(query) {
+		return query, nil
+	}
+	value := ctx.Value(o.auditKey)
+	if value == nil {
+		return query, nil
+	}
+	actor := fmt.Sprint(value)
+	if st != nil && d.dialect() == "postgres" {
+		// set_config is undone with the savepoint it ran in, so it is issued again for every write
+		stmt := "SELECT set_config(" + d.bindVar(1) + ", " + d.bindVar(2) + ", true)"
+		return query, set(stmt, o.auditSetting, actor)
+	}
+	return appendTags(query, map[string]string{o.auditSetting: actor}), nil
+}
+
+// isWrite Report whether query modifies rows
+func isWrite(query string) bool {
+	switch statementVerb(query) {
+	case "INSERT", "UPDATE", "DELETE", "REPLACE", "MERGE":
+		return true
+	}
+	return false
+}
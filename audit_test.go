@@ -0,0 +1,105 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"testing"
+)
+
+type actorKey struct{}
+
+// localSetting Emulate a run-time parameter set with set_config(name, value, true), dropped when the transaction ends
+func localSetting(f *fakeDB) {
+	var mu sync.Mutex
+	value := ""
+	f.handle("set_config", func(_ context.Context, args []driver.NamedValue) (*fakeResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		value = args[1].Value.(string)
+		return nil, nil
+	})
+	f.handle("current_setting", func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return rowsOf([]string{"current_setting"}, []driver.Value{value}), nil
+	})
+	reset := func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		value = ""
+		return nil, nil
+	}
+	f.handle("COMMIT", reset)
+	f.handle("ROLLBACK", reset)
+}
+
+func TestAuditSetsLocalSetting(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, tx := open(t, "postgres", WithAuditContextKey(actorKey{}, "app.current_user_id"))
+			localSetting(f)
+			ctx := context.WithValue(context.Background(), actorKey{}, 42)
+			var inside string
+			err := tx.Transaction(ctx, func(ctx context.Context) error {
+				if _, err := tx.ExecQuery(ctx, "UPDATE accounts SET balance = 0"); err != nil {
+					return err
+				}
+				return (&Result{ctx: ctx, tx: tx, query: "SELECT current_setting('app.current_user_id', true)"}).Scalar(&inside)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inside != "42" {
+				t.Errorf("current_setting in the transaction = %q, want 42", inside)
+			}
+			set := f.last("set_config")
+			if !set.inTx || len(set.args) != 2 || set.args[0] != "app.current_user_id" || set.args[1] != "42" {
+				t.Errorf("set_config = %+v", set)
+			}
+			if q := f.last("UPDATE").query; q != "UPDATE accounts SET balance = 0" {
+				t.Errorf("write = %q, want it without a comment", q)
+			}
+			var after string
+			err = (&Result{ctx: context.Background(), tx: tx, query: "SELECT current_setting('app.current_user_id', true)"}).Scalar(&after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after != "" {
+				t.Errorf("current_setting after commit = %q, want it gone", after)
+			}
+		})
+	}
+}
+
+func TestAuditComment(t *testing.T) {
+	ctx := context.WithValue(context.Background(), actorKey{}, "ada")
+	t.Run("autocommit", func(t *testing.T) {
+		f, r := newFakeSession(t, "postgres", WithAuditContextKey(actorKey{}, "app.current_user_id"))
+		if _, err := r.ExecQuery(ctx, "DELETE FROM sessions /*owner='jobs'*/;"); err != nil {
+			t.Fatal(err)
+		}
+		wantStatements(t, f, "DELETE FROM sessions /*owner='jobs'*/ /*app.current_user_id='ada'*/;")
+	})
+	t.Run("mysql", func(t *testing.T) {
+		f, r := newFakeSession(t, "mysql", WithAuditContextKey(actorKey{}, "actor"))
+		err := r.Transaction(ctx, func(ctx context.Context) error {
+			_, err := r.ExecQuery(ctx, "INSERT INTO notes (body) VALUES (?)", "hi")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatements(t, f, "BEGIN", "INSERT INTO notes (body) VALUES (?) /*actor='ada'*/", "COMMIT")
+	})
+	t.Run("reads and missing actor", func(t *testing.T) {
+		f, r := newFakeSession(t, "postgres", WithAuditContextKey(actorKey{}, "actor"))
+		if _, err := r.ExecQuery(ctx, "SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.ExecQuery(context.Background(), "UPDATE users SET name = $1", "ada"); err != nil {
+			t.Fatal(err)
+		}
+		wantStatements(t, f, "SELECT 1", "UPDATE users SET name = $1")
+	})
+}
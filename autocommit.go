@@ -0,0 +1,24 @@
+package cransaction
+
+import "context"
+
+// autocommitSession Session whose Transaction runs fn without a database transaction, see NewAutocommitSession
+type autocommitSession struct {
+	ITransaction
+}
+
+// NewAutocommitSession Create a session for code paths that must not transact, such as tooling or read-only replicas
+//
+// Transaction calls fn directly and every query runs autocommit, so fn gets no atomicity: a failing fn leaves
+// the statements it already ran in place
+func NewAutocommitSession(driverType string, db interface{}, opts ...Option) ITransaction {
+	return &autocommitSession{ITransaction: NewSession(driverType, db, nil, nil, opts...)}
+}
+
+func (a *autocommitSession) Transaction(ctx context.Context, fn func(context.Context) error) (err error) {
+	defer optionsOf(a.ITransaction).mapError(&err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fn(ctx)
+}
@@ -0,0 +1,33 @@
+package cransaction
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAutocommitSessionRunsFnWithoutTransaction(t *testing.T) {
+	f, db := newFakeDB(t)
+	s := NewAutocommitSession("postgres", db)
+	ran := false
+	err := s.Transaction(context.Background(), func(ctx context.Context) error {
+		ran = true
+		if TransactionDepth(ctx) != 0 {
+			t.Error("fn runs in a transaction")
+		}
+		if _, err := s.ExecQuery(ctx, "INSERT INTO events VALUES (1)"); err != nil {
+			return err
+		}
+		return errFake
+	})
+	if !ran || !errors.Is(err, errFake) {
+		t.Fatalf("ran = %v, err = %v", ran, err)
+	}
+	if f.last("INSERT INTO events").inTx {
+		t.Error("the insert ran in a transaction")
+	}
+	wantStatements(t, f, "INSERT INTO events VALUES (1)")
+	if len(f.txOpts) != 0 {
+		t.Errorf("%d transactions begun", len(f.txOpts))
+	}
+}
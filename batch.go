@@ -0,0 +1,98 @@
+package cransaction
+
+import (
+	"context"
+	"fmt"
+)
+
+// BatchWriter Buffer statements and run them in transactions of bounded size, see BatchCommitWriter
+//
+// A BatchWriter is not safe for concurrent use
+type BatchWriter struct {
+	ctx       context.Context
+	session   beginner
+	batchSize int
+	pending   []batchStatement
+	committed int
+	err       error
+}
+
+type batchStatement struct {
+	query string
+	args  []interface{}
+}
+
+// beginner Session able to start a transaction outside of a closure
+type beginner interface {
+	ITransaction
+	Begin(ctx context.Context) (context.Context, func(err error) error, error)
+}
+
+// BatchCommitWriter Start a writer committing the statements given to Write in batches of batchSize
+//
+// The writer must be closed to commit the last, partial batch
+func (r *RDMSSession) BatchCommitWriter(ctx context.Context, batchSize int) (*BatchWriter, error) {
+	return newBatchWriter(ctx, r, batchSize)
+}
+
+// BatchCommitWriter Start a writer committing the statements given to Write in batches of batchSize
+//
+// The writer must be closed to commit the last, partial batch
+func (g *GormSession) BatchCommitWriter(ctx context.Context, batchSize int) (*BatchWriter, error) {
+	return newBatchWriter(ctx, g, batchSize)
+}
+
+func newBatchWriter(ctx context.Context, session beginner, batchSize int) (*BatchWriter, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("cransaction: batch size must be positive, got %d", batchSize)
+	}
+	return &BatchWriter{ctx: ctx, session: session, batchSize: batchSize}, nil
+}
+
+// Write Buffer a statement, a full batch is committed in its own transaction before Write returns
+//
+// When a batch fails its transaction is rolled back, the error is returned by this and every later call and
+// Committed reports the statements of the batches committed before it
+func (w *BatchWriter) Write(query string, args ...interface{}) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.pending = append(w.pending, batchStatement{query: query, args: args})
+	if len(w.pending) < w.batchSize {
+		return nil
+	}
+	return w.flush()
+}
+
+// Close Commit the buffered statements, returns the error of a batch that failed earlier
+func (w *BatchWriter) Close() error {
+	if w.err != nil || len(w.pending) == 0 {
+		return w.err
+	}
+	return w.flush()
+}
+
+// Committed Number of statements committed so far
+func (w *BatchWriter) Committed() int {
+	return w.committed
+}
+
+func (w *BatchWriter) flush() error {
+	ctx, finish, err := w.session.Begin(w.ctx)
+	if err == nil {
+		for _, stmt := range w.pending {
+			if _, err = w.session.ExecQuery(ctx, stmt.query, stmt.args...); err != nil {
+				break
+			}
+		}
+		err = finish(err)
+	}
+	if err != nil {
+		w.err = fmt.Errorf("cransaction: batch failed after %d committed statements: %w", w.committed, err)
+		w.pending = nil
+		return w.err
+	}
+	w.committed += len(w.pending)
+	w.pending = w.pending[:0]
+	return nil
+}
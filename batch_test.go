@@ -0,0 +1,74 @@
+package cransaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// batchSession Session offering BatchCommitWriter, both backends do
+type batchSession interface {
+	BatchCommitWriter(ctx context.Context, batchSize int) (*BatchWriter, error)
+}
+
+func TestBatchCommitWriter(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			w, err := tx.(batchSession).BatchCommitWriter(context.Background(), 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i <= 5; i++ {
+				if err = w.Write(fmt.Sprintf("INSERT INTO events VALUES (%d)", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if w.Committed() != 4 {
+				t.Errorf("committed %d before Close, want 4", w.Committed())
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if w.Committed() != 5 {
+				t.Errorf("committed %d, want 5", w.Committed())
+			}
+			wantStatements(t, f,
+				"BEGIN", "INSERT INTO events VALUES (1)", "INSERT INTO events VALUES (2)", "COMMIT",
+				"BEGIN", "INSERT INTO events VALUES (3)", "INSERT INTO events VALUES (4)", "COMMIT",
+				"BEGIN", "INSERT INTO events VALUES (5)", "COMMIT")
+		})
+	}
+}
+
+func TestBatchCommitWriterFailure(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	f.fail("VALUES (4)", errFake)
+	w, err := r.BatchCommitWriter(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		err = w.Write(fmt.Sprintf("INSERT INTO events VALUES (%d)", i))
+	}
+	if !errors.Is(err, errFake) {
+		t.Fatalf("err = %v", err)
+	}
+	if w.Committed() != 2 {
+		t.Errorf("committed %d, want the 2 of the first batch", w.Committed())
+	}
+	if err = w.Write("INSERT INTO events VALUES (5)"); !errors.Is(err, errFake) {
+		t.Errorf("Write after the failure err = %v", err)
+	}
+	if err = w.Close(); !errors.Is(err, errFake) {
+		t.Errorf("Close err = %v", err)
+	}
+	wantStatements(t, f,
+		"BEGIN", "INSERT INTO events VALUES (1)", "INSERT INTO events VALUES (2)", "COMMIT",
+		"BEGIN", "INSERT INTO events VALUES (3)", "INSERT INTO events VALUES (4)", "ROLLBACK")
+
+	if _, err = r.BatchCommitWriter(context.Background(), 0); err == nil {
+		t.Error("a zero batch size was accepted")
+	}
+}
@@ -0,0 +1,231 @@
+package cransaction
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// defaultBulkChunkSize Rows per INSERT statement when BulkOptions.ChunkSize is zero
+const defaultBulkChunkSize = 500
+
+// maxBindVars Bind parameters allowed in one statement by Postgres and MySQL
+const maxBindVars = 65535
+
+// BulkOptions Tune a bulk operation
+type BulkOptions struct {
+	// ChunkSize Rows per statement, 500 when zero, lowered to stay under the bind parameter limit
+	ChunkSize int
+	// ProgressEvery Minimum number of rows between two Progress calls, every chunk when zero
+	ProgressEvery int64
+	// Progress Receive the number of rows written so far, always called once the last chunk is written
+	Progress func(rowsSoFar int64)
+}
+
+func (o BulkOptions) chunkSize(columns int) int {
+	size := o.ChunkSize
+	if size <= 0 {
+		size = defaultBulkChunkSize
+	}
+	if columns > 0 && size*columns > maxBindVars {
+		size = maxBindVars / columns
+	}
+	return size
+}
+
+// progress Report done rows to Progress when enough rows were written since last, returns the reported count
+func (o BulkOptions) progress(done, last, total int64) int64 {
+	if o.Progress == nil || (done-last < o.ProgressEvery && done != total) {
+		return last
+	}
+	o.Progress(done)
+	return done
+}
+
+// BulkInsert Insert rows into table with multi-row INSERT statements, returns the number of inserted rows
+//
+// The load runs in the transaction of tx in ctx, or in its own transaction when there is none, and stops between
+// chunks as soon as ctx is done so the partial load is rolled back
+func BulkInsert(ctx context.Context, tx ITransaction, table string, columns []string, rows [][]interface{}, opts BulkOptions) (int64, error) {
+	if err := checkTable(table); err != nil {
+		return 0, err
+	}
+	for _, column := range columns {
+		if err := checkIdentifier(column); err != nil {
+			return 0, err
+		}
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	d := dialectOf(tx)
+	var inserted int64
+	err := inTransaction(ctx, tx, func(ctx context.Context) error {
+		size := opts.chunkSize(len(columns))
+		var reported int64
+		for start := 0; start < len(rows); start += size {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			chunk := rows[start:min(start+size, len(rows))]
+			query, args, err := insertStatement(d, table, columns, chunk)
+			if err != nil {
+				return err
+			}
+			result, err := tx.ExecQuery(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			n, err := rowsAffected(result)
+			if err != nil {
+				return err
+			}
+			inserted += n
+			reported = opts.progress(int64(start+len(chunk)), reported, int64(len(rows)))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+// insertStatement Build a multi-row INSERT for rows
+func insertStatement(d dialecter, table string, columns []string, rows [][]interface{}) (string, []interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+	args := make([]interface{}, 0, len(rows)*len(columns))
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return "", nil, fmt.Errorf("cransaction: row has %d values for %d columns", len(row), len(columns))
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for j, v := range row {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			args = append(args, v)
+			b.WriteString(d.bindVar(len(args)))
+		}
+		b.WriteByte(')')
+	}
+	return b.String(), args, nil
+}
+
+// inTransaction Run fn in the transaction tx has in ctx, or in a new transaction of tx when it has none. A
+// transaction of another session in ctx is not joined, its statements would not run on tx
+func inTransaction(ctx context.Context, tx ITransaction, fn func(context.Context) error) error {
+	if sessionState(ctx, tx) != nil {
+		return fn(ctx)
+	}
+	return tx.Transaction(ctx, fn)
+}
+
+// BulkDelete Delete the rows of table whose keyColumn is one of keys, returns the number of deleted rows
+//
+// On Postgres the keys go in one array parameter compared with = ANY, so one statement deletes up to 65535 of
+// them. Other drivers delete them with IN lists of up to 500 keys per statement. The statements run in the
+// transaction of tx in ctx, or in their own transaction when there is none. Empty keys delete nothing
+func BulkDelete(ctx context.Context, tx ITransaction, table string, keyColumn string, keys []interface{}) (int64, error) {
+	if err := checkTable(table); err != nil {
+		return 0, err
+	}
+	size := 0
+	if dialectOf(tx).dialect() == "postgres" {
+		size = len(keys)
+	}
+	return execBatched(ctx, tx, "DELETE FROM "+table, keyColumn, keys, size, nil)
+}
+
+// ExecBatched Run query once per batch of allKeys, restricted to the rows whose keyColumn is in the batch, and
+// return the total number of affected rows
+//
+// query is an UPDATE or DELETE without WHERE clause, ExecBatched appends the one filtering on the keys of the
+// batch: = ANY over an array parameter on Postgres, an IN list elsewhere. batchSize keys go in each statement,
+// 500 when zero or less. progress, when set, receives the number of affected rows so far after every batch.
+// The batches run in the transaction of tx in ctx, or in their own transaction when there is none, and stop as
+// soon as ctx is done. Empty allKeys run nothing
+func ExecBatched(ctx context.Context, tx ITransaction, query string, keyColumn string, allKeys []interface{}, batchSize int, progress func(done int64)) (int64, error) {
+	if hasWhere(query) {
+		return 0, fmt.Errorf("cransaction: ExecBatched adds the WHERE clause, query must not have one")
+	}
+	return execBatched(ctx, tx, query, keyColumn, allKeys, batchSize, progress)
+}
+
+func execBatched(ctx context.Context, tx ITransaction, query string, keyColumn string, keys []interface{}, size int, progress func(done int64)) (int64, error) {
+	if err := checkIdentifier(keyColumn); err != nil {
+		return 0, err
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	d := dialectOf(tx)
+	size = BulkOptions{ChunkSize: size}.chunkSize(1)
+	var affected int64
+	err := inTransaction(ctx, tx, func(ctx context.Context) error {
+		for start := 0; start < len(keys); start += size {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			filter, args := keyFilter(d, keyColumn, keys[start:min(start+size, len(keys))])
+			result, err := tx.ExecQuery(ctx, query+" WHERE "+filter, args...)
+			if err != nil {
+				return err
+			}
+			n, err := rowsAffected(result)
+			if err != nil {
+				return err
+			}
+			affected += n
+			if progress != nil {
+				progress(affected)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return affected, nil
+}
+
+// keyFilter Condition matching the rows whose keyColumn is one of keys, with its args
+func keyFilter(d dialecter, keyColumn string, keys []interface{}) (string, []interface{}) {
+	if d.dialect() == "postgres" {
+		return fmt.Sprintf("%s = ANY(%s)", keyColumn, d.bindVar(1)), []interface{}{pgArray(keys)}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s IN (", keyColumn)
+	for i := range keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(d.bindVar(i + 1))
+	}
+	b.WriteByte(')')
+	return b.String(), keys
+}
+
+// hasWhere Whether query contains the WHERE keyword outside string literals and quoted identifiers
+func hasWhere(query string) bool {
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case (c == 'w' || c == 'W') && (i == 0 || !isIdentByte(query[i-1])) && len(query) >= i+5 &&
+			strings.EqualFold(query[i:i+5], "where") && (len(query) == i+5 || !isIdentByte(query[i+5])):
+			return true
+		}
+	}
+	return false
+}
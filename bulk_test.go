@@ -0,0 +1,232 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// inserted Answer INSERT statements affecting one row per columns args
+func inserted(columns int) func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+	return func(_ context.Context, args []driver.NamedValue) (*fakeResult, error) {
+		return &fakeResult{affected: int64(len(args) / columns)}, nil
+	}
+}
+
+func bulkRows(n int) [][]interface{} {
+	rows := make([][]interface{}, n)
+	for i := range rows {
+		rows[i] = []interface{}{i + 1, fmt.Sprintf("user %d", i+1)}
+	}
+	return rows
+}
+
+func TestBulkInsertProgress(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			f.handle("INSERT", inserted(2))
+			var reports []int64
+			n, err := BulkInsert(context.Background(), s, "users", []string{"id", "name"}, bulkRows(10), BulkOptions{
+				ChunkSize:     2,
+				ProgressEvery: 4,
+				Progress: func(rowsSoFar int64) {
+					reports = append(reports, rowsSoFar)
+				},
+			})
+			if err != nil || n != 10 {
+				t.Fatalf("n = %d, err = %v", n, err)
+			}
+			if !slices.Equal(reports, []int64{4, 8, 10}) {
+				t.Errorf("progress reports = %v", reports)
+			}
+			if f.count("INSERT") != 5 || f.count("BEGIN") != 1 || f.count("COMMIT") != 1 {
+				t.Errorf("statements = %v", f.statements())
+			}
+		})
+	}
+}
+
+func TestBulkInsertCancelled(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	f.handle("INSERT", inserted(2))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := BulkInsert(ctx, r, "users", []string{"id", "name"}, bulkRows(10), BulkOptions{
+		ChunkSize: 2,
+		Progress: func(rowsSoFar int64) {
+			if rowsSoFar == 4 {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if f.count("INSERT") != 2 || f.count("COMMIT") != 0 || f.count("ROLLBACK") != 1 {
+		t.Errorf("statements = %v", f.statements())
+	}
+}
+
+func TestBulkInsertJoinsOnlyItsSession(t *testing.T) {
+	otherDB, other := newFakeSession(t, "postgres")
+	f, r := newFakeSession(t, "postgres")
+	f.handle("INSERT", inserted(2))
+	err := other.Transaction(context.Background(), func(ctx context.Context) error {
+		_, err := BulkInsert(ctx, r, "users", []string{"id", "name"}, bulkRows(1), BulkOptions{})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, f, "BEGIN", "INSERT INTO users (id, name) VALUES ($1, $2)", "COMMIT")
+	wantStatements(t, otherDB, "BEGIN", "COMMIT")
+}
+
+// deleted Answer DELETE statements affecting one row per key, given as args or as one Postgres array
+func deleted(_ context.Context, args []driver.NamedValue) (*fakeResult, error) {
+	if array, ok := args[0].Value.(string); ok && strings.HasPrefix(array, "{") {
+		return &fakeResult{affected: int64(strings.Count(array, ",") + 1)}, nil
+	}
+	return &fakeResult{affected: int64(len(args))}, nil
+}
+
+func bulkKeys(n int) []interface{} {
+	keys := make([]interface{}, n)
+	for i := range keys {
+		keys[i] = int64(i + 1)
+	}
+	return keys
+}
+
+func TestBulkDeleteChunks(t *testing.T) {
+	cases := []struct {
+		dialect string
+		keys    int
+		chunks  []int
+	}{
+		{"mysql", 500, []int{500}},
+		{"mysql", 501, []int{500, 1}},
+		{"mysql", 1000, []int{500, 500}},
+		{"postgres", 1200, []int{1}},
+		{"postgres", 65536, []int{1, 1}},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s/%d", c.dialect, c.keys), func(t *testing.T) {
+			f, r := newFakeSession(t, c.dialect)
+			f.handle("DELETE", deleted)
+			n, err := BulkDelete(context.Background(), r, "sessions", "id", bulkKeys(c.keys))
+			if err != nil || n != int64(c.keys) {
+				t.Fatalf("n = %d, err = %v", n, err)
+			}
+			var chunks []int
+			for _, s := range f.log {
+				if strings.HasPrefix(s.query, "DELETE") {
+					chunks = append(chunks, len(s.args))
+				}
+			}
+			if !slices.Equal(chunks, c.chunks) {
+				t.Errorf("args per statement = %v, want %v", chunks, c.chunks)
+			}
+			if f.count("BEGIN") != 1 || f.count("COMMIT") != 1 {
+				t.Errorf("%d BEGIN, %d COMMIT, want the chunks in one transaction", f.count("BEGIN"), f.count("COMMIT"))
+			}
+		})
+	}
+}
+
+func TestBulkDeleteStatements(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			f.handle("DELETE", deleted)
+			n, err := BulkDelete(context.Background(), s, "sessions", "id", nil)
+			if err != nil || n != 0 || len(f.statements()) != 0 {
+				t.Fatalf("empty keys: n = %d, err = %v, statements = %v", n, err, f.statements())
+			}
+			err = s.Transaction(context.Background(), func(ctx context.Context) error {
+				n, err = BulkDelete(ctx, s, "sessions", "id", bulkKeys(3))
+				return err
+			})
+			if err != nil || n != 3 {
+				t.Fatalf("n = %d, err = %v", n, err)
+			}
+			query := "DELETE FROM sessions WHERE id = ANY($1)"
+			if name == "gorm" {
+				query = "DELETE FROM sessions WHERE id = ANY(?)"
+			}
+			wantStatements(t, f, "BEGIN", query, "COMMIT")
+			if got := f.argsOf("DELETE"); len(got) != 1 || got[0] != "{1,2,3}" {
+				t.Errorf("args = %v", got)
+			}
+			if _, err = BulkDelete(context.Background(), s, "sessions; DROP TABLE users", "id", bulkKeys(1)); err == nil {
+				t.Error("unsafe table name accepted")
+			}
+		})
+	}
+}
+
+func TestExecBatchedProgress(t *testing.T) {
+	for name, open := range testSessions {
+		for _, dialect := range []string{"postgres", "mysql"} {
+			t.Run(name+"/"+dialect, func(t *testing.T) {
+				f, s := open(t, dialect)
+				f.handle("UPDATE", deleted)
+				var reports []int64
+				n, err := ExecBatched(context.Background(), s, "UPDATE users SET active = false", "id", bulkKeys(1200), 500,
+					func(done int64) {
+						reports = append(reports, done)
+					})
+				if err != nil || n != 1200 {
+					t.Fatalf("n = %d, err = %v", n, err)
+				}
+				if !slices.Equal(reports, []int64{500, 1000, 1200}) {
+					t.Errorf("progress = %v", reports)
+				}
+				if f.count("UPDATE") != 3 || f.count("BEGIN") != 1 || f.count("COMMIT") != 1 {
+					t.Errorf("%d batches in %d transactions", f.count("UPDATE"), f.count("BEGIN"))
+				}
+				last := f.last("UPDATE")
+				filter, args := "WHERE id IN (", 200
+				if dialect == "postgres" {
+					filter, args = "WHERE id = ANY(", 1
+				}
+				if !strings.Contains(last.query, filter) || len(last.args) != args {
+					t.Errorf("last batch = %q with %d args", last.query, len(last.args))
+				}
+			})
+		}
+	}
+}
+
+func TestExecBatchedLimits(t *testing.T) {
+	f, r := newFakeSession(t, "mysql")
+	f.handle("DELETE", deleted)
+	if _, err := ExecBatched(context.Background(), r, "DELETE FROM users WHERE active", "id", bulkKeys(3), 2, nil); err == nil {
+		t.Error("query with a WHERE clause accepted")
+	}
+	n, err := ExecBatched(context.Background(), r, "DELETE FROM users", "id", nil, 2, func(int64) {
+		t.Error("progress reported without keys")
+	})
+	if err != nil || n != 0 || len(f.statements()) != 0 {
+		t.Fatalf("no keys: n = %d, err = %v, statements = %v", n, err, f.statements())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = r.Transaction(ctx, func(ctx context.Context) error {
+		_, err := ExecBatched(ctx, r, "DELETE FROM users", "id", bulkKeys(6), 2, func(done int64) {
+			if done == 2 {
+				cancel()
+			}
+		})
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want the cancellation", err)
+	}
+	wantStatements(t, f, "BEGIN", "DELETE FROM users WHERE id IN (?, ?)", "ROLLBACK")
+}
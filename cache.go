@@ -0,0 +1,351 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CachedRows Result set stored in a QueryCache
+type CachedRows struct {
+	// Query Statement that produced the rows, used for invalidation by table
+	Query   string
+	Columns []string
+	// Types Database type name of each column, for the decoding of the generic scanners, see WithTypeConverter
+	Types  []string
+	Values [][]driver.Value
+}
+
+// QueryCache Storage used by WithQueryCache, implementations must be safe for concurrent use
+type QueryCache interface {
+	Get(key string) (*CachedRows, bool)
+	Set(key string, rows *CachedRows, ttl time.Duration)
+	// Invalidate Drop the entries whose query reads table
+	Invalidate(table string)
+}
+
+// WithQueryCache Serve SELECT statements issued through QueryRow and QueryRows outside a transaction from cache
+//
+// Entries are keyed by query and args and live for ttl. Reads inside a transaction always go to the database
+// so a transaction sees its own writes
+func WithQueryCache(cache QueryCache, ttl time.Duration) Option {
+	return func(o *options) {
+		o.cache = cache
+		o.cacheTTL = ttl
+	}
+}
+
+// WithQueryCacheInvalidation Drop cached reads of the table written by INSERT, UPDATE, DELETE, REPLACE and
+// TRUNCATE statements run through ExecQuery, after commit for statements inside a transaction
+func WithQueryCacheInvalidation(enabled bool) Option {
+	return func(o *options) {
+		o.cacheInvalidation = enabled
+	}
+}
+
+// noCacheKey Marks the context of a query whose result must not come from the cache, see QueryMultiple
+type noCacheKey struct{}
+
+func (o *options) cacheable(ctx context.Context, st *txState, query string) bool {
+	return o.cache != nil && st == nil && isSelect(query) && ctx.Value(noCacheKey{}) == nil
+}
+
+// cachedRows Serve query from the cache, running fetch and storing its result set on a miss, the plan fetch
+// returns along with the rows is logged once they are read
+//
+// query is the one of prepareUncommented, so calls differing only by their comment tags or label share an entry
+func (o *options) cachedRows(ctx context.Context, query string, args []interface{}, fetch func() (*sql.Rows, func(), error)) (*CachedRows, error) {
+	key := cacheKey(query, args)
+	if cached, ok := o.cache.Get(key); ok {
+		return cached, nil
+	}
+	rows, explain, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	cached, err := readAll(rows)
+	if explain != nil {
+		explain()
+	}
+	if err != nil {
+		return nil, err
+	}
+	cached.Query = query
+	o.cache.Set(key, cached, o.cacheTTL)
+	return cached, nil
+}
+
+// invalidate Drop cached reads of the table written by query
+func (o *options) invalidate(st *txState, query string) {
+	if o.cache == nil || !o.cacheInvalidation {
+		return
+	}
+	table := writtenTable(query)
+	if table == "" {
+		return
+	}
+	if st == nil {
+		o.cache.Invalidate(table)
+		return
+	}
+	st.afterCommit = append(st.afterCommit, func(ctx context.Context) error {
+		o.cache.Invalidate(table)
+		return nil
+	})
+}
+
+// cacheKey Key of query run with args, which are keyed on the value the driver receives, as database/sql converts
+// them: a pointer by the value it points to and a driver.Valuer by its Value
+func cacheKey(query string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString(query)
+	for _, arg := range args {
+		b.WriteByte(0)
+		if named, ok := arg.(sql.NamedArg); ok {
+			fmt.Fprintf(&b, "@%s=", named.Name)
+			arg = named.Value
+		}
+		if v, err := driver.DefaultParameterConverter.ConvertValue(arg); err == nil {
+			arg = v
+		}
+		fmt.Fprintf(&b, "%T:%v", arg, arg)
+	}
+	return b.String()
+}
+
+var writtenTablePattern = regexp.MustCompile("(?is)^\\s*(?:INSERT\\s+(?:IGNORE\\s+)?INTO|REPLACE\\s+INTO|UPDATE|DELETE\\s+FROM|TRUNCATE(?:\\s+TABLE)?)\\s+([A-Za-z0-9_.\"`]+)")
+
+// writtenTable Table modified by a write statement, empty when it cannot be told
+func writtenTable(query string) string {
+	m := writtenTablePattern.FindStringSubmatch(query)
+	if m == nil {
+		return ""
+	}
+	return strings.NewReplacer(`"`, "", "`", "").Replace(m[1])
+}
+
+// readAll Drain rows into a CachedRows
+func readAll(rows *sql.Rows) (*CachedRows, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	cached := &CachedRows{Columns: columns, Types: make([]string, len(types))}
+	for i, t := range types {
+		cached.Types[i] = t.DatabaseTypeName()
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		targets := make([]interface{}, len(columns))
+		for i := range values {
+			targets[i] = &values[i]
+		}
+		if err = rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+		row := make([]driver.Value, len(values))
+		for i, v := range values {
+			row[i] = v
+		}
+		cached.Values = append(cached.Values, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return cached, rows.Close()
+}
+
+// MemoryQueryCache In-process QueryCache with per-entry expiry
+//
+// Set drops the expired entries each time the cache has doubled since the last sweep, so keys that are never
+// requested again hold at most as much memory as the live ones
+type MemoryQueryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+	clock   Clock
+	// sweepAt Number of entries at which Set next drops the expired ones
+	sweepAt int
+}
+
+type memoryCacheEntry struct {
+	rows    *CachedRows
+	expires time.Time
+}
+
+// NewMemoryQueryCache Create an empty in-process query cache
+func NewMemoryQueryCache() *MemoryQueryCache {
+	return NewMemoryQueryCacheWithClock(realClock{})
+}
+
+// NewMemoryQueryCacheWithClock Create an empty in-process query cache expiring entries according to clock
+func NewMemoryQueryCacheWithClock(clock Clock) *MemoryQueryCache {
+	return &MemoryQueryCache{entries: make(map[string]memoryCacheEntry), clock: clock}
+}
+
+func (c *MemoryQueryCache) Get(key string) (*CachedRows, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.clock.Now().Before(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.rows, true
+}
+
+func (c *MemoryQueryCache) Set(key string, rows *CachedRows, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	if len(c.entries) >= c.sweepAt {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.sweepAt = 2 * len(c.entries)
+		if c.sweepAt < minMemoryCacheSweep {
+			c.sweepAt = minMemoryCacheSweep
+		}
+	}
+	c.entries[key] = memoryCacheEntry{rows: rows, expires: now.Add(ttl)}
+}
+
+// minMemoryCacheSweep Size below which MemoryQueryCache.Set never sweeps
+const minMemoryCacheSweep = 64
+
+func (c *MemoryQueryCache) Invalidate(table string) {
+	pattern := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(table) + `\b`)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if pattern.MatchString(e.rows.Query) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// The replay driver turns a CachedRows back into *sql.Rows and *sql.Row: each replay registers the result
+// set under a token and queries the token on a database/sql handle backed by the driver. The driver takes the
+// token out when it serves it, a query failing before that drops it
+
+var (
+	replayOnce    sync.Once
+	replayDB      *sql.DB
+	replayTokens  sync.Map
+	replayCounter atomic.Uint64
+)
+
+func replayHandle(cached *CachedRows) (*sql.DB, string) {
+	replayOnce.Do(func() {
+		replayDB = sql.OpenDB(replayConnector{})
+	})
+	token := strconv.FormatUint(replayCounter.Add(1), 10)
+	replayTokens.Store(token, cached)
+	return replayDB, token
+}
+
+func replayRows(ctx context.Context, cached *CachedRows) (*sql.Rows, error) {
+	db, token := replayHandle(cached)
+	rows, err := db.QueryContext(ctx, token)
+	if err != nil {
+		replayTokens.Delete(token)
+	}
+	return rows, err
+}
+
+func replayRow(ctx context.Context, cached *CachedRows) *sql.Row {
+	db, token := replayHandle(cached)
+	row := db.QueryRowContext(ctx, token)
+	if row.Err() != nil {
+		replayTokens.Delete(token)
+	}
+	return row
+}
+
+var errReplayOnly = errors.New("cransaction: replay connection only serves cached rows")
+
+type replayConnector struct{}
+
+func (replayConnector) Connect(context.Context) (driver.Conn, error) {
+	return replayConn{}, nil
+}
+
+func (replayConnector) Driver() driver.Driver {
+	return replayDriver{}
+}
+
+type replayDriver struct{}
+
+func (replayDriver) Open(string) (driver.Conn, error) {
+	return replayConn{}, nil
+}
+
+type replayConn struct{}
+
+func (replayConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errReplayOnly
+}
+
+func (replayConn) Close() error {
+	return nil
+}
+
+func (replayConn) Begin() (driver.Tx, error) {
+	return nil, errReplayOnly
+}
+
+func (replayConn) QueryContext(_ context.Context, token string, _ []driver.NamedValue) (driver.Rows, error) {
+	cached, ok := replayTokens.LoadAndDelete(token)
+	if !ok {
+		return nil, errReplayOnly
+	}
+	return &replayDriverRows{rows: cached.(*CachedRows)}, nil
+}
+
+type replayDriverRows struct {
+	rows *CachedRows
+	next int
+}
+
+func (r *replayDriverRows) Columns() []string {
+	return r.rows.Columns
+}
+
+// ColumnTypeDatabaseTypeName Type name the column had in the database, empty for entries stored without types
+func (r *replayDriverRows) ColumnTypeDatabaseTypeName(index int) string {
+	if index < len(r.rows.Types) {
+		return r.rows.Types[index]
+	}
+	return ""
+}
+
+func (r *replayDriverRows) Close() error {
+	return nil
+}
+
+func (r *replayDriverRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows.Values) {
+		return io.EOF
+	}
+	copy(dest, r.rows.Values[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,249 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCachedSession(t *testing.T, open func(t *testing.T, dialect string, opts ...Option) (*fakeDB, ITransaction), opts ...Option) (*fakeDB, ITransaction, *fakeClock) {
+	t.Helper()
+	clock := newFakeClock()
+	opts = append([]Option{WithQueryCache(NewMemoryQueryCacheWithClock(clock), time.Minute)}, opts...)
+	f, s := open(t, "postgres", opts...)
+	f.on("FROM countries", rowsOf([]string{"name"}, []driver.Value{"France"}))
+	return f, s, clock
+}
+
+func countryName(t *testing.T, ctx context.Context, s ITransaction, code interface{}) {
+	t.Helper()
+	name, err := QueryValue[string](ctx, s, "SELECT name FROM countries WHERE code = $1", code)
+	if err != nil || name != "France" {
+		t.Fatalf("name = %q, err = %v", name, err)
+	}
+}
+
+func TestQueryCacheHitMissExpiry(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s, clock := newCachedSession(t, open)
+			ctx := context.Background()
+			countryName(t, ctx, s, "FR")
+			countryName(t, ctx, s, "FR")
+			if n := f.count("FROM countries"); n != 1 {
+				t.Fatalf("%d queries after a hit, want 1", n)
+			}
+			countryName(t, ctx, s, "DE")
+			if n := f.count("FROM countries"); n != 2 {
+				t.Fatalf("%d queries after a miss on other args, want 2", n)
+			}
+			clock.Advance(time.Minute)
+			countryName(t, ctx, s, "FR")
+			if n := f.count("FROM countries"); n != 3 {
+				t.Fatalf("%d queries after expiry, want 3", n)
+			}
+		})
+	}
+}
+
+func TestQueryCacheBypassedInTransaction(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s, _ := newCachedSession(t, open)
+			ctx := context.Background()
+			countryName(t, ctx, s, "FR")
+			err := s.Transaction(ctx, func(ctx context.Context) error {
+				countryName(t, ctx, s, "FR")
+				countryName(t, ctx, s, "DE")
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := f.count("FROM countries"); n != 3 {
+				t.Fatalf("%d queries, want every read of the transaction to reach the database", n)
+			}
+			countryName(t, ctx, s, "DE")
+			if n := f.count("FROM countries"); n != 4 {
+				t.Fatalf("%d queries, the transaction filled the cache", n)
+			}
+		})
+	}
+}
+
+func TestQueryCacheInvalidation(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s, _ := newCachedSession(t, open, WithQueryCacheInvalidation(true))
+			ctx := context.Background()
+			countryName(t, ctx, s, "FR")
+			if _, err := s.ExecQuery(ctx, "UPDATE countries SET name = 'France'"); err != nil {
+				t.Fatal(err)
+			}
+			countryName(t, ctx, s, "FR")
+			if n := f.count("FROM countries"); n != 2 {
+				t.Fatalf("%d queries after an autocommit write, want 2", n)
+			}
+
+			err := s.Transaction(ctx, func(ctx context.Context) error {
+				if _, err := s.ExecQuery(ctx, "UPDATE countries SET name = 'France'"); err != nil {
+					return err
+				}
+				// Invalidated on commit only
+				countryName(t, WithoutTransaction(ctx), s, "FR")
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := f.count("FROM countries"); n != 2 {
+				t.Fatalf("%d queries, the cache was dropped before commit", n)
+			}
+			countryName(t, ctx, s, "FR")
+			if n := f.count("FROM countries"); n != 3 {
+				t.Fatalf("%d queries after the commit, want 3", n)
+			}
+		})
+	}
+}
+
+func TestQueryCacheInvalidationContinueOnError(t *testing.T) {
+	sessions := map[string]func(t *testing.T) (*fakeDB, continueOnErrorSession, *fakeClock){
+		"sql": func(t *testing.T) (*fakeDB, continueOnErrorSession, *fakeClock) {
+			f, s, clock := newCachedSession(t, testSessions["sql"], WithQueryCacheInvalidation(true))
+			return f, s.(continueOnErrorSession), clock
+		},
+		"gorm": func(t *testing.T) (*fakeDB, continueOnErrorSession, *fakeClock) {
+			f, s, clock := newCachedSession(t, testSessions["gorm"], WithQueryCacheInvalidation(true))
+			return f, s.(continueOnErrorSession), clock
+		},
+	}
+	for name, open := range sessions {
+		t.Run(name, func(t *testing.T) {
+			f, s, _ := open(t)
+			ctx := context.Background()
+			countryName(t, ctx, s, "FR")
+			_, err := s.TransactionContinueOnError(ctx, func(ctx context.Context) error {
+				_, err := s.ExecQuery(ctx, "DELETE FROM countries WHERE code = 'XX'")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			countryName(t, ctx, s, "FR")
+			if n := f.count("FROM countries WHERE code = $1"); n != 2 {
+				t.Fatalf("%d reads, the write did not invalidate the cache", n)
+			}
+		})
+	}
+}
+
+func TestQueryCacheKeepsColumnTypes(t *testing.T) {
+	issued := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	decimal := func(b []byte) (interface{}, error) {
+		return testDecimal{text: string(b)}, nil
+	}
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "mysql", WithQueryCache(NewMemoryQueryCache(), time.Minute), WithTypeConverter("decimal", decimal))
+			f.on("FROM invoices", &fakeResult{
+				columns: []string{"id", "issued_at", "total"},
+				types:   []string{"BIGINT", "DATETIME", "DECIMAL"},
+				rows:    [][]driver.Value{{int64(1), []byte("2024-03-01 10:00:00"), []byte("12.50")}},
+			})
+			for _, read := range []string{"miss", "hit"} {
+				got, err := QueryRowStruct[testInvoice](context.Background(), s, "SELECT * FROM invoices")
+				if err != nil {
+					t.Fatalf("%s: %v", read, err)
+				}
+				if !got.IssuedAt.Equal(issued) || got.Total.text != "12.50" {
+					t.Errorf("%s: got %+v", read, got)
+				}
+			}
+			if n := f.count("FROM invoices"); n != 1 {
+				t.Errorf("%d queries, want the second read served from cache", n)
+			}
+		})
+	}
+}
+
+func TestReplayTokenDroppedOnError(t *testing.T) {
+	tokens := func() (n int) {
+		replayTokens.Range(func(_, _ interface{}) bool {
+			n++
+			return true
+		})
+		return n
+	}
+	before := tokens()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cached := &CachedRows{Columns: []string{"name"}, Values: [][]driver.Value{{"France"}}}
+	if _, err := replayRows(ctx, cached); err == nil {
+		t.Fatal("rows replayed under a cancelled context")
+	}
+	if err := replayRow(ctx, cached).Err(); err == nil {
+		t.Fatal("row replayed under a cancelled context")
+	}
+	if n := tokens(); n != before {
+		t.Errorf("%d replay tokens left behind", n-before)
+	}
+}
+
+// testCode driver.Valuer sending its code upper-cased
+type testCode string
+
+func (c testCode) Value() (driver.Value, error) {
+	return strings.ToUpper(string(c)), nil
+}
+
+func TestQueryCacheKeyedOnArgValues(t *testing.T) {
+	f, s, _ := newCachedSession(t, testSessions["sql"])
+	ctx := context.Background()
+	code := "FR"
+	countryName(t, ctx, s, &code)
+	code = "DE"
+	countryName(t, ctx, s, &code)
+	if n := f.count("FROM countries"); n != 2 {
+		t.Fatalf("%d queries, the pointer to a new value hit the entry of the old one", n)
+	}
+	other := "DE"
+	countryName(t, ctx, s, &other)
+	countryName(t, ctx, s, "DE")
+	countryName(t, ctx, s, testCode("de"))
+	if n := f.count("FROM countries"); n != 2 {
+		t.Errorf("%d queries, args sending the same value missed the cache", n)
+	}
+}
+
+func TestQueryCacheKeyIgnoresComments(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s, _ := newCachedSession(t, open, WithSQLCommenter(true))
+			countryName(t, WithCommentTag(context.Background(), "request_id", "1"), s, "FR")
+			countryName(t, WithCommentTag(context.Background(), "request_id", "2"), s, "FR")
+			countryName(t, WithQueryLabel(context.Background(), "report"), s, "FR")
+			if n := f.count("FROM countries"); n != 1 {
+				t.Errorf("%d queries, calls differing by their comments missed the cache", n)
+			}
+			if q := f.last("FROM countries").query; !strings.Contains(q, "request_id='1'") {
+				t.Errorf("query sent = %q, want its comment", q)
+			}
+		})
+	}
+}
+
+func TestMemoryQueryCacheSetDropsExpired(t *testing.T) {
+	clock := newFakeClock()
+	c := NewMemoryQueryCacheWithClock(clock)
+	for i := 0; i < 10*minMemoryCacheSweep; i++ {
+		c.Set(fmt.Sprint(i), &CachedRows{}, time.Second)
+		clock.Advance(time.Second)
+	}
+	if n := len(c.entries); n > minMemoryCacheSweep {
+		t.Errorf("%d entries held, want expired ones dropped", n)
+	}
+}
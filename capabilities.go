@@ -0,0 +1,51 @@
+package cransaction
+
+// DriverCapabilities Features of the database behind a session, for portable code branching on them
+type DriverCapabilities struct {
+	// SupportsReturning INSERT, UPDATE and DELETE accept a RETURNING clause
+	SupportsReturning bool
+	// SupportsLastInsertID sql.Result.LastInsertId reports generated keys
+	SupportsLastInsertID bool
+	SupportsSavepoints   bool
+	// SupportsCopy Bulk loads can use COPY FROM STDIN
+	SupportsCopy bool
+	// SupportsAdvisoryLocks Application-defined locks, pg_advisory_lock on Postgres and GET_LOCK on MySQL
+	SupportsAdvisoryLocks bool
+}
+
+// Capabilities Features of the session's database, inferred from its driver name
+func (r *RDMSSession) Capabilities() DriverCapabilities {
+	return capabilitiesOf(r.dialect())
+}
+
+// Capabilities Features of the session's database, inferred from its Gorm dialector name
+func (g *GormSession) Capabilities() DriverCapabilities {
+	return capabilitiesOf(g.dialect())
+}
+
+// capabilitiesOf Known features per dialect, a dialect that is not listed reports none
+func capabilitiesOf(dialect string) DriverCapabilities {
+	switch dialect {
+	case "postgres", "pgx":
+		return DriverCapabilities{
+			SupportsReturning:     true,
+			SupportsSavepoints:    true,
+			SupportsCopy:          true,
+			SupportsAdvisoryLocks: true,
+		}
+	case "mysql":
+		return DriverCapabilities{
+			SupportsLastInsertID:  true,
+			SupportsSavepoints:    true,
+			SupportsAdvisoryLocks: true,
+		}
+	case "sqlite", "sqlite3":
+		return DriverCapabilities{
+			// Since SQLite 3.35
+			SupportsReturning:    true,
+			SupportsLastInsertID: true,
+			SupportsSavepoints:   true,
+		}
+	}
+	return DriverCapabilities{}
+}
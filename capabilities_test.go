@@ -0,0 +1,31 @@
+package cransaction
+
+import "testing"
+
+func TestCapabilities(t *testing.T) {
+	cases := []struct {
+		dialect string
+		want    DriverCapabilities
+	}{
+		{"postgres", DriverCapabilities{SupportsReturning: true, SupportsSavepoints: true, SupportsCopy: true, SupportsAdvisoryLocks: true}},
+		{"mysql", DriverCapabilities{SupportsLastInsertID: true, SupportsSavepoints: true, SupportsAdvisoryLocks: true}},
+		{"sqlite", DriverCapabilities{SupportsReturning: true, SupportsLastInsertID: true, SupportsSavepoints: true}},
+		{"clickhouse", DriverCapabilities{}},
+	}
+	for _, c := range cases {
+		t.Run(c.dialect, func(t *testing.T) {
+			// Gorm sessions read the dialect from the dialector, which any name can be given
+			_, g := newFakeGorm(t, c.dialect)
+			if got := g.Capabilities(); got != c.want {
+				t.Errorf("gorm: got %+v, want %+v", got, c.want)
+			}
+			if c.dialect != "postgres" && c.dialect != "mysql" {
+				return
+			}
+			_, r := newFakeSession(t, c.dialect)
+			if got := r.Capabilities(); got != c.want {
+				t.Errorf("sql: got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
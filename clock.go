@@ -0,0 +1,91 @@
+package cransaction
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock Source of time for the timing-dependent features: retry backoff, reconnect delay, durations, slow
+// queries, query timeouts and transaction expiry
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock Clock backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// WithClock Read time from c instead of the system clock, meant for tests driving backoff with a fake clock
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+func (o *options) now() time.Time {
+	if o.clock == nil {
+		return time.Now()
+	}
+	return o.clock.Now()
+}
+
+func (o *options) since(t time.Time) time.Duration {
+	return o.now().Sub(t)
+}
+
+func (o *options) after(d time.Duration) <-chan time.Time {
+	if o.clock == nil {
+		return time.After(d)
+	}
+	return o.clock.After(d)
+}
+
+// afterFunc Call f in its own goroutine once d elapsed on the session clock, the returned stop prevents the
+// call and reports false when f was already called
+func (o *options) afterFunc(d time.Duration, f func()) (stop func() bool) {
+	if o.clock == nil {
+		return time.AfterFunc(d, f).Stop
+	}
+	var state atomic.Int32
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-o.clock.After(d):
+			if state.CompareAndSwap(0, 1) {
+				f()
+			}
+		case <-stopped:
+		}
+	}()
+	return func() bool {
+		if !state.CompareAndSwap(0, 2) {
+			return false
+		}
+		close(stopped)
+		return true
+	}
+}
+
+// fromWall Time of the session clock at the wall clock time t, such as a context deadline
+func (o *options) fromWall(t time.Time) time.Time {
+	if o.clock == nil {
+		return t
+	}
+	return o.now().Add(time.Until(t))
+}
+
+// toWall Wall clock time at the time t of the session clock, to set a context deadline
+func (o *options) toWall(t time.Time) time.Time {
+	if o.clock == nil {
+		return t
+	}
+	return time.Now().Add(t.Sub(o.now()))
+}
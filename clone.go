@@ -0,0 +1,57 @@
+package cransaction
+
+import (
+	"database/sql"
+	"maps"
+	"slices"
+)
+
+// WithTxOptions Begin transactions with txOptions instead of the TxOptions given to NewSession, mostly useful
+// with Clone to derive for example a read-only session
+func WithTxOptions(txOptions *sql.TxOptions) Option {
+	return func(o *options) {
+		o.txOptions = txOptions
+	}
+}
+
+// Clone Return a session on the same *sql.DB with opts applied over the options of r, r is left unchanged
+//
+// Both sessions share the base handle, so a Transaction of the clone nested in a transaction of r runs in a
+// savepoint of it
+func (r *RDMSSession) Clone(opts ...Option) ITransaction {
+	o := r.opts.clone(opts)
+	return &RDMSSession{db: r.db, driver: r.driver, txOptions: o.txOptionsOr(r.txOptions), opts: o}
+}
+
+// Clone Return a session on the same *gorm.DB with opts applied over the options of g, g is left unchanged
+//
+// Both sessions share the base handle, so a Transaction of the clone nested in a transaction of g runs in a
+// savepoint of it
+func (g *GormSession) Clone(opts ...Option) ITransaction {
+	o := g.opts.clone(opts)
+	return &GormSession{db: g.db, txOptions: o.txOptionsOr(g.txOptions), opts: o}
+}
+
+// clone Copy of o with opts applied, the slices and maps are copied so appending options leave o untouched
+func (o *options) clone(opts []Option) options {
+	c := *o
+	c.rewriters = slices.Clone(o.rewriters)
+	c.beginHooks = slices.Clone(o.beginHooks)
+	c.afterBeginHooks = slices.Clone(o.afterBeginHooks)
+	c.beforeQueryHooks = slices.Clone(o.beforeQueryHooks)
+	c.afterQueryHooks = slices.Clone(o.afterQueryHooks)
+	c.typeConverters = maps.Clone(o.typeConverters)
+	c.valueConverters = slices.Clone(o.valueConverters)
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// txOptionsOr TxOptions set with WithTxOptions, fallback when there are none
+func (o *options) txOptionsOr(fallback *sql.TxOptions) *sql.TxOptions {
+	if o.txOptions != nil {
+		return o.txOptions
+	}
+	return fallback
+}
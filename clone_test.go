@@ -0,0 +1,66 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// cloner Session offering Clone, both backends do
+type cloner interface {
+	Clone(opts ...Option) ITransaction
+}
+
+func TestCloneReadOnly(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres", WithQueryRewriter(func(_ context.Context, query string) (string, error) {
+				return strings.ToUpper(query), nil
+			}))
+			readOnly := s.(cloner).Clone(
+				WithTxOptions(&sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true}),
+				WithQueryRewriter(func(_ context.Context, query string) (string, error) {
+					return query + " -- replica", nil
+				}))
+			run := func(tx ITransaction) {
+				t.Helper()
+				err := tx.Transaction(context.Background(), func(ctx context.Context) error {
+					_, err := tx.ExecQuery(ctx, "update users set name = 'ada'")
+					return err
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			run(readOnly)
+			run(s)
+			if len(f.txOpts) != 2 {
+				t.Fatalf("%d transactions, want 2", len(f.txOpts))
+			}
+			if opts := f.txOpts[0]; !opts.ReadOnly || sql.IsolationLevel(opts.Isolation) != sql.LevelSerializable {
+				t.Errorf("clone began with %+v, want read-only serializable", opts)
+			}
+			if opts := f.txOpts[1]; opts.ReadOnly || opts.Isolation != 0 {
+				t.Errorf("original began with %+v, want it writable", opts)
+			}
+			wantStatements(t, f, "BEGIN", "UPDATE USERS SET NAME = 'ADA' -- replica", "COMMIT",
+				"BEGIN", "UPDATE USERS SET NAME = 'ADA'", "COMMIT")
+		})
+	}
+}
+
+func TestCloneNestsInOriginal(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	clone := r.Clone()
+	err := r.Transaction(context.Background(), func(ctx context.Context) error {
+		return clone.Transaction(ctx, func(ctx context.Context) error {
+			_, err := clone.ExecQuery(ctx, "DELETE FROM sessions")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, f, "BEGIN", "SAVEPOINT sp_2", "DELETE FROM sessions", "RELEASE SAVEPOINT sp_2", "COMMIT")
+}
@@ -0,0 +1,88 @@
+package cransaction
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type commentTagsKey struct{}
+
+// WithCommentTag Return a context whose queries are tagged with key=value when the SQL commenter is enabled
+//
+// Typical keys are request_id, controller and action
+func WithCommentTag(ctx context.Context, key, value string) context.Context {
+	parent, _ := ctx.Value(commentTagsKey{}).(map[string]string)
+	tags := make(map[string]string, len(parent)+1)
+	for k, v := range parent {
+		tags[k] = v
+	}
+	tags[key] = value
+	return context.WithValue(ctx, commentTagsKey{}, tags)
+}
+
+// WithSQLCommenter Append the context comment tags to each query as a sqlcommenter comment
+//
+// Keys are sorted and both keys and values are URL-encoded, so tags can never terminate the comment
+func WithSQLCommenter(enabled bool) Option {
+	return func(o *options) {
+		o.sqlCommenter = enabled
+	}
+}
+
+// appendComment Add the tags of ctx to query in the sqlcommenter format, /*key='value',...*/
+func appendComment(ctx context.Context, query string) string {
+	tags, _ := ctx.Value(commentTagsKey{}).(map[string]string)
+	if len(tags) == 0 || strings.Contains(query, "/*") {
+		return query
+	}
+	return appendTags(query, tags)
+}
+
+// appendTags Add tags to query as a sqlcommenter comment, before a trailing semicolon
+func appendTags(query string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = url.PathEscape(k) + "='" + url.PathEscape(tags[k]) + "'"
+	}
+	trimmed := strings.TrimRight(query, " \t\r\n")
+	suffix := ""
+	if strings.HasSuffix(trimmed, ";") {
+		trimmed, suffix = strings.TrimSuffix(trimmed, ";"), ";"
+	}
+	return trimmed + " /*" + strings.Join(pairs, ",") + "*/" + suffix
+}
+
+type queryLabelKey struct{}
+
+// WithQueryLabel Return a context whose statements carry label in a trailing comment, whatever the commenter
+// setting, so they can be picked out in pg_stat_statements or the slow query log
+//
+// Characters other than letters, digits and _ . : - are replaced with _, so a label can never end the comment
+func WithQueryLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, queryLabelKey{}, sanitizeLabel(label))
+}
+
+func sanitizeLabel(label string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_.:-", r) {
+			return r
+		}
+		return '_'
+	}, label)
+}
+
+// appendLabel Add the label of ctx to query
+func appendLabel(ctx context.Context, query string) string {
+	label, _ := ctx.Value(queryLabelKey{}).(string)
+	if label == "" {
+		return query
+	}
+	return appendTags(query, map[string]string{"label": label})
+}
@@ -0,0 +1,52 @@
+package cransaction
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSQLCommenter(t *testing.T) {
+	f, r := newFakeSession(t, "postgres", WithSQLCommenter(true))
+	ctx := WithCommentTag(context.Background(), "request_id", "r-1")
+	ctx = WithCommentTag(ctx, "controller", "users'*/ DROP TABLE users; --")
+	if _, err := r.ExecQuery(ctx, "UPDATE users SET name = $1;", "ada"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ExecQuery(context.Background(), "DELETE FROM sessions"); err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, f,
+		"UPDATE users SET name = $1 /*controller='users%27%2A%2F%20DROP%20TABLE%20users%3B%20--',request_id='r-1'*/;",
+		"DELETE FROM sessions")
+}
+
+func TestQueryLabel(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			ctx := WithQueryLabel(context.Background(), "nightly:report-v2")
+			if _, err := s.ExecQuery(ctx, "UPDATE reports SET done = true;"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.QueryRows(WithQueryLabel(ctx, "x'*/ DROP TABLE users; --"), "SELECT * FROM reports"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.ExecQuery(context.Background(), "DELETE FROM reports"); err != nil {
+				t.Fatal(err)
+			}
+			wantStatements(t, f,
+				"UPDATE reports SET done = true /*label='nightly:report-v2'*/;",
+				"SELECT * FROM reports /*label='x____DROP_TABLE_users__--'*/",
+				"DELETE FROM reports")
+		})
+	}
+}
+
+func TestQueryLabelWithCommenter(t *testing.T) {
+	f, r := newFakeSession(t, "postgres", WithSQLCommenter(true))
+	ctx := WithQueryLabel(WithCommentTag(context.Background(), "route", "/reports"), "export")
+	if _, err := r.ExecQuery(ctx, "DELETE FROM reports"); err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, f, "DELETE FROM reports /*route='%2Freports'*/ /*label='export'*/")
+}
@@ -0,0 +1,88 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+
+	"gorm.io/gorm"
+)
+
+type pinnedConnKey struct{}
+
+// pinnedConn Dedicated connection handed to the fn of WithFreshConn, parent is the one pinned further out
+type pinnedConn struct {
+	// owner Base handle of the session the connection was taken from
+	owner  interface{}
+	conn   interface{}
+	parent *pinnedConn
+}
+
+// pinnedFor Innermost connection in ctx pinned from owner, nil when there is none
+func pinnedFor(ctx context.Context, owner interface{}) interface{} {
+	p, _ := ctx.Value(pinnedConnKey{}).(*pinnedConn)
+	for ; p != nil; p = p.parent {
+		if p.owner == owner {
+			return p.conn
+		}
+	}
+	return nil
+}
+
+// withPinned Return a context without transaction whose statements on the session of owner run on conn
+func withPinned(ctx context.Context, owner, conn interface{}) context.Context {
+	parent, _ := ctx.Value(pinnedConnKey{}).(*pinnedConn)
+	return context.WithValue(WithoutTransaction(ctx), pinnedConnKey{}, &pinnedConn{owner: owner, conn: conn, parent: parent})
+}
+
+// WithFreshConn Run fn on a connection of its own taken from the pool, released once fn returns
+//
+// The context given to fn carries no transaction, so its statements never join a transaction open in ctx.
+// Statements and transactions of the session started with it run on the dedicated connection
+func (r *RDMSSession) WithFreshConn(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return fn(withPinned(ctx, r.db, conn))
+}
+
+// WithFreshConn Run fn on a connection of its own taken from the pool, released once fn returns
+//
+// The context given to fn carries no transaction, so its statements never join a transaction open in ctx.
+// Statements and transactions of the session started with it run on the dedicated connection
+func (g *GormSession) WithFreshConn(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		return fn(withPinned(ctx, g.db, conn))
+	})
+}
+
+// sqlBeginner Handle a database/sql transaction can begin on, satisfied by *sql.DB and *sql.Conn
+type sqlBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// handle Connection pinned in ctx by WithFreshConn, the pool otherwise
+func (r *RDMSSession) handle(ctx context.Context) interface {
+	sqlConn
+	sqlBeginner
+} {
+	if conn, ok := pinnedFor(ctx, r.db).(*sql.Conn); ok {
+		return conn
+	}
+	return r.db
+}
+
+// handle Connection pinned in ctx by WithFreshConn, db otherwise
+func (g *GormSession) handle(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if conn, ok := pinnedFor(ctx, g.db).(*gorm.DB); ok {
+		return conn.WithContext(ctx)
+	}
+	return db
+}
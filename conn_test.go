@@ -0,0 +1,58 @@
+package cransaction
+
+import (
+	"context"
+	"testing"
+)
+
+// freshConnSession Session offering WithFreshConn, both backends do
+type freshConnSession interface {
+	ITransaction
+	WithFreshConn(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+func TestWithFreshConnIsIndependentOfOuterTransaction(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			s := tx.(freshConnSession)
+			err := s.Transaction(context.Background(), func(ctx context.Context) error {
+				if _, err := s.ExecQuery(ctx, "UPDATE jobs SET state = 'running'"); err != nil {
+					return err
+				}
+				err := s.WithFreshConn(ctx, func(ctx context.Context) error {
+					if TransactionDepth(ctx) != 0 {
+						t.Error("fn sees the outer transaction")
+					}
+					if _, err := s.ExecQuery(ctx, "DELETE FROM sessions WHERE expired"); err != nil {
+						return err
+					}
+					// A transaction begun in fn is a new one on the dedicated connection, not a savepoint
+					return s.Transaction(ctx, func(ctx context.Context) error {
+						_, err := s.ExecQuery(ctx, "INSERT INTO housekeeping VALUES (now())")
+						return err
+					})
+				})
+				if err != nil {
+					return err
+				}
+				return errFake
+			})
+			if err == nil {
+				t.Fatal("expected the outer transaction to fail")
+			}
+			if f.last("DELETE FROM sessions").inTx {
+				t.Error("the fresh connection query joined a transaction")
+			}
+			if !f.last("INSERT INTO housekeeping").inTx {
+				t.Error("the transaction of fn did not run in a transaction")
+			}
+			if f.count("SAVEPOINT") != 0 || f.count("BEGIN") != 2 || f.count("COMMIT") != 1 || f.count("ROLLBACK") != 1 {
+				t.Errorf("statements = %v", f.statements())
+			}
+			if f.conns != 2 {
+				t.Errorf("%d connections opened, want one for the transaction and one fresh", f.conns)
+			}
+		})
+	}
+}
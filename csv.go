@@ -0,0 +1,86 @@
+package cransaction
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// CSVFormat Tune how QueryRowsCSV renders values
+type CSVFormat struct {
+	// Null Text written for NULL, empty when unset
+	Null string
+	// TimeLayout Layout of time values, time.RFC3339Nano when empty
+	TimeLayout string
+}
+
+// QueryRowsCSV Run query and stream its result set to w as CSV, a header row of the column names first
+//
+// Rows are written as they are read, so the whole result set is never held in memory. The rows are closed
+// before QueryRowsCSV returns, also when writing to w fails
+func QueryRowsCSV(ctx context.Context, tx ITransaction, w io.Writer, format CSVFormat, query string, args ...interface{}) error {
+	rows, err := queryRows(ctx, tx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err = cw.Write(columns); err != nil {
+		return err
+	}
+	values := make([]interface{}, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+	for rows.Next() {
+		if err = rows.Scan(targets...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			record[i] = format.value(v)
+		}
+		if err = cw.Write(record); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// value Render a value scanned from the driver
+func (f CSVFormat) value(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return f.Null
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case time.Time:
+		layout := f.TimeLayout
+		if layout == "" {
+			layout = time.RFC3339Nano
+		}
+		return v.Format(layout)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return fmt.Sprint(v)
+}
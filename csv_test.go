@@ -0,0 +1,65 @@
+package cransaction
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingWriter Writer failing every write with err
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+func TestQueryRowsCSV(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			f.on("users", rowsOf([]string{"id", "name", "score", "joined", "note"},
+				[]driver.Value{int64(1), "ada", 9.5, at, []byte(`says "hi", twice`)},
+				[]driver.Value{int64(2), "grace", nil, at.Add(time.Hour), nil}))
+			var buf bytes.Buffer
+			err := QueryRowsCSV(context.Background(), tx, &buf, CSVFormat{Null: `\N`, TimeLayout: time.DateTime},
+				"SELECT * FROM users WHERE id > $1", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "id,name,score,joined,note\n" +
+				"1,ada,9.5,2024-03-01 12:30:00,\"says \"\"hi\"\", twice\"\n" +
+				"2,grace,\\N,2024-03-01 13:30:00,\\N\n"
+			if buf.String() != want {
+				t.Errorf("csv =\n%s\nwant\n%s", buf.String(), want)
+			}
+		})
+	}
+}
+
+func TestQueryRowsCSVDefaultsAndErrors(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	f.on("events", rowsOf([]string{"at", "payload"}, []driver.Value{time.Date(2024, 3, 1, 0, 0, 0, 5, time.UTC), nil}))
+	var buf bytes.Buffer
+	if err := QueryRowsCSV(context.Background(), r, &buf, CSVFormat{}, "SELECT * FROM events"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "at,payload\n2024-03-01T00:00:00.000000005Z,\n"; buf.String() != want {
+		t.Errorf("csv = %q, want %q", buf.String(), want)
+	}
+
+	errDisk := errors.New("disk full")
+	if err := QueryRowsCSV(context.Background(), r, failingWriter{errDisk}, CSVFormat{}, "SELECT * FROM events"); !errors.Is(err, errDisk) {
+		t.Errorf("err = %v, want the write error", err)
+	}
+	f.fail("broken", errFake)
+	buf.Reset()
+	if err := QueryRowsCSV(context.Background(), r, &buf, CSVFormat{}, "SELECT * FROM broken"); !errors.Is(err, errFake) || buf.Len() != 0 {
+		t.Errorf("err = %v, wrote %q", err, buf.String())
+	}
+}
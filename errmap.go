@@ -0,0 +1,43 @@
+package cransaction
+
+// WithErrorMapper Translate every error returned by Transaction, ExecQuery, QueryRow and QueryRows with mapper,
+// for example a unique violation into a domain error
+//
+// mapper is never called with a nil error. It may see an error it already mapped, such as a statement error
+// that fn returned to Transaction, so it should return the errors it does not recognize unchanged
+func WithErrorMapper(mapper func(err error) error) Option {
+	return func(o *options) {
+		o.errorMapper = mapper
+	}
+}
+
+// mapError Replace *err with its mapped form, meant to be deferred
+func (o *options) mapError(err *error) {
+	if o.errorMapper != nil && *err != nil {
+		*err = o.errorMapper(*err)
+	}
+}
+
+// mapRow Wrap the row returned by QueryRow so that its errors are mapped
+func (o *options) mapRow(row interface{}) interface{} {
+	if o.errorMapper == nil {
+		return row
+	}
+	return &mappedRow{row: row.(Row), o: o}
+}
+
+// mappedRow Row whose Scan and Err errors go through the error mapper
+type mappedRow struct {
+	row Row
+	o   *options
+}
+
+func (m *mappedRow) Scan(dest ...interface{}) (err error) {
+	defer m.o.mapError(&err)
+	return m.row.Scan(dest...)
+}
+
+func (m *mappedRow) Err() (err error) {
+	defer m.o.mapError(&err)
+	return m.row.Err()
+}
@@ -0,0 +1,69 @@
+package cransaction
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// testDuplicateError Domain error a mapper turns unique violations into
+type testDuplicateError struct {
+	cause error
+}
+
+func (e *testDuplicateError) Error() string {
+	return "duplicate: " + e.cause.Error()
+}
+
+func TestErrorMapper(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			var nilCalls int
+			mapper := func(err error) error {
+				if err == nil {
+					nilCalls++
+				}
+				var dup *testDuplicateError
+				if IsUniqueViolation(err) && !errors.As(err, &dup) {
+					return &testDuplicateError{cause: err}
+				}
+				return err
+			}
+			f, tx := open(t, "postgres", WithErrorMapper(mapper))
+			f.fail("users", &pgError{"23505"})
+			ctx := context.Background()
+			var dup *testDuplicateError
+
+			if _, err := tx.ExecQuery(ctx, "INSERT INTO users VALUES (1)"); !errors.As(err, &dup) {
+				t.Errorf("ExecQuery err = %v", err)
+			}
+			var n int
+			if err := tx.QueryRow(ctx, "SELECT id FROM users").(Row).Scan(&n); !errors.As(err, &dup) {
+				t.Errorf("QueryRow err = %v", err)
+			}
+			if _, err := tx.QueryRows(ctx, "SELECT id FROM users"); !errors.As(err, &dup) {
+				t.Errorf("QueryRows err = %v", err)
+			}
+			err := tx.Transaction(ctx, func(ctx context.Context) error {
+				_, err := tx.ExecQuery(ctx, "INSERT INTO users VALUES (1)")
+				return err
+			})
+			if !errors.As(err, &dup) {
+				t.Errorf("Transaction err = %v", err)
+			}
+			if errors.As(dup.cause, new(*testDuplicateError)) {
+				t.Errorf("error mapped twice: %v", err)
+			}
+
+			if _, err := tx.ExecQuery(ctx, "UPDATE orders SET paid = true"); err != nil {
+				t.Errorf("successful ExecQuery err = %v", err)
+			}
+			if err := tx.Transaction(ctx, func(ctx context.Context) error { return nil }); err != nil {
+				t.Errorf("successful Transaction err = %v", err)
+			}
+			if nilCalls != 0 {
+				t.Errorf("mapper called %d times with nil", nilCalls)
+			}
+		})
+	}
+}
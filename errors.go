@@ -0,0 +1,214 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrNoActiveTransaction Returned by helpers that must run inside a transaction when the context has none
+var ErrNoActiveTransaction = errors.New("cransaction: no active transaction")
+
+// ErrBeginFailed Wraps errors raised while starting a transaction, before fn runs
+var ErrBeginFailed = errors.New("cransaction: begin transaction failed")
+
+// ErrCommitFailed Wraps errors raised by the final commit, after fn succeeded
+var ErrCommitFailed = errors.New("cransaction: commit transaction failed")
+
+// ErrTransactionExpired Returned for a transaction rolled back by WithMaxTransactionDuration
+var ErrTransactionExpired = errors.New("cransaction: transaction expired")
+
+// ErrVersionConflict Returned by UpdateWithVersion when the row is gone or no longer at the expected version
+var ErrVersionConflict = errors.New("cransaction: version conflict")
+
+// ErrLocked Returned by SelectForUpdateNoWait when a row it would lock is locked by another transaction
+var ErrLocked = errors.New("cransaction: row is locked")
+
+func beginFailed(err error) error {
+	return fmt.Errorf("%w: %w", ErrBeginFailed, err)
+}
+
+// sqlStater Postgres error exposing its SQLSTATE, implemented by lib/pq and pgx errors
+type sqlStater interface {
+	SQLState() string
+}
+
+// IsUniqueViolation Report whether err is a unique constraint violation, also through wrapped errors
+func IsUniqueViolation(err error) bool {
+	return classify(err, ErrorClassifier.IsUniqueViolation)
+}
+
+// IsForeignKeyViolation Report whether err is a foreign key constraint violation, also through wrapped errors
+func IsForeignKeyViolation(err error) bool {
+	return classify(err, ErrorClassifier.IsForeignKeyViolation)
+}
+
+// IsNotNullViolation Report whether err is a not-null constraint violation, also through wrapped errors
+func IsNotNullViolation(err error) bool {
+	return classify(err, ErrorClassifier.IsNotNullViolation)
+}
+
+// IsSerializationFailure Report whether err is a serialization failure or a deadlock, a transaction that failed
+// with it may succeed when run again
+func IsSerializationFailure(err error) bool {
+	return classify(err, ErrorClassifier.IsRetryable)
+}
+
+// ErrorClassifier Recognize the errors of one driver, consulted by the Is functions and the features built on
+// them, such as TransactionWithRetry and the default RetryPolicy
+type ErrorClassifier interface {
+	// IsRetryable A transaction that failed with err, such as on a serialization failure or a deadlock, may
+	// succeed when run again
+	IsRetryable(err error) bool
+	IsUniqueViolation(err error) bool
+	IsForeignKeyViolation(err error) bool
+	IsNotNullViolation(err error) bool
+	// IsConnectionError err is a connection-level failure specific to the driver, the network errors and
+	// driver.ErrBadConn are already recognized
+	IsConnectionError(err error) bool
+}
+
+var (
+	classifiersMu sync.RWMutex
+	classifiers   = map[string]ErrorClassifier{
+		"postgres": sqlStateClassifier{},
+		"mysql":    mysqlClassifier{},
+	}
+)
+
+// RegisterErrorClassifier Recognize the errors of driver with c, replacing the classifier already registered
+// under that name, such as the built-in postgres and mysql ones
+//
+// An error does not tell which driver raised it, so every registered classifier is consulted and err matches
+// when one of them reports it
+func RegisterErrorClassifier(driver string, c ErrorClassifier) {
+	classifiersMu.Lock()
+	defer classifiersMu.Unlock()
+	if c == nil {
+		delete(classifiers, driver)
+		return
+	}
+	classifiers[driver] = c
+}
+
+// classify Report whether one of the registered classifiers matches err with is
+func classify(err error, is func(ErrorClassifier, error) bool) bool {
+	if err == nil {
+		return false
+	}
+	classifiersMu.RLock()
+	defer classifiersMu.RUnlock()
+	for _, c := range classifiers {
+		if is(c, err) {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlStateClassifier Built-in classifier of the errors reporting a SQLSTATE, as the Postgres drivers do
+type sqlStateClassifier struct{}
+
+func (sqlStateClassifier) IsRetryable(err error) bool {
+	return hasSQLState(err, "40001", "40P01")
+}
+
+func (sqlStateClassifier) IsUniqueViolation(err error) bool {
+	return hasSQLState(err, "23505")
+}
+
+func (sqlStateClassifier) IsForeignKeyViolation(err error) bool {
+	return hasSQLState(err, "23503")
+}
+
+func (sqlStateClassifier) IsNotNullViolation(err error) bool {
+	return hasSQLState(err, "23502")
+}
+
+// IsConnectionError Class 08 is connection exception, 57P01 to 57P03 cover server shutdown and startup
+func (sqlStateClassifier) IsConnectionError(err error) bool {
+	var e sqlStater
+	if !errors.As(err, &e) {
+		return false
+	}
+	state := e.SQLState()
+	return strings.HasPrefix(state, "08") || state == "57P01" || state == "57P02" || state == "57P03"
+}
+
+// mysqlClassifier Built-in classifier of the errors of go-sql-driver/mysql
+type mysqlClassifier struct{}
+
+func (mysqlClassifier) IsRetryable(err error) bool {
+	return hasMySQLNumber(err, 1213)
+}
+
+func (mysqlClassifier) IsUniqueViolation(err error) bool {
+	return hasMySQLNumber(err, 1062, 1586)
+}
+
+func (mysqlClassifier) IsForeignKeyViolation(err error) bool {
+	return hasMySQLNumber(err, 1216, 1217, 1451, 1452)
+}
+
+func (mysqlClassifier) IsNotNullViolation(err error) bool {
+	return hasMySQLNumber(err, 1048, 1364)
+}
+
+func (mysqlClassifier) IsConnectionError(err error) bool {
+	return errors.Is(err, mysql.ErrInvalidConn)
+}
+
+// The built-in classifiers only rely on the errors, never on the driver behind the *sql.DB: they look through
+// every wrapper implementing Unwrap, such as the ones added by driver-level middleware, for an error reporting
+// a SQLSTATE through SQLState, as lib/pq and pgx do, or for a *mysql.MySQLError
+
+func hasSQLState(err error, states ...string) bool {
+	var e sqlStater
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, state := range states {
+		if e.SQLState() == state {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMySQLNumber(err error, numbers ...uint16) bool {
+	var e *mysql.MySQLError
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, number := range numbers {
+		if e.Number == number {
+			return true
+		}
+	}
+	return false
+}
+
+// IsConnectionError Report whether err is a connection-level failure, such as a connection dropped by a failover
+func IsConnectionError(err error) bool {
+	// Context errors satisfy net.Error but retrying them is pointless
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return classify(err, ErrorClassifier.IsConnectionError)
+}
@@ -0,0 +1,220 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// pgError Error reporting a SQLSTATE, as the Postgres drivers do
+type pgError struct {
+	state string
+}
+
+func (e *pgError) Error() string {
+	return "pq: error " + e.state
+}
+
+func (e *pgError) SQLState() string {
+	return e.state
+}
+
+func TestConstraintViolations(t *testing.T) {
+	cases := []struct {
+		driver string
+		err    error
+		is     func(error) bool
+	}{
+		{"postgres", &pgError{"23505"}, IsUniqueViolation},
+		{"postgres", &pgError{"23503"}, IsForeignKeyViolation},
+		{"postgres", &pgError{"23502"}, IsNotNullViolation},
+		{"mysql", &mysql.MySQLError{Number: 1062}, IsUniqueViolation},
+		{"mysql", &mysql.MySQLError{Number: 1452}, IsForeignKeyViolation},
+		{"mysql", &mysql.MySQLError{Number: 1048}, IsNotNullViolation},
+	}
+	all := []func(error) bool{IsUniqueViolation, IsForeignKeyViolation, IsNotNullViolation}
+	for _, c := range cases {
+		t.Run(c.err.Error(), func(t *testing.T) {
+			f, r := newFakeSession(t, c.driver)
+			f.fail("INSERT", c.err)
+			err := r.Transaction(context.Background(), func(ctx context.Context) error {
+				_, err := r.ExecQuery(ctx, "INSERT INTO users VALUES (1)")
+				return err
+			})
+			if err == nil || err == c.err {
+				t.Fatalf("err = %v, want it wrapped by the transaction", err)
+			}
+			matches := 0
+			for _, is := range all {
+				if is(err) {
+					matches++
+				}
+			}
+			if !c.is(err) || matches != 1 {
+				t.Errorf("%v classified %d times", err, matches)
+			}
+		})
+	}
+}
+
+func TestBeginAndCommitFailuresClassified(t *testing.T) {
+	errDown := errors.New("connection refused")
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			f.beginErr = errDown
+			called := false
+			err := s.Transaction(context.Background(), func(ctx context.Context) error {
+				called = true
+				return nil
+			})
+			if !errors.Is(err, ErrBeginFailed) || !errors.Is(err, errDown) || errors.Is(err, ErrCommitFailed) || called {
+				t.Errorf("begin failure: err = %v, fn called = %v", err, called)
+			}
+
+			f.beginErr = nil
+			f.fail("COMMIT", errDown)
+			err = s.Transaction(context.Background(), func(ctx context.Context) error { return nil })
+			if !errors.Is(err, ErrCommitFailed) || !errors.Is(err, errDown) || errors.Is(err, ErrBeginFailed) {
+				t.Errorf("commit failure: err = %v", err)
+			}
+
+			err = s.Transaction(context.Background(), func(ctx context.Context) error { return errFake })
+			if !errors.Is(err, errFake) || errors.Is(err, ErrBeginFailed) || errors.Is(err, ErrCommitFailed) {
+				t.Errorf("fn failure: err = %v", err)
+			}
+		})
+	}
+}
+
+// middlewareError Error of a driver-level wrapper, hiding the driver error behind its own type
+type middlewareError struct {
+	query string
+	err   error
+}
+
+func (e *middlewareError) Error() string {
+	return "sqlmw: " + e.query + ": " + e.err.Error()
+}
+
+func (e *middlewareError) Unwrap() error {
+	return e.err
+}
+
+func TestClassifyThroughWrappedDriver(t *testing.T) {
+	cases := []struct {
+		dialect string
+		err     error
+		is      func(error) bool
+	}{
+		{"postgres", &pgError{"23505"}, IsUniqueViolation},
+		{"postgres", &pgError{"40001"}, IsSerializationFailure},
+		{"mysql", &mysql.MySQLError{Number: 1452}, IsForeignKeyViolation},
+		{"mysql", &mysql.MySQLError{Number: 1213}, IsSerializationFailure},
+	}
+	for name, open := range testSessions {
+		for _, c := range cases {
+			t.Run(name+"/"+c.err.Error(), func(t *testing.T) {
+				f, s := open(t, c.dialect)
+				f.fail("INSERT", &middlewareError{query: "INSERT", err: c.err})
+				err := s.Transaction(context.Background(), func(ctx context.Context) error {
+					_, err := s.ExecQuery(ctx, "INSERT INTO users VALUES (1)")
+					return err
+				})
+				var wrapped *middlewareError
+				if !errors.As(err, &wrapped) || !c.is(err) {
+					t.Errorf("%v not classified through the wrapper", err)
+				}
+			})
+		}
+	}
+}
+
+// sqliteError Error of a driver the package knows nothing about, carrying an extended result code
+type sqliteError struct {
+	code int
+}
+
+func (e *sqliteError) Error() string {
+	return fmt.Sprintf("sqlite: error %d", e.code)
+}
+
+// sqliteClassifier Classify sqliteError codes: SQLITE_BUSY, SQLITE_CONSTRAINT_UNIQUE, _FOREIGNKEY and _NOTNULL
+type sqliteClassifier struct{}
+
+func (sqliteClassifier) code(err error) int {
+	var e *sqliteError
+	if !errors.As(err, &e) {
+		return 0
+	}
+	return e.code
+}
+
+func (c sqliteClassifier) IsRetryable(err error) bool {
+	return c.code(err) == 5
+}
+
+func (c sqliteClassifier) IsUniqueViolation(err error) bool {
+	return c.code(err) == 2067
+}
+
+func (c sqliteClassifier) IsForeignKeyViolation(err error) bool {
+	return c.code(err) == 787
+}
+
+func (c sqliteClassifier) IsNotNullViolation(err error) bool {
+	return c.code(err) == 1299
+}
+
+func (c sqliteClassifier) IsConnectionError(err error) bool {
+	return c.code(err) == 26
+}
+
+func TestRegisterErrorClassifier(t *testing.T) {
+	busy, unique := &sqliteError{5}, &sqliteError{2067}
+	if IsSerializationFailure(busy) || IsUniqueViolation(unique) {
+		t.Fatal("sqlite errors classified before registration")
+	}
+	RegisterErrorClassifier("sqlite", sqliteClassifier{})
+	t.Cleanup(func() {
+		RegisterErrorClassifier("sqlite", nil)
+	})
+	wrapped := fmt.Errorf("insert user: %w", unique)
+	if !IsUniqueViolation(wrapped) || IsForeignKeyViolation(wrapped) || !IsForeignKeyViolation(&sqliteError{787}) ||
+		!IsNotNullViolation(&sqliteError{1299}) || !IsConnectionError(&sqliteError{26}) || IsConnectionError(busy) {
+		t.Error("sqlite errors misclassified")
+	}
+	if !IsUniqueViolation(&pgError{"23505"}) || !IsUniqueViolation(&mysql.MySQLError{Number: 1062}) {
+		t.Error("built-in classifiers lost")
+	}
+
+	f, r := newFakeSession(t, "postgres", WithQueryRetry(1, RetryPolicy{}))
+	f.handle("UPDATE", flaky(2, busy, nil))
+	attempts := 0
+	err := r.TransactionWithRetry(context.Background(), 3, func(ctx context.Context) error {
+		attempts++
+		_, err := r.ExecQuery(ctx, "UPDATE counters SET n = n + 1")
+		return err
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("err = %v after %d attempts, want busy retried", err, attempts)
+	}
+	f.handle("SELECT", flaky(1, &sqliteError{26}, rowsOf([]string{"n"}, []driver.Value{int64(1)})))
+	if n, err := QueryValue[int64](context.Background(), r, "SELECT n FROM counters"); err != nil || n != 1 {
+		t.Errorf("n = %d, err = %v, want the connection error retried", n, err)
+	}
+}
+
+func TestRegisterErrorClassifierReplacesBuiltIn(t *testing.T) {
+	RegisterErrorClassifier("postgres", sqliteClassifier{})
+	t.Cleanup(func() {
+		RegisterErrorClassifier("postgres", sqlStateClassifier{})
+	})
+	if IsUniqueViolation(&pgError{"23505"}) || !IsUniqueViolation(&sqliteError{2067}) {
+		t.Error("postgres classifier not replaced")
+	}
+}
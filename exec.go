@@ -0,0 +1,166 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ExecResult Outcome of a statement, satisfied by sql.Result
+type ExecResult interface {
+	LastInsertId() (int64, error)
+	RowsAffected() (int64, error)
+}
+
+// errNoLastInsertID LastInsertId of a Gorm result, Gorm does not report generated keys of raw statements
+var errNoLastInsertID = errors.New("cransaction: LastInsertId is not available on the Gorm backend")
+
+// gormResult Adapt the *gorm.DB returned by a Gorm exec to ExecResult
+type gormResult struct {
+	db *gorm.DB
+}
+
+func (g gormResult) LastInsertId() (int64, error) {
+	return 0, errNoLastInsertID
+}
+
+func (g gormResult) RowsAffected() (int64, error) {
+	return g.db.RowsAffected, nil
+}
+
+// execResult Type the result returned by ExecQuery on either backend
+func execResult(result interface{}) (ExecResult, error) {
+	switch r := result.(type) {
+	case sql.Result:
+		return r, nil
+	case *gorm.DB:
+		return gormResult{r}, nil
+	}
+	return nil, fmt.Errorf("cransaction: unexpected exec result type %T", result)
+}
+
+// ExecStruct Execute query with the db-tagged fields of arg as positional args
+//
+// Fields are passed in declaration order, which must match the order of the placeholders in query. Fields
+// without a db tag or tagged "-" are skipped
+func (r *RDMSSession) ExecStruct(ctx context.Context, query string, arg interface{}) (ExecResult, error) {
+	return execStruct(ctx, r, query, arg)
+}
+
+// ExecStruct Execute query with the db-tagged fields of arg as positional args
+//
+// Fields are passed in declaration order, which must match the order of the placeholders in query. Fields
+// without a db tag or tagged "-" are skipped
+func (g *GormSession) ExecStruct(ctx context.Context, query string, arg interface{}) (ExecResult, error) {
+	return execStruct(ctx, g, query, arg)
+}
+
+func execStruct(ctx context.Context, tx ITransaction, query string, arg interface{}) (ExecResult, error) {
+	args, err := structArgs(arg)
+	if err != nil {
+		return nil, err
+	}
+	result, err := tx.ExecQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return execResult(result)
+}
+
+// ExecNonTransactional Execute query on the base handle even when ctx carries a transaction of the session
+//
+// The statement is not part of that transaction: it commits on its own right away, is not undone when the
+// transaction rolls back and runs on another connection, so it blocks on the locks the transaction holds and
+// does not see its uncommitted writes. Meant for statements that cannot run in a transaction block, such as
+// CREATE INDEX CONCURRENTLY on Postgres. WithRequireTransaction does not apply to it
+func (r *RDMSSession) ExecNonTransactional(ctx context.Context, query string, args ...interface{}) (ExecResult, error) {
+	return execNonTransactional(ctx, r, query, args)
+}
+
+// ExecNonTransactional Execute query on the base handle even when ctx carries a transaction of the session
+//
+// The statement is not part of that transaction: it commits on its own right away, is not undone when the
+// transaction rolls back and runs on another connection, so it blocks on the locks the transaction holds and
+// does not see its uncommitted writes. Meant for statements that cannot run in a transaction block, such as
+// CREATE INDEX CONCURRENTLY on Postgres. WithRequireTransaction does not apply to it
+func (g *GormSession) ExecNonTransactional(ctx context.Context, query string, args ...interface{}) (ExecResult, error) {
+	return execNonTransactional(ctx, g, query, args)
+}
+
+type nonTransactionalKey struct{}
+
+func execNonTransactional(ctx context.Context, tx ITransaction, query string, args []interface{}) (ExecResult, error) {
+	// A connection pinned by WithFreshConn may hold a transaction begun on it, so the pool is used instead
+	ctx = context.WithValue(WithoutTransaction(ctx), pinnedConnKey{}, (*pinnedConn)(nil))
+	result, err := tx.ExecQuery(context.WithValue(ctx, nonTransactionalKey{}, true), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return execResult(result)
+}
+
+// structArgs Values of the db-tagged fields of arg, a struct or a pointer to one, in declaration order
+func structArgs(arg interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(arg)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cransaction: cannot take args from %T, expected struct", arg)
+	}
+	var args []interface{}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("db"), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		args = append(args, v.Field(i).Interface())
+	}
+	return args, nil
+}
+
+// Prepare Validate query by preparing it on the server and closing the statement right away, without running it
+//
+// The server parses the statement and resolves the tables and columns it uses, so a CI step can check every
+// query of an application against the schema. Inside a transaction it is prepared on the transaction
+func (r *RDMSSession) Prepare(ctx context.Context, query string) (err error) {
+	defer r.opts.mapError(&err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	query, _, err = r.opts.prepare(ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := r.conn(ctx).PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	return stmt.Close()
+}
+
+// Prepare Validate query by preparing it on the server and closing the statement right away, without running it
+//
+// The server parses the statement and resolves the tables and columns it uses, so a CI step can check every
+// query of an application against the schema. Inside a transaction it is prepared on the transaction
+func (g *GormSession) Prepare(ctx context.Context, query string) (err error) {
+	defer g.opts.mapError(&err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	query, _, err = g.opts.prepare(ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := g.conn(ctx).Statement.ConnPool.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	return stmt.Close()
+}
@@ -0,0 +1,173 @@
+package cransaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testOrder struct {
+	ID       int64  `db:"id"`
+	Customer string `db:"customer,omitempty"`
+	internal string
+	Note     string
+	Skipped  string `db:"-"`
+	Total    int64  `db:"total"`
+}
+
+// structExecer Session offering ExecStruct, both backends do
+type structExecer interface {
+	ExecStruct(ctx context.Context, query string, arg interface{}) (ExecResult, error)
+}
+
+func TestExecStruct(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "mysql")
+			f.on("INSERT INTO orders", &fakeResult{affected: 1})
+			order := testOrder{ID: 7, Customer: "ada", internal: "x", Note: "n", Skipped: "s", Total: 1200}
+			res, err := tx.(structExecer).ExecStruct(context.Background(), "INSERT INTO orders (id, customer, total) VALUES (?, ?, ?)", &order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, err := res.RowsAffected(); err != nil || n != 1 {
+				t.Errorf("affected = %d, err = %v", n, err)
+			}
+			if got := fmt.Sprint(f.argsOf("INSERT INTO orders")); got != "[7 ada 1200]" {
+				t.Errorf("args = %s, want the tagged fields in declaration order", got)
+			}
+		})
+	}
+}
+
+func TestExecStructErrors(t *testing.T) {
+	f, r := newFakeSession(t, "mysql")
+	if _, err := r.ExecStruct(context.Background(), "INSERT INTO orders VALUES (?)", 42); err == nil {
+		t.Error("a non-struct arg was accepted")
+	}
+	if len(f.statements()) != 0 {
+		t.Errorf("statements = %v", f.statements())
+	}
+	f.fail("INSERT", errFake)
+	if _, err := r.ExecStruct(context.Background(), "INSERT INTO orders (id) VALUES (?)", testOrder{}); !errors.Is(err, errFake) {
+		t.Errorf("err = %v", err)
+	}
+
+	_, g := newFakeGorm(t, "mysql")
+	res, err := g.ExecStruct(context.Background(), "UPDATE orders SET total = ? WHERE id = ?", struct {
+		Total int64 `db:"total"`
+		ID    int64 `db:"id"`
+	}{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = res.LastInsertId(); err == nil {
+		t.Error("Gorm reported a last insert id")
+	}
+}
+
+// nonTransactionalSession Session offering ExecNonTransactional, both backends do
+type nonTransactionalSession interface {
+	ITransaction
+	ExecNonTransactional(ctx context.Context, query string, args ...interface{}) (ExecResult, error)
+}
+
+func TestExecNonTransactionalBypassesTransaction(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres", WithRequireTransaction(true))
+			s := tx.(nonTransactionalSession)
+			err := s.Transaction(context.Background(), func(ctx context.Context) error {
+				if _, err := s.ExecQuery(ctx, "INSERT INTO users VALUES (1)"); err != nil {
+					return err
+				}
+				if _, err := s.ExecNonTransactional(ctx, "CREATE INDEX CONCURRENTLY users_email ON users (email)"); err != nil {
+					return err
+				}
+				return errFake
+			})
+			if !errors.Is(err, errFake) {
+				t.Fatalf("err = %v", err)
+			}
+			if f.last("CREATE INDEX").inTx {
+				t.Error("the index was created in the ambient transaction")
+			}
+			if !f.last("INSERT INTO users").inTx {
+				t.Error("the insert left the transaction")
+			}
+			// Outside a transaction too, WithRequireTransaction does not apply to it
+			if _, err = s.ExecNonTransactional(context.Background(), "VACUUM users"); err != nil {
+				t.Errorf("outside a transaction err = %v", err)
+			}
+			wantStatements(t, f, "BEGIN", "INSERT INTO users VALUES (1)", "CREATE INDEX CONCURRENTLY users_email ON users (email)",
+				"ROLLBACK", "VACUUM users")
+		})
+	}
+}
+
+func TestExecTypedResult(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			f.on("UPDATE", &fakeResult{affected: 3})
+			result, err := s.Exec(context.Background(), "UPDATE users SET active = true")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, err := result.RowsAffected(); err != nil || n != 3 {
+				t.Errorf("RowsAffected = %d, %v, want 3", n, err)
+			}
+			f.fail("DELETE", errFake)
+			if result, err = s.Exec(context.Background(), "DELETE FROM users"); !errors.Is(err, errFake) || result != nil {
+				t.Errorf("failed Exec = %v, %v", result, err)
+			}
+		})
+	}
+	t.Run("gorm LastInsertId", func(t *testing.T) {
+		_, g := newFakeGorm(t, "mysql")
+		result, err := g.Exec(context.Background(), "INSERT INTO users (name) VALUES (?)", "ada")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = result.LastInsertId(); err == nil {
+			t.Error("LastInsertId succeeded, want an error as Gorm does not report it")
+		}
+	})
+}
+
+// preparer Session offering Prepare, both backends do
+type preparer interface {
+	Prepare(ctx context.Context, query string) error
+}
+
+func TestPrepareValidatesWithoutRunning(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres", WithQueryRewriter(func(_ context.Context, query string) (string, error) {
+				return strings.ReplaceAll(query, "{schema}", "app"), nil
+			}))
+			syntax := &pgError{"42601"}
+			f.fail("PREPARE SELEC ", syntax)
+			p := s.(preparer)
+			if err := p.Prepare(context.Background(), "SELECT id FROM {schema}.users WHERE id = $1"); err != nil {
+				t.Errorf("valid query: %v", err)
+			}
+			if err := p.Prepare(context.Background(), "SELEC id FROM users"); !errors.Is(err, syntax) {
+				t.Errorf("invalid query err = %v, want the syntax error", err)
+			}
+			err := s.Transaction(context.Background(), func(ctx context.Context) error {
+				return p.Prepare(ctx, "DELETE FROM users")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !f.last("PREPARE DELETE").inTx {
+				t.Error("Prepare in a transaction ran outside it")
+			}
+			wantStatements(t, f, "PREPARE SELECT id FROM app.users WHERE id = $1", "PREPARE SELEC id FROM users",
+				"BEGIN", "PREPARE DELETE FROM users", "COMMIT")
+		})
+	}
+}
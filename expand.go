@@ -0,0 +1,144 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ExpandIn Expand the placeholders bound to slice args into one placeholder per element, for IN clauses
+//
+// query uses either ? or $n placeholders, the $n ones after an expanded slice are renumbered. An empty slice
+// expands to NULL, so x IN (NULL) matches no row. []byte and driver.Valuer args, such as array types, are
+// passed unchanged
+func ExpandIn(query string, args ...interface{}) (string, []interface{}, error) {
+	spots := placeholders(query)
+	if len(spots) == 0 {
+		return query, args, nil
+	}
+	dollar := query[spots[0].start] == '$'
+	for _, spot := range spots {
+		if (query[spot.start] == '$') != dollar {
+			return "", nil, fmt.Errorf("cransaction: query mixes ? and $n placeholders")
+		}
+	}
+	if !dollar && len(spots) != len(args) {
+		return "", nil, fmt.Errorf("cransaction: query has %d placeholders for %d args", len(spots), len(args))
+	}
+	// first[i] is the position of the first expanded arg of args[i], counted from 1
+	first := make([]int, len(args))
+	var expanded []interface{}
+	for i, arg := range args {
+		first[i] = len(expanded) + 1
+		expanded = append(expanded, expandArg(arg)...)
+	}
+	var b strings.Builder
+	last := 0
+	for i, spot := range spots {
+		b.WriteString(query[last:spot.start])
+		last = spot.end
+		n := i + 1
+		if dollar {
+			n, _ = strconv.Atoi(query[spot.start+1 : spot.end])
+			if n < 1 || n > len(args) {
+				return "", nil, fmt.Errorf("cransaction: placeholder %s has no arg", query[spot.start:spot.end])
+			}
+		}
+		count := len(expandArg(args[n-1]))
+		if count == 0 {
+			b.WriteString("NULL")
+			continue
+		}
+		for k := 0; k < count; k++ {
+			if k > 0 {
+				b.WriteString(", ")
+			}
+			if dollar {
+				b.WriteString("$" + strconv.Itoa(first[n-1]+k))
+			} else {
+				b.WriteByte('?')
+			}
+		}
+	}
+	b.WriteString(query[last:])
+	return b.String(), expanded, nil
+}
+
+// QueryRowsIn Query multiple rows on tx after expanding the slice args with ExpandIn
+func QueryRowsIn(ctx context.Context, tx ITransaction, query string, args ...interface{}) (interface{}, error) {
+	query, args, err := ExpandIn(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return tx.QueryRows(ctx, query, args...)
+}
+
+func isExpandable(arg interface{}) bool {
+	if _, ok := arg.(driver.Valuer); ok || arg == nil {
+		return false
+	}
+	if _, ok := arg.([]byte); ok {
+		return false
+	}
+	return reflect.TypeOf(arg).Kind() == reflect.Slice
+}
+
+// expandArg Elements of a slice arg, the arg alone otherwise
+func expandArg(arg interface{}) []interface{} {
+	if !isExpandable(arg) {
+		return []interface{}{arg}
+	}
+	v := reflect.ValueOf(arg)
+	values := make([]interface{}, v.Len())
+	for i := range values {
+		values[i] = v.Index(i).Interface()
+	}
+	return values
+}
+
+// placeholder Position of a ? or $n placeholder in a query
+type placeholder struct {
+	start, end int
+}
+
+// placeholders Locate the placeholders of query outside string literals, quoted identifiers and comments
+func placeholders(query string) []placeholder {
+	var spots []placeholder
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			if end := strings.IndexByte(query[i+1:], c); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(query)
+			}
+		case strings.HasPrefix(query[i:], "--"):
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(query)
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(query)
+			}
+		case c == '?':
+			spots = append(spots, placeholder{start: i, end: i + 1})
+		case c == '$' && (i == 0 || !isIdentByte(query[i-1])):
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			if j > i+1 {
+				spots = append(spots, placeholder{start: i, end: j})
+				i = j - 1
+			}
+		}
+	}
+	return spots
+}
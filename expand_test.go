@@ -0,0 +1,97 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExpandIn(t *testing.T) {
+	cases := []struct {
+		name      string
+		query     string
+		args      []interface{}
+		wantQuery string
+		wantArgs  string
+	}{
+		{
+			name:      "question",
+			query:     "SELECT * FROM users WHERE org = ? AND id IN (?) AND active = ?",
+			args:      []interface{}{7, []int64{1, 2, 3}, true},
+			wantQuery: "SELECT * FROM users WHERE org = ? AND id IN (?, ?, ?) AND active = ?",
+			wantArgs:  "[7 1 2 3 true]",
+		},
+		{
+			name:      "dollar renumbered",
+			query:     "SELECT * FROM users WHERE id IN ($1) AND org = $2 AND role IN ($3) AND org <> $2",
+			args:      []interface{}{[]int{1, 2}, 7, []string{"admin", "owner"}},
+			wantQuery: "SELECT * FROM users WHERE id IN ($1, $2) AND org = $3 AND role IN ($4, $5) AND org <> $3",
+			wantArgs:  "[1 2 7 admin owner]",
+		},
+		{
+			name:      "empty slice",
+			query:     "SELECT * FROM users WHERE id IN ($1) AND org = $2",
+			args:      []interface{}{[]int{}, 7},
+			wantQuery: "SELECT * FROM users WHERE id IN (NULL) AND org = $1",
+			wantArgs:  "[7]",
+		},
+		{
+			name:      "bytes and valuers kept",
+			query:     "UPDATE files SET data = ?, deleted_at = ? WHERE id IN (?)",
+			args:      []interface{}{[]byte("x"), sql.NullTime{}, []int{4}},
+			wantQuery: "UPDATE files SET data = ?, deleted_at = ? WHERE id IN (?)",
+			wantArgs:  fmt.Sprint([]interface{}{[]byte("x"), sql.NullTime{}, 4}),
+		},
+		{
+			name:      "quoted and commented placeholders",
+			query:     "SELECT '?', \"$1\" -- ?\nFROM t /* $2 */ WHERE id IN ($1)",
+			args:      []interface{}{[]int{1, 2}},
+			wantQuery: "SELECT '?', \"$1\" -- ?\nFROM t /* $2 */ WHERE id IN ($1, $2)",
+			wantArgs:  "[1 2]",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			query, args, err := ExpandIn(c.query, c.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != c.wantQuery {
+				t.Errorf("query = %q, want %q", query, c.wantQuery)
+			}
+			if got := fmt.Sprint(args); got != c.wantArgs {
+				t.Errorf("args = %s, want %s", got, c.wantArgs)
+			}
+		})
+	}
+}
+
+func TestExpandInErrors(t *testing.T) {
+	cases := map[string][]interface{}{
+		"SELECT ? = $1":               {1, 2},
+		"SELECT * FROM t WHERE a = ?": {1, 2},
+		"SELECT $2":                   {1},
+	}
+	for query, args := range cases {
+		if _, _, err := ExpandIn(query, args...); err == nil {
+			t.Errorf("%q with %v expanded without error", query, args)
+		}
+	}
+}
+
+func TestQueryRowsIn(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	rows, err := QueryRowsIn(context.Background(), r, "SELECT * FROM events WHERE id IN ($1) AND at > $2", []int64{5, 6}, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rows.(*ContextRows).Close(); err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, f, "SELECT * FROM events WHERE id IN ($1, $2) AND at > $3")
+	if got := fmt.Sprint(f.argsOf("FROM events")[:2]); got != "[5 6]" {
+		t.Errorf("args = %s", got)
+	}
+}
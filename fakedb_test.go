@@ -0,0 +1,549 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// fakeDB Scripted database/sql driver recording every statement it receives
+//
+// Statements are answered by the first handler whose pattern they contain, the others succeed with no rows
+// and no affected row. Transactions are recorded as BEGIN, COMMIT and ROLLBACK, prepared statements as PREPARE
+// followed by the query
+type fakeDB struct {
+	mu       sync.Mutex
+	handlers []fakeHandler
+	log      []fakeStatement
+	txOpts   []driver.TxOptions
+	// checkValue Argument check of the connection, the default conversion of database/sql when nil
+	checkValue func(v *driver.NamedValue) error
+	// beginErr Returned by BeginTx when set
+	beginErr error
+	conns    int
+}
+
+// fakeStatement Statement received by the fake driver
+type fakeStatement struct {
+	query string
+	args  []driver.Value
+	names []string
+	// inTx The statement ran in a transaction of its connection
+	inTx bool
+}
+
+type fakeHandler struct {
+	pattern string
+	fn      func(ctx context.Context, args []driver.NamedValue) (*fakeResult, error)
+}
+
+// fakeResult Answer of the fake driver to a statement
+type fakeResult struct {
+	columns  []string
+	types    []string
+	rows     [][]driver.Value
+	affected int64
+	// next Following result set, for drivers returning several
+	next *fakeResult
+	// delay Wait before returning each row after the first fastRows, as a slow result set streams
+	delay    time.Duration
+	fastRows int
+}
+
+func rowsOf(columns []string, rows ...[]driver.Value) *fakeResult {
+	return &fakeResult{columns: columns, rows: rows}
+}
+
+// newFakeDB Open a *sql.DB on a new fake driver, closed at the end of the test
+func newFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	t.Helper()
+	f := &fakeDB{}
+	db := sql.OpenDB(f)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return f, db
+}
+
+// newFakeSession SQL session on a fake driver for dialect
+func newFakeSession(t *testing.T, dialect string, opts ...Option) (*fakeDB, *RDMSSession) {
+	t.Helper()
+	f, db := newFakeDB(t)
+	return f, NewSession(dialect, db, nil, context.Background(), opts...).(*RDMSSession)
+}
+
+// newFakeGorm Gorm session on a fake driver whose dialector reports dialect
+func newFakeGorm(t *testing.T, dialect string, opts ...Option) (*fakeDB, *GormSession) {
+	t.Helper()
+	f, db := newFakeDB(t)
+	gdb, err := gorm.Open(fakeDialector{name: dialect, pool: db}, &gorm.Config{
+		Logger:                 logger.Discard,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Forget the ping of gorm.Open
+	f.log = nil
+	return f, NewSession("gorm", gdb, nil, context.Background(), opts...).(*GormSession)
+}
+
+// on Answer statements containing pattern with res
+func (f *fakeDB) on(pattern string, res *fakeResult) {
+	f.handle(pattern, func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+		return res, nil
+	})
+}
+
+// fail Fail statements containing pattern with err
+func (f *fakeDB) fail(pattern string, err error) {
+	f.handle(pattern, func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+		return nil, err
+	})
+}
+
+// handle Answer statements containing pattern with fn
+func (f *fakeDB) handle(pattern string, fn func(ctx context.Context, args []driver.NamedValue) (*fakeResult, error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers = append(f.handlers, fakeHandler{pattern: pattern, fn: fn})
+}
+
+// statements Queries received so far, transaction boundaries included
+func (f *fakeDB) statements() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	queries := make([]string, len(f.log))
+	for i, s := range f.log {
+		queries[i] = s.query
+	}
+	return queries
+}
+
+// argsOf Args of the last statement containing pattern, nil when there is none
+func (f *fakeDB) argsOf(pattern string) []driver.Value {
+	return f.last(pattern).args
+}
+
+// last Last statement containing pattern, the zero statement when there is none
+func (f *fakeDB) last(pattern string) fakeStatement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.log) - 1; i >= 0; i-- {
+		if strings.Contains(f.log[i].query, pattern) {
+			return f.log[i]
+		}
+	}
+	return fakeStatement{}
+}
+
+// count Number of statements received containing pattern
+func (f *fakeDB) count(pattern string) int {
+	n := 0
+	for _, q := range f.statements() {
+		if strings.Contains(q, pattern) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeDB) record(query string, args []driver.NamedValue, inTx bool) {
+	values := make([]driver.Value, len(args))
+	names := make([]string, len(args))
+	for i, a := range args {
+		values[i], names[i] = a.Value, a.Name
+	}
+	f.mu.Lock()
+	f.log = append(f.log, fakeStatement{query: query, args: values, names: names, inTx: inTx})
+	f.mu.Unlock()
+}
+
+func (f *fakeDB) answer(ctx context.Context, query string, args []driver.NamedValue, inTx bool) (*fakeResult, error) {
+	f.record(query, args, inTx)
+	f.mu.Lock()
+	var fn func(ctx context.Context, args []driver.NamedValue) (*fakeResult, error)
+	for _, h := range f.handlers {
+		if strings.Contains(query, h.pattern) {
+			fn = h.fn
+			break
+		}
+	}
+	f.mu.Unlock()
+	if fn == nil {
+		return &fakeResult{}, nil
+	}
+	res, err := fn(ctx, args)
+	if err == nil && res == nil {
+		res = &fakeResult{}
+	}
+	return res, err
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	f.mu.Lock()
+	f.conns++
+	f.mu.Unlock()
+	return &fakeConn{db: f}, nil
+}
+
+func (f *fakeDB) Driver() driver.Driver {
+	return fakeDriver{f}
+}
+
+type fakeDriver struct {
+	db *fakeDB
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return d.db.Connect(context.Background())
+}
+
+type fakeConn struct {
+	db *fakeDB
+	// inTx A transaction is open on the connection
+	inTx bool
+}
+
+type fakeConnKey struct{}
+
+// withConn Context of a statement run on c, handlers find the connection with connOf
+func withConn(ctx context.Context, c *fakeConn) context.Context {
+	return context.WithValue(ctx, fakeConnKey{}, c)
+}
+
+// connOf Connection a handler is answering for, telling the transactions of the fake database apart
+func connOf(ctx context.Context) *fakeConn {
+	c, _ := ctx.Value(fakeConnKey{}).(*fakeConn)
+	return c
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext Record the statement as PREPARE followed by query, a handler failing it fails the prepare
+func (c *fakeConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if _, err := c.db.answer(withConn(ctx, c), "PREPARE "+query, nil, c.inTx); err != nil {
+		return nil, err
+	}
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.db.mu.Lock()
+	err := c.db.beginErr
+	c.db.txOpts = append(c.db.txOpts, opts)
+	c.db.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = c.db.answer(withConn(ctx, c), "BEGIN", nil, false); err != nil {
+		return nil, err
+	}
+	c.inTx = true
+	return &fakeTx{conn: c}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.db.answer(withConn(ctx, c), query, args, c.inTx)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.db.answer(withConn(ctx, c), query, args, c.inTx)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{res: res}, nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	_, err := c.db.answer(withConn(ctx, c), "PING", nil, false)
+	return err
+}
+
+func (c *fakeConn) CheckNamedValue(v *driver.NamedValue) error {
+	if c.db.checkValue == nil {
+		return driver.ErrSkip
+	}
+	return c.db.checkValue(v)
+}
+
+type fakeTx struct {
+	conn *fakeConn
+}
+
+func (t *fakeTx) Commit() error {
+	t.conn.inTx = false
+	_, err := t.conn.db.answer(withConn(context.Background(), t.conn), "COMMIT", nil, true)
+	return err
+}
+
+func (t *fakeTx) Rollback() error {
+	t.conn.inTx = false
+	_, err := t.conn.db.answer(withConn(context.Background(), t.conn), "ROLLBACK", nil, true)
+	return err
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return out
+}
+
+type fakeRows struct {
+	res *fakeResult
+	pos int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.res.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.res.rows) {
+		return io.EOF
+	}
+	if r.pos >= r.res.fastRows {
+		time.Sleep(r.res.delay)
+	}
+	copy(dest, r.res.rows[r.pos])
+	r.pos++
+	return nil
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string {
+	if i < len(r.res.types) {
+		return r.res.types[i]
+	}
+	return ""
+}
+
+func (r *fakeRows) HasNextResultSet() bool {
+	return r.res.next != nil
+}
+
+func (r *fakeRows) NextResultSet() error {
+	if r.res.next == nil {
+		return io.EOF
+	}
+	r.res, r.pos = r.res.next, 0
+	return nil
+}
+
+// fakeDialector Gorm dialector on a *sql.DB of the fake driver, reporting name as its dialect
+type fakeDialector struct {
+	name string
+	pool *sql.DB
+}
+
+func (d fakeDialector) Name() string {
+	return d.name
+}
+
+func (d fakeDialector) Initialize(db *gorm.DB) error {
+	db.ConnPool = d.pool
+	callbacks.RegisterDefaultCallbacks(db, &callbacks.Config{})
+	return nil
+}
+
+func (d fakeDialector) Migrator(*gorm.DB) gorm.Migrator {
+	return nil
+}
+
+func (d fakeDialector) DataTypeOf(*schema.Field) string {
+	return ""
+}
+
+func (d fakeDialector) DefaultValueOf(*schema.Field) clause.Expression {
+	return clause.Expr{SQL: "DEFAULT"}
+}
+
+func (d fakeDialector) BindVarTo(writer clause.Writer, _ *gorm.Statement, _ interface{}) {
+	_ = writer.WriteByte('?')
+}
+
+func (d fakeDialector) QuoteTo(writer clause.Writer, str string) {
+	_, _ = writer.WriteString(`"` + str + `"`)
+}
+
+func (d fakeDialector) Explain(sql string, vars ...interface{}) string {
+	return logger.ExplainSQL(sql, nil, `'`, vars...)
+}
+
+// errFake Error returned by the statements a test makes fail
+var errFake = errors.New("fake: statement failed")
+
+// wantStatements Fail the test unless the fake driver received exactly want, in order
+func wantStatements(t *testing.T, f *fakeDB, want ...string) {
+	t.Helper()
+	got := f.statements()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("statements:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// testSessions Open a session of each backend on a fake driver for a dialect
+var testSessions = map[string]func(t *testing.T, dialect string, opts ...Option) (*fakeDB, ITransaction){
+	"sql": func(t *testing.T, dialect string, opts ...Option) (*fakeDB, ITransaction) {
+		return newFakeSession(t, dialect, opts...)
+	},
+	"gorm": func(t *testing.T, dialect string, opts ...Option) (*fakeDB, ITransaction) {
+		return newFakeGorm(t, dialect, opts...)
+	},
+}
+
+// testLogger Logger keeping the lines it receives
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+// logged Lines received so far containing substr
+func (l *testLogger) logged(substr string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var lines []string
+	for _, line := range l.lines {
+		if strings.Contains(line, substr) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// fakeClock Clock moving only when told, with Advance, or by every wait it is asked for when instant
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	instant bool
+	waiters []clockWaiter
+	// waits Durations passed to After, in call order
+	waits []time.Duration
+}
+
+type clockWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	if c.instant {
+		c.now = c.now.Add(d)
+	}
+	if d <= 0 || c.instant {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, clockWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance Move the clock by d, firing the waits that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+// waitForWaiters Block until n waits are pending, for timers armed by another goroutine
+func (c *fakeClock) waitForWaiters(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		pending := len(c.waiters)
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+	}
+	t.Fatalf("%d waits pending, want %d", len(c.waiters), n)
+}
+
+// recorded Durations passed to After so far
+func (c *fakeClock) recorded() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waits...)
+}
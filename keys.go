@@ -0,0 +1,88 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errKeysNotPostgres = errors.New("cransaction: QueryRowsByKeys requires a Postgres session")
+
+// QueryRowsByKeys Query rows matching keys and scan each one into T, Postgres only
+//
+// keys are sent as one array parameter, the only one of query, so query compares against it with = ANY($1) or
+// joins UNNEST($1), written with ? on Gorm. The statement stays the same whatever the number of keys and is not
+// bound by the limit on bind parameters that ExpandIn runs into. Empty keys return no rows without querying
+func QueryRowsByKeys[T any](ctx context.Context, tx ITransaction, query string, keys []interface{}) ([]T, error) {
+	if dialectOf(tx).dialect() != "postgres" {
+		return nil, errKeysNotPostgres
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return QueryRowsStruct[T](ctx, tx, query, pgArray(keys))
+}
+
+// pgArray Postgres array literal of its elements, cast by the server to the type of the parameter
+type pgArray []interface{}
+
+func (a pgArray) Value() (driver.Value, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range a {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := writeArrayElem(&b, v); err != nil {
+			return nil, fmt.Errorf("cransaction: key %d: %w", i, err)
+		}
+	}
+	b.WriteByte('}')
+	return b.String(), nil
+}
+
+func writeArrayElem(b *strings.Builder, v interface{}) error {
+	if valuer, ok := v.(driver.Valuer); ok {
+		var err error
+		if v, err = valuer.Value(); err != nil {
+			return err
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("NULL")
+	case string:
+		quoteArrayElem(b, v)
+	case []byte:
+		quoteArrayElem(b, string(v))
+	case time.Time:
+		quoteArrayElem(b, v.Format(time.RFC3339Nano))
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		fmt.Fprint(b, v)
+	case float32:
+		b.WriteString(strconv.FormatFloat(float64(v), 'g', -1, 32))
+	case float64:
+		b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	default:
+		return fmt.Errorf("unsupported type %T", v)
+	}
+	return nil
+}
+
+// quoteArrayElem Write s as a double-quoted array element, backslash-escaping quotes and backslashes
+func quoteArrayElem(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+}
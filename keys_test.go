@@ -0,0 +1,82 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestQueryRowsByKeysThousands(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			f.handle("ANY", func(_ context.Context, args []driver.NamedValue) (*fakeResult, error) {
+				if len(args) != 1 {
+					t.Fatalf("%d args, want the keys as one array", len(args))
+				}
+				literal := args[0].Value.(string)
+				if !strings.HasPrefix(literal, "{") || !strings.HasSuffix(literal, "}") {
+					t.Fatalf("arg %q is not an array literal", literal)
+				}
+				res := &fakeResult{columns: []string{"id", "name"}}
+				for _, id := range strings.Split(strings.Trim(literal, "{}"), ",") {
+					n, err := strconv.ParseInt(id, 10, 64)
+					if err != nil {
+						return nil, err
+					}
+					res.rows = append(res.rows, []driver.Value{n, "user " + id})
+				}
+				return res, nil
+			})
+			keys := make([]interface{}, 5000)
+			for i := range keys {
+				keys[i] = i + 1
+			}
+			users, err := QueryRowsByKeys[testPagedUser](context.Background(), tx, "SELECT id, name FROM users WHERE id = ANY($1)", keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(users) != 5000 || users[0].ID != 1 || users[4999].ID != 5000 || users[4999].Name != "user 5000" {
+				t.Fatalf("%d users, last %+v", len(users), users[len(users)-1])
+			}
+			if got := f.count("ANY"); got != 1 {
+				t.Errorf("%d queries, want 1", got)
+			}
+		})
+	}
+}
+
+func TestQueryRowsByKeysEncoding(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	if _, err := QueryRowsByKeys[testPagedUser](context.Background(), r, "SELECT * FROM users WHERE name = ANY($1)",
+		[]interface{}{"ada", `say "hi"`, `back\slash`, nil, true, 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.argsOf("ANY"), `{"ada","say \"hi\"","back\\slash",NULL,true,1.5}`; len(got) != 1 || got[0] != want {
+		t.Errorf("array = %v, want %s", got, want)
+	}
+	if _, err := QueryRowsByKeys[testPagedUser](context.Background(), r, "SELECT * FROM users WHERE id = ANY($1)",
+		[]interface{}{struct{}{}}); err == nil || !strings.Contains(err.Error(), "key 0") {
+		t.Errorf("err = %v, want the unsupported key reported", err)
+	}
+	users, err := QueryRowsByKeys[testPagedUser](context.Background(), r, "SELECT * FROM users WHERE id = ANY($1)", nil)
+	if err != nil || users != nil {
+		t.Errorf("no keys = %v, %v", users, err)
+	}
+	if got := f.count("ANY"); got != 1 {
+		t.Errorf("%d queries, want only the first one", got)
+	}
+}
+
+func TestQueryRowsByKeysPostgresOnly(t *testing.T) {
+	f, r := newFakeSession(t, "mysql")
+	if _, err := QueryRowsByKeys[testPagedUser](context.Background(), r, "SELECT * FROM users WHERE id = ANY(?)", []interface{}{1}); !errors.Is(err, errKeysNotPostgres) {
+		t.Errorf("err = %v", err)
+	}
+	if len(f.statements()) != 0 {
+		t.Errorf("statements = %v", f.statements())
+	}
+}
@@ -0,0 +1,122 @@
+package cransaction
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SelectForUpdateOrdered Lock the rows of table whose id is in ids, in id order, in the active transaction
+//
+// Every caller taking its row locks in the same order cannot deadlock with the others on them
+func (r *RDMSSession) SelectForUpdateOrdered(ctx context.Context, table string, ids []interface{}) error {
+	if _, ok := r.txFrom(ctx); !ok {
+		return ErrNoActiveTransaction
+	}
+	return selectForUpdateOrdered(ctx, r, table, ids)
+}
+
+// SelectForUpdateOrdered Lock the rows of table whose id is in ids, in id order, in the active transaction
+//
+// Every caller taking its row locks in the same order cannot deadlock with the others on them
+func (g *GormSession) SelectForUpdateOrdered(ctx context.Context, table string, ids []interface{}) error {
+	if _, ok := g.txFrom(ctx); !ok {
+		return ErrNoActiveTransaction
+	}
+	return selectForUpdateOrdered(ctx, g, table, ids)
+}
+
+func selectForUpdateOrdered(ctx context.Context, tx ITransaction, table string, ids []interface{}) error {
+	if err := checkTable(table); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	d := dialectOf(tx)
+	binds := make([]string, len(ids))
+	for i := range ids {
+		binds[i] = d.bindVar(i + 1)
+	}
+	query := "SELECT id FROM " + table + " WHERE id IN (" + strings.Join(binds, ", ") + ") ORDER BY id FOR UPDATE"
+	rows, err := queryRows(ctx, tx, query, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	// Rows are locked as they are read, drain them so that every lock is held on return
+	for rows.Next() {
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// SelectForUpdateNoWait Query rows with query followed by FOR UPDATE NOWAIT in the active transaction, failing
+// with ErrLocked instead of waiting when one of them is locked by another transaction
+//
+// Supported on Postgres and MySQL 8 or later. The lock conflict is reported by the query itself, so ErrLocked
+// is returned before any row is read. The rows are the *ContextRows of QueryRows. On Postgres the error aborts
+// the transaction, call it from a nested Transaction to keep the enclosing one usable
+func (r *RDMSSession) SelectForUpdateNoWait(ctx context.Context, query string, args ...interface{}) (*ContextRows, error) {
+	if _, ok := r.txFrom(ctx); !ok {
+		return nil, ErrNoActiveTransaction
+	}
+	return selectForUpdateNoWait(ctx, r, query, args)
+}
+
+// SelectForUpdateNoWait Query rows with query followed by FOR UPDATE NOWAIT in the active transaction, failing
+// with ErrLocked instead of waiting when one of them is locked by another transaction
+//
+// Supported on Postgres and MySQL 8 or later. The lock conflict is reported by the query itself, so ErrLocked
+// is returned before any row is read. The rows are the *ContextRows of QueryRows. On Postgres the error aborts
+// the transaction, call it from a nested Transaction to keep the enclosing one usable
+func (g *GormSession) SelectForUpdateNoWait(ctx context.Context, query string, args ...interface{}) (*ContextRows, error) {
+	if _, ok := g.txFrom(ctx); !ok {
+		return nil, ErrNoActiveTransaction
+	}
+	return selectForUpdateNoWait(ctx, g, query, args)
+}
+
+func selectForUpdateNoWait(ctx context.Context, tx ITransaction, query string, args []interface{}) (*ContextRows, error) {
+	if dialect := dialectOf(tx).dialect(); dialect != "postgres" && dialect != "mysql" {
+		return nil, fmt.Errorf("cransaction: FOR UPDATE NOWAIT is not supported on %q", dialect)
+	}
+	query = strings.TrimRight(strings.TrimSpace(query), ";") + " FOR UPDATE NOWAIT"
+	rows, err := queryRows(ctx, tx, query, args...)
+	if err != nil {
+		if isLockNotAvailable(err) {
+			return nil, fmt.Errorf("%w: %w", ErrLocked, err)
+		}
+		return nil, err
+	}
+	return rows, nil
+}
+
+// isLockNotAvailable Report whether err is a NOWAIT lock request that found the row locked
+func isLockNotAvailable(err error) bool {
+	return hasSQLState(err, "55P03") || hasMySQLNumber(err, 3572)
+}
+
+// SelectForUpdateSkipLocked Claim up to limit rows of query in the transaction of tx in ctx, skipping the rows other
+// transactions hold locked, and scan them into T
+//
+// query is a SELECT without LIMIT nor locking clause, LIMIT and FOR UPDATE SKIP LOCKED are appended. Workers
+// polling a queue table with it each get rows no other one has, and keep them locked until their transaction
+// ends. Supported on Postgres and MySQL 8 or later
+func SelectForUpdateSkipLocked[T any](ctx context.Context, tx ITransaction, query string, limit int, args ...interface{}) ([]T, error) {
+	if sessionState(ctx, tx) == nil {
+		return nil, ErrNoActiveTransaction
+	}
+	d := dialectOf(tx)
+	if dialect := d.dialect(); dialect != "postgres" && dialect != "mysql" {
+		return nil, fmt.Errorf("cransaction: FOR UPDATE SKIP LOCKED is not supported on %q", dialect)
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("cransaction: limit must be positive, got %d", limit)
+	}
+	args = append(args[:len(args):len(args)], limit)
+	query = strings.TrimRight(strings.TrimSpace(query), ";") + " LIMIT " + d.bindVar(len(args)) + " FOR UPDATE SKIP LOCKED"
+	return QueryRowsStruct[T](ctx, tx, query, args...)
+}
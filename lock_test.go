@@ -0,0 +1,394 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// rowLocks Row locks of the fake database, taken by FOR UPDATE and held until the transaction ends
+type rowLocks struct {
+	mu   sync.Mutex
+	held sync.Mutex
+	busy bool
+}
+
+func (l *rowLocks) lock(context.Context, []driver.NamedValue) (*fakeResult, error) {
+	l.held.Lock()
+	l.mu.Lock()
+	l.busy = true
+	l.mu.Unlock()
+	return &fakeResult{}, nil
+}
+
+func (l *rowLocks) release(context.Context, []driver.NamedValue) (*fakeResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.busy {
+		l.busy = false
+		l.held.Unlock()
+	}
+	return &fakeResult{}, nil
+}
+
+// ownedRows Rows of the fake database locked by the connection of the transaction holding them, released when
+// that transaction ends
+type ownedRows struct {
+	mu    sync.Mutex
+	ids   []int64
+	owner map[int64]*fakeConn
+}
+
+func newOwnedRows(f *fakeDB, ids ...int64) *ownedRows {
+	l := &ownedRows{ids: ids, owner: map[int64]*fakeConn{}}
+	f.handle("COMMIT", l.release)
+	f.handle("ROLLBACK", l.release)
+	return l
+}
+
+// noWait Lock the row of the first arg, failing with conflict when another transaction holds it
+func (l *ownedRows) noWait(conflict error) func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+	return func(ctx context.Context, args []driver.NamedValue) (*fakeResult, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		id := args[0].Value.(int64)
+		if c, ok := l.owner[id]; ok && c != connOf(ctx) {
+			return nil, conflict
+		}
+		l.owner[id] = connOf(ctx)
+		return rowsOf([]string{"id"}, []driver.Value{id}), nil
+	}
+}
+
+// skipLocked Lock and return up to the limit of the last arg of the rows no other transaction holds
+func (l *ownedRows) skipLocked(ctx context.Context, args []driver.NamedValue) (*fakeResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit := args[len(args)-1].Value.(int64)
+	res := rowsOf([]string{"id"})
+	for _, id := range l.ids {
+		if int64(len(res.rows)) == limit {
+			break
+		}
+		if c, ok := l.owner[id]; ok && c != connOf(ctx) {
+			continue
+		}
+		l.owner[id] = connOf(ctx)
+		res.rows = append(res.rows, []driver.Value{id})
+	}
+	return res, nil
+}
+
+func (l *ownedRows) release(ctx context.Context, _ []driver.NamedValue) (*fakeResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, c := range l.owner {
+		if c == connOf(ctx) {
+			delete(l.owner, id)
+		}
+	}
+	return nil, nil
+}
+
+// waitFor Poll cond until it holds, failing the test after a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not reached")
+		}
+	}
+}
+
+// orderedLocker Session offering SelectForUpdateOrdered, both backends do
+type orderedLocker interface {
+	SelectForUpdateOrdered(ctx context.Context, table string, ids []interface{}) error
+}
+
+func TestSelectForUpdateOrderedBlocksConflictingTransaction(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			s := tx.(orderedLocker)
+			locks := &rowLocks{}
+			f.handle("FOR UPDATE", locks.lock)
+			f.handle("COMMIT", locks.release)
+			f.handle("ROLLBACK", locks.release)
+
+			locked, release := make(chan struct{}), make(chan struct{})
+			first := make(chan error, 1)
+			go func() {
+				first <- tx.Transaction(context.Background(), func(ctx context.Context) error {
+					if err := s.SelectForUpdateOrdered(ctx, "accounts", []interface{}{3, 1, 2}); err != nil {
+						return err
+					}
+					close(locked)
+					<-release
+					return nil
+				})
+			}()
+			<-locked
+			var secondLocked atomic.Bool
+			second := make(chan error, 1)
+			go func() {
+				second <- tx.Transaction(context.Background(), func(ctx context.Context) error {
+					err := s.SelectForUpdateOrdered(ctx, "accounts", []interface{}{2, 3})
+					secondLocked.Store(true)
+					return err
+				})
+			}()
+			waitFor(t, func() bool { return f.count("FOR UPDATE") == 2 })
+			if secondLocked.Load() {
+				t.Fatal("the conflicting transaction took the locks while they were held")
+			}
+			close(release)
+			if err := <-first; err != nil {
+				t.Fatal(err)
+			}
+			if err := <-second; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSelectForUpdateOrdered(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	if err := r.SelectForUpdateOrdered(context.Background(), "accounts", []interface{}{1}); !errors.Is(err, ErrNoActiveTransaction) {
+		t.Fatalf("outside a transaction err = %v", err)
+	}
+	err := r.Transaction(context.Background(), func(ctx context.Context) error {
+		return r.SelectForUpdateOrdered(ctx, "accounts", []interface{}{3, 1, 2})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, f, "BEGIN", "SELECT id FROM accounts WHERE id IN ($1, $2, $3) ORDER BY id FOR UPDATE", "COMMIT")
+}
+
+// noWaitLocker Session offering SelectForUpdateNoWait, both backends do
+type noWaitLocker interface {
+	SelectForUpdateNoWait(ctx context.Context, query string, args ...interface{}) (*ContextRows, error)
+}
+
+// lockOne Lock the row id with SelectForUpdateNoWait and check it is returned
+func lockOne(ctx context.Context, s noWaitLocker, id int64) error {
+	rows, err := s.SelectForUpdateNoWait(ctx, "SELECT id FROM jobs WHERE id = $1;", id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var got int64
+	if !rows.Next() {
+		return errors.New("row not returned")
+	}
+	if err = rows.Scan(&got); err != nil {
+		return err
+	}
+	if got != id {
+		return fmt.Errorf("locked row %d, want %d", got, id)
+	}
+	return rows.Close()
+}
+
+func TestSelectForUpdateNoWait(t *testing.T) {
+	conflicts := map[string]error{
+		"postgres": &pgError{"55P03"},
+		"mysql":    &mysql.MySQLError{Number: 3572, Message: "Statement aborted because lock(s) could not be acquired immediately and NOWAIT is set."},
+	}
+	for backend, open := range testSessions {
+		for dialect, conflict := range conflicts {
+			t.Run(backend+"/"+dialect, func(t *testing.T) {
+				f, tx := open(t, dialect)
+				s := tx.(noWaitLocker)
+				locks := newOwnedRows(f, 1, 2)
+				f.handle("FOR UPDATE NOWAIT", locks.noWait(conflict))
+
+				locked, release := make(chan struct{}), make(chan struct{})
+				first := make(chan error, 1)
+				go func() {
+					first <- tx.Transaction(context.Background(), func(ctx context.Context) error {
+						if err := lockOne(ctx, s, 1); err != nil {
+							return err
+						}
+						close(locked)
+						<-release
+						return nil
+					})
+				}()
+				<-locked
+				err := tx.Transaction(context.Background(), func(ctx context.Context) error {
+					return lockOne(ctx, s, 1)
+				})
+				if !errors.Is(err, ErrLocked) || !errors.Is(err, conflict) {
+					t.Errorf("locked row err = %v, want ErrLocked wrapping the driver error", err)
+				}
+				err = tx.Transaction(context.Background(), func(ctx context.Context) error {
+					return lockOne(ctx, s, 2)
+				})
+				if err != nil {
+					t.Errorf("free row err = %v", err)
+				}
+				close(release)
+				if err = <-first; err != nil {
+					t.Fatal(err)
+				}
+				err = tx.Transaction(context.Background(), func(ctx context.Context) error {
+					return lockOne(ctx, s, 1)
+				})
+				if err != nil {
+					t.Errorf("row released by the first transaction err = %v", err)
+				}
+				if q := f.last("NOWAIT").query; q != "SELECT id FROM jobs WHERE id = $1 FOR UPDATE NOWAIT" {
+					t.Errorf("query = %q", q)
+				}
+			})
+		}
+	}
+}
+
+func TestSelectForUpdateNoWaitFailures(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			s := tx.(noWaitLocker)
+			if _, err := s.SelectForUpdateNoWait(context.Background(), "SELECT id FROM jobs"); !errors.Is(err, ErrNoActiveTransaction) {
+				t.Errorf("outside a transaction err = %v", err)
+			}
+			f.fail("NOWAIT", errFake)
+			err := tx.Transaction(context.Background(), func(ctx context.Context) error {
+				_, err := s.SelectForUpdateNoWait(ctx, "SELECT id FROM jobs")
+				return err
+			})
+			if !errors.Is(err, errFake) || errors.Is(err, ErrLocked) {
+				t.Errorf("other error err = %v, want it unchanged", err)
+			}
+		})
+	}
+	_, g := newFakeGorm(t, "sqlite")
+	err := g.Transaction(context.Background(), func(ctx context.Context) error {
+		_, err := g.SelectForUpdateNoWait(ctx, "SELECT id FROM jobs")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("sqlite err = %v", err)
+	}
+}
+
+type testJob struct {
+	ID int64 `db:"id"`
+}
+
+func TestSelectForUpdateSkipLockedClaimsDisjointRows(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			locks := newOwnedRows(f, 1, 2, 3, 4, 5, 6)
+			f.handle("SKIP LOCKED", locks.skipLocked)
+			// Gorm takes ? and rewrites it into the placeholders of its dialect
+			query := "SELECT id FROM jobs WHERE state = " + dialectOf(tx).bindVar(1) + " ORDER BY id"
+
+			const workers = 3
+			var claiming sync.WaitGroup
+			claiming.Add(workers)
+			claims := make([][]testJob, workers)
+			errs := make(chan error, workers)
+			for i := range workers {
+				go func() {
+					errs <- tx.Transaction(context.Background(), func(ctx context.Context) error {
+						jobs, err := SelectForUpdateSkipLocked[testJob](ctx, tx, query, 2, "queued")
+						claims[i] = jobs
+						// Hold the rows until every worker has claimed its own
+						claiming.Done()
+						claiming.Wait()
+						return err
+					})
+				}()
+			}
+			var claimed []int64
+			for i := 0; i < workers; i++ {
+				if err := <-errs; err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, jobs := range claims {
+				if len(jobs) != 2 {
+					t.Errorf("worker %d claimed %v, want 2 rows", i, jobs)
+				}
+				for _, j := range jobs {
+					claimed = append(claimed, j.ID)
+				}
+			}
+			slices.Sort(claimed)
+			if want := []int64{1, 2, 3, 4, 5, 6}; !slices.Equal(claimed, want) {
+				t.Errorf("claimed %v, want every row exactly once", claimed)
+			}
+			st := f.last("SKIP LOCKED")
+			if st.query != query+" LIMIT "+dialectOf(tx).bindVar(2)+" FOR UPDATE SKIP LOCKED" {
+				t.Errorf("query = %q", st.query)
+			}
+			if !st.inTx || len(st.args) != 2 || st.args[0] != "queued" || st.args[1] != int64(2) {
+				t.Errorf("statement = %+v", st)
+			}
+
+			var again []testJob
+			err := tx.Transaction(context.Background(), func(ctx context.Context) (err error) {
+				again, err = SelectForUpdateSkipLocked[testJob](ctx, tx, "SELECT id FROM jobs ORDER BY id", 1)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(again) != 1 || again[0].ID != 1 {
+				t.Errorf("after the workers committed claimed %v, want row 1 back", again)
+			}
+		})
+	}
+}
+
+func TestSelectForUpdateSkipLocked(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "mysql")
+			_, other := open(t, "mysql")
+			if _, err := SelectForUpdateSkipLocked[testJob](context.Background(), tx, "SELECT id FROM jobs", 1); !errors.Is(err, ErrNoActiveTransaction) {
+				t.Errorf("outside a transaction err = %v", err)
+			}
+			err := other.Transaction(context.Background(), func(ctx context.Context) error {
+				_, err := SelectForUpdateSkipLocked[testJob](ctx, tx, "SELECT id FROM jobs", 1)
+				return err
+			})
+			if !errors.Is(err, ErrNoActiveTransaction) {
+				t.Errorf("in a transaction of another session err = %v", err)
+			}
+			err = tx.Transaction(context.Background(), func(ctx context.Context) error {
+				if _, err := SelectForUpdateSkipLocked[testJob](ctx, tx, "SELECT id FROM jobs", 0); err == nil || !strings.Contains(err.Error(), "limit must be positive") {
+					t.Errorf("zero limit err = %v", err)
+				}
+				_, err := SelectForUpdateSkipLocked[testJob](ctx, tx, "SELECT id FROM jobs WHERE queue = ?;", 10, "mail")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatements(t, f, "BEGIN", "SELECT id FROM jobs WHERE queue = ? LIMIT ? FOR UPDATE SKIP LOCKED", "COMMIT")
+		})
+	}
+	_, g := newFakeGorm(t, "sqlite")
+	err := g.Transaction(context.Background(), func(ctx context.Context) error {
+		_, err := SelectForUpdateSkipLocked[testJob](ctx, g, "SELECT id FROM jobs", 1)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("sqlite err = %v", err)
+	}
+}
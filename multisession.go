@@ -0,0 +1,74 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// multiSession Fan-out session writing to a primary and secondary sessions, see MultiSession
+type multiSession struct {
+	primary     ITransaction
+	secondaries []ITransaction
+}
+
+// MultiSession Combine sessions for dual writes during a migration, writes go to every session and reads to primary
+//
+// ExecQuery runs on the primary first, then on each secondary, and fails on the first error. Transaction
+// nests a transaction on every session around fn: when fn fails all of them roll back, otherwise they commit
+// one after the other starting with the last secondary. The separate commits are not atomic, a failing
+// commit can leave the sessions that already committed ahead of the others
+func MultiSession(primary ITransaction, secondary ...ITransaction) ITransaction {
+	return &multiSession{primary: primary, secondaries: secondary}
+}
+
+func (m *multiSession) sessions() []ITransaction {
+	return append([]ITransaction{m.primary}, m.secondaries...)
+}
+
+func (m *multiSession) Transaction(ctx context.Context, fn func(context.Context) error) error {
+	sessions := m.sessions()
+	var nest func(ctx context.Context, i int) error
+	nest = func(ctx context.Context, i int) error {
+		if i == len(sessions) {
+			return fn(ctx)
+		}
+		return sessions[i].Transaction(ctx, func(ctx context.Context) error {
+			return nest(ctx, i+1)
+		})
+	}
+	return nest(ctx, 0)
+}
+
+func (m *multiSession) ExecQuery(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
+	result, err := m.primary.ExecQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range m.secondaries {
+		if _, err = s.ExecQuery(ctx, query, args...); err != nil {
+			return nil, fmt.Errorf("cransaction: secondary session %d: %w", i, err)
+		}
+	}
+	return result, nil
+}
+
+func (m *multiSession) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	result, err := m.ExecQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return execResult(result)
+}
+
+func (m *multiSession) Driver() string {
+	return m.primary.Driver()
+}
+
+func (m *multiSession) QueryRow(ctx context.Context, query string, args ...interface{}) interface{} {
+	return m.primary.QueryRow(ctx, query, args...)
+}
+
+func (m *multiSession) QueryRows(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
+	return m.primary.QueryRows(ctx, query, args...)
+}
@@ -0,0 +1,46 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestMultiSession(t *testing.T) {
+	ctx := context.Background()
+	oldDB, primary := newFakeSession(t, "postgres")
+	newDB, secondary := newFakeSession(t, "postgres")
+	oldDB.on("SELECT", rowsOf([]string{"n"}, []driver.Value{int64(1)}))
+	m := MultiSession(primary, secondary)
+
+	err := m.Transaction(ctx, func(ctx context.Context) error {
+		if _, err := m.ExecQuery(ctx, "INSERT INTO users VALUES (1)"); err != nil {
+			return err
+		}
+		_, err := QueryValue[int64](ctx, m, "SELECT COUNT(*) FROM users")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, oldDB, "BEGIN", "INSERT INTO users VALUES (1)", "SELECT COUNT(*) FROM users", "COMMIT")
+	wantStatements(t, newDB, "BEGIN", "INSERT INTO users VALUES (1)", "COMMIT")
+}
+
+func TestMultiSessionRollsBackAll(t *testing.T) {
+	oldDB, primary := newFakeSession(t, "postgres")
+	newDB, secondary := newFakeSession(t, "postgres")
+	newDB.fail("INSERT", errFake)
+	m := MultiSession(primary, secondary)
+
+	err := m.Transaction(context.Background(), func(ctx context.Context) error {
+		_, err := m.ExecQuery(ctx, "INSERT INTO users VALUES (1)")
+		return err
+	})
+	if !errors.Is(err, errFake) {
+		t.Fatalf("err = %v", err)
+	}
+	wantStatements(t, oldDB, "BEGIN", "INSERT INTO users VALUES (1)", "ROLLBACK")
+	wantStatements(t, newDB, "BEGIN", "INSERT INTO users VALUES (1)", "ROLLBACK")
+}
@@ -0,0 +1,192 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// WithSlowQueryThreshold Log statements that take at least d to run
+func WithSlowQueryThreshold(d time.Duration) Option {
+	return func(o *options) {
+		o.slowThreshold = d
+	}
+}
+
+// WithExplainOnSlow Log the EXPLAIN plan of SELECT statements crossing the slow query threshold
+//
+// The plan is fetched with EXPLAIN, never EXPLAIN ANALYZE, so the statement is not run again. It is fetched on
+// the connection the statement ran on, its transaction or the one pinned by WithFreshConn, once the result no
+// longer holds it: when the row is scanned or the rows are closed. Inside a transaction the EXPLAIN runs in a
+// savepoint, and is skipped once a statement of the transaction failed as Postgres then rejects everything
+func WithExplainOnSlow(enabled bool) Option {
+	return func(o *options) {
+		o.explainOnSlow = enabled
+	}
+}
+
+// explainFunc Run a statement on the connection the explained statement ran on, see WithExplainOnSlow
+type explainFunc func(ctx context.Context, query string, args []interface{}) (*sql.Rows, error)
+
+// WithUseGormLogger Leave query logging of a Gorm session to the logger of its gorm.Config, which already logs
+// every statement the session runs, instead of also logging slow queries and their plans through WithLogger
+//
+// Hooks and the other warnings of the session are unaffected, SQL sessions ignore it
+func WithUseGormLogger(enabled bool) Option {
+	return func(o *options) {
+		o.useGormLogger = enabled
+	}
+}
+
+// explainTimeout Bound of the EXPLAIN of a slow query, it runs under a context of its own as the one of the
+// statement may be done by the time the result is released
+const explainTimeout = 5 * time.Second
+
+// afterQuery Record the outcome of a statement that started at start and, with logSlow, report it when slow
+//
+// The returned func logs the plan of a slow SELECT and is nil when there is none to log, the caller runs it once
+// the result of the statement released its connection
+func (o *options) afterQuery(ctx context.Context, st *txState, query string, args []interface{}, start time.Time, err error, explain explainFunc, logSlow bool) func() {
+	elapsed := o.since(start)
+	if st != nil {
+		st.lastQuery = query
+		st.failed = st.failed || st.abortedBy(err)
+		st.stats.Queries++
+		st.stats.TotalQueryDuration += elapsed
+	}
+	for _, hook := range o.afterQueryHooks {
+		hook(ctx, query, args, elapsed, err)
+	}
+	if !logSlow || o.slowThreshold <= 0 || elapsed < o.slowThreshold {
+		return nil
+	}
+	o.logf("cransaction: slow query took %s: %s", elapsed, query)
+	if !o.explainOnSlow || err != nil || !isSelect(query) {
+		return nil
+	}
+	return func() {
+		// A statement run since then may have aborted the transaction
+		if st.aborted() {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), explainTimeout)
+		defer cancel()
+		plan, err := fetchPlan(ctx, st, explain, query, args)
+		if err != nil {
+			o.logf("cransaction: explain failed for %s: %v", query, err)
+			return
+		}
+		o.logf("cransaction: plan for slow query %s:\n%s", query, plan)
+	}
+}
+
+// andExplain Release a result with cancel, then log the plan returned by afterQuery when there is one
+func andExplain(cancel context.CancelFunc, explain func()) func() {
+	if explain == nil {
+		return cancel
+	}
+	return func() {
+		cancel()
+		explain()
+	}
+}
+
+// explainSavepoint Savepoint the EXPLAIN of a statement of a transaction runs in, on Postgres a failing EXPLAIN
+// would abort the transaction
+const explainSavepoint = "cransaction_explain"
+
+// fetchPlan Fetch the plan of query with run, inside explainSavepoint when st is a transaction
+func fetchPlan(ctx context.Context, st *txState, run explainFunc, query string, args []interface{}) (string, error) {
+	if st == nil {
+		return explainPlan(run(ctx, "EXPLAIN "+query, args))
+	}
+	if err := closeRows(run(ctx, "SAVEPOINT "+explainSavepoint, nil)); err != nil {
+		st.failed = true
+		return "", err
+	}
+	plan, err := explainPlan(run(ctx, "EXPLAIN "+query, args))
+	if err != nil {
+		if rbErr := closeRows(run(ctx, "ROLLBACK TO SAVEPOINT "+explainSavepoint, nil)); rbErr != nil {
+			st.failed = true
+			return "", errors.Join(err, rbErr)
+		}
+		return "", err
+	}
+	return plan, closeRows(run(ctx, "RELEASE SAVEPOINT "+explainSavepoint, nil))
+}
+
+// closeRows Close the empty result of a statement run with an explainFunc, such as SAVEPOINT
+func closeRows(rows *sql.Rows, err error) error {
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// explainPlan Render the EXPLAIN result, one line per row with columns separated by " | "
+func explainPlan(rows *sql.Rows, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	values := make([]sql.NullString, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	var lines []string
+	for rows.Next() {
+		if err = rows.Scan(targets...); err != nil {
+			return "", err
+		}
+		cells := make([]string, len(values))
+		for i, v := range values {
+			cells[i] = v.String
+		}
+		lines = append(lines, strings.Join(cells, " | "))
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// statementVerb First keyword of query in upper case, skipping leading whitespace and comments
+func statementVerb(query string) string {
+	q := query
+	for {
+		q = strings.TrimLeft(q, " \t\r\n(")
+		switch {
+		case strings.HasPrefix(q, "--"):
+			if i := strings.IndexByte(q, '\n'); i >= 0 {
+				q = q[i+1:]
+				continue
+			}
+			return ""
+		case strings.HasPrefix(q, "/*"):
+			if i := strings.Index(q, "*/"); i >= 0 {
+				q = q[i+2:]
+				continue
+			}
+			return ""
+		}
+		end := strings.IndexFunc(q, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+		})
+		if end < 0 {
+			end = len(q)
+		}
+		return strings.ToUpper(q[:end])
+	}
+}
+
+func isSelect(query string) bool {
+	verb := statementVerb(query)
+	return verb == "SELECT" || verb == "WITH"
+}
@@ -0,0 +1,293 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+// slowly Answer with res after moving clock past the slow query threshold of the tests
+func slowly(clock *fakeClock, res *fakeResult) func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+	return func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+		clock.Advance(2 * time.Second)
+		return res, nil
+	}
+}
+
+func TestExplainOnSlow(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			clock, log := newFakeClock(), &testLogger{}
+			f, s := open(t, "postgres", WithClock(clock), WithLogger(log), WithSlowQueryThreshold(time.Second), WithExplainOnSlow(true))
+			f.on("EXPLAIN", rowsOf([]string{"QUERY PLAN"}, []driver.Value{"Seq Scan on users"}, []driver.Value{"  Filter: (active)"}))
+			f.handle("SELECT", slowly(clock, rowsOf([]string{"id"}, []driver.Value{int64(1)})))
+			f.handle("UPDATE", slowly(clock, nil))
+			ctx := context.Background()
+
+			if _, err := QueryValue[int64](ctx, s, "SELECT id FROM users WHERE active"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.ExecQuery(ctx, "UPDATE users SET active = false"); err != nil {
+				t.Fatal(err)
+			}
+			plans := log.logged("plan for slow query")
+			if len(plans) != 1 || !strings.HasSuffix(plans[0], "SELECT id FROM users WHERE active:\nSeq Scan on users\n  Filter: (active)") {
+				t.Fatalf("plans = %q", plans)
+			}
+			if n := len(log.logged("slow query took 2s")); n != 2 {
+				t.Errorf("%d slow queries logged, want 2", n)
+			}
+			if n := f.count("EXPLAIN"); n != 1 {
+				t.Errorf("%d EXPLAIN sent, want 1", n)
+			}
+		})
+	}
+}
+
+func TestExplainOnSlowInTransaction(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			clock, log := newFakeClock(), &testLogger{}
+			f, s := open(t, "postgres", WithClock(clock), WithLogger(log), WithSlowQueryThreshold(time.Second), WithExplainOnSlow(true))
+			f.on("EXPLAIN", rowsOf([]string{"QUERY PLAN"}, []driver.Value{"Index Scan using users_pkey on users"}))
+			f.handle("SELECT", slowly(clock, rowsOf([]string{"id"}, []driver.Value{int64(1)})))
+			f.fail("UPDATE", errFake)
+			// The transaction holds the only connection, the plan must be fetched on it
+			setMaxOpenConns(t, s, 1)
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			err := s.Transaction(ctx, func(ctx context.Context) error {
+				_, err := QueryValue[int64](ctx, s, "SELECT id FROM users WHERE id = 1")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if failed := log.logged("explain failed"); len(failed) != 0 {
+				t.Fatalf("explain failed: %q", failed)
+			}
+			plans := log.logged("plan for slow query")
+			if len(plans) != 1 || !strings.HasSuffix(plans[0], "WHERE id = 1:\nIndex Scan using users_pkey on users") {
+				t.Fatalf("plans = %q", plans)
+			}
+			if e := f.last("EXPLAIN"); !e.inTx {
+				t.Error("the EXPLAIN ran outside the transaction")
+			}
+			wantStatements(t, f, "BEGIN", "SELECT id FROM users WHERE id = 1", "SAVEPOINT cransaction_explain",
+				"EXPLAIN SELECT id FROM users WHERE id = 1", "RELEASE SAVEPOINT cransaction_explain", "COMMIT")
+
+			// Postgres rejects every statement of a transaction after a failed one, an EXPLAIN included
+			err = s.Transaction(ctx, func(ctx context.Context) error {
+				if _, err := s.ExecQuery(ctx, "UPDATE users SET active = false"); !errors.Is(err, errFake) {
+					t.Errorf("update err = %v", err)
+				}
+				_, err := QueryValue[int64](ctx, s, "SELECT id FROM users WHERE id = 2")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := f.count("EXPLAIN"); n != 1 {
+				t.Errorf("%d EXPLAIN sent, want none in the aborted transaction", n-1)
+			}
+			if n := len(log.logged("slow query took")); n != 2 {
+				t.Errorf("%d slow queries logged, want 2", n)
+			}
+		})
+	}
+}
+
+func TestExplainOnSlowAfterResultReleased(t *testing.T) {
+	clock, log := newFakeClock(), &testLogger{}
+	f, r := newFakeSession(t, "postgres", WithClock(clock), WithLogger(log), WithSlowQueryThreshold(time.Second), WithExplainOnSlow(true))
+	f.on("EXPLAIN", rowsOf([]string{"QUERY PLAN"}, []driver.Value{"Seq Scan on users"}))
+	f.handle("SELECT", slowly(clock, rowsOf([]string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)})))
+	// The result holds the only connection until it is released, an EXPLAIN sent before would wait for it
+	r.db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := r.QueryRows(ctx, "SELECT id FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := result.(*ContextRows)
+	for rows.Next() {
+	}
+	if n := f.count("EXPLAIN"); n != 0 {
+		t.Errorf("%d EXPLAIN sent while the rows were open", n)
+	}
+	if err = rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var id int64
+	row := r.QueryRow(ctx, "SELECT id FROM users LIMIT 1").(Row)
+	if n := f.count("EXPLAIN"); n != 1 {
+		t.Errorf("%d EXPLAIN sent before the row was scanned, want the one of the rows", n)
+	}
+	if err = row.Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if failed := log.logged("explain failed"); len(failed) != 0 {
+		t.Fatalf("explain failed: %q", failed)
+	}
+	if n := len(log.logged("plan for slow query")); n != 2 {
+		t.Errorf("%d plans logged, want 2", n)
+	}
+}
+
+func TestExplainOnSlowRowTypeIndependentOfLatency(t *testing.T) {
+	clock := newFakeClock()
+	f, r := newFakeSession(t, "postgres", WithClock(clock), WithLogger(&testLogger{}), WithSlowQueryThreshold(time.Second), WithExplainOnSlow(true))
+	f.on("EXPLAIN", rowsOf([]string{"QUERY PLAN"}, []driver.Value{"Seq Scan on reports"}))
+	f.handle("FROM reports", slowly(clock, rowsOf([]string{"id"}, []driver.Value{int64(1)})))
+	f.on("FROM users", rowsOf([]string{"id"}, []driver.Value{int64(1)}))
+	ctx := context.Background()
+	fast := r.QueryRow(ctx, "SELECT id FROM users")
+	slow := r.QueryRow(ctx, "SELECT id FROM reports")
+	if fmt.Sprintf("%T", fast) != fmt.Sprintf("%T", slow) {
+		t.Errorf("QueryRow returned %T for a fast query and %T for a slow one", fast, slow)
+	}
+	for _, row := range []interface{}{fast, slow} {
+		var id int64
+		if err := row.(Row).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTxSummary(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			var summaries []Event
+			f, s := open(t, "postgres", WithClock(clock), WithEventHandler(func(_ context.Context, e Event) {
+				if e.Summary != nil {
+					summaries = append(summaries, e)
+				}
+			}))
+			for pattern, affected := range map[string]int64{"UPDATE": 2, "INSERT": 1, "DELETE": 5, "SELECT": 0} {
+				f.handle(pattern, func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+					clock.Advance(10 * time.Millisecond)
+					return &fakeResult{affected: affected}, nil
+				})
+			}
+			err := s.Transaction(context.Background(), func(ctx context.Context) error {
+				if _, err := s.ExecQuery(ctx, "UPDATE accounts SET balance = 0"); err != nil {
+					return err
+				}
+				if _, err := s.ExecQuery(ctx, "INSERT INTO audit VALUES (1)"); err != nil {
+					return err
+				}
+				rows, err := queryRows(ctx, s, "SELECT id FROM accounts")
+				if err != nil {
+					return err
+				}
+				if err = rows.Close(); err != nil {
+					return err
+				}
+				err = s.Transaction(ctx, func(ctx context.Context) error {
+					if _, err := s.ExecQuery(ctx, "DELETE FROM sessions"); err != nil {
+						return err
+					}
+					return errFake
+				})
+				if !errors.Is(err, errFake) {
+					t.Errorf("savepoint err = %v", err)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(summaries) != 2 {
+				t.Fatalf("%d events with a summary, want 2", len(summaries))
+			}
+			want := TxSummary{Queries: 1, TotalQueryDuration: 10 * time.Millisecond, RowsAffected: 5}
+			if e := summaries[0]; e.Type != TxRollback || *e.Summary != want {
+				t.Errorf("savepoint %v summary = %+v, want %+v", e.Type, *e.Summary, want)
+			}
+			want = TxSummary{Queries: 4, TotalQueryDuration: 40 * time.Millisecond, RowsAffected: 3, Committed: true}
+			if e := summaries[1]; e.Type != TxCommit || *e.Summary != want {
+				t.Errorf("transaction %v summary = %+v, want %+v", e.Type, *e.Summary, want)
+			}
+		})
+	}
+}
+
+func TestTxSummaryCommitFailed(t *testing.T) {
+	var summary *TxSummary
+	f, r := newFakeSession(t, "postgres", WithEventHandler(func(_ context.Context, e Event) {
+		summary = e.Summary
+	}))
+	f.on("UPDATE", &fakeResult{affected: 1})
+	f.fail("COMMIT", errFake)
+	err := r.Transaction(context.Background(), func(ctx context.Context) error {
+		_, err := r.ExecQuery(ctx, "UPDATE accounts SET balance = 0")
+		return err
+	})
+	if !errors.Is(err, ErrCommitFailed) {
+		t.Fatalf("err = %v", err)
+	}
+	if summary == nil || summary.Committed || summary.Queries != 1 || summary.RowsAffected != 1 {
+		t.Errorf("summary = %+v, want one uncommitted statement", summary)
+	}
+}
+
+func TestUseGormLogger(t *testing.T) {
+	clock, log, gormLog := newFakeClock(), &testLogger{}, &testLogger{}
+	f, g := newFakeGorm(t, "postgres", WithClock(clock), WithLogger(log), WithSlowQueryThreshold(time.Second),
+		WithExplainOnSlow(true), WithUseGormLogger(true))
+	g.db.Logger = logger.New(gormLog, logger.Config{LogLevel: logger.Info})
+	f.handle("SELECT", slowly(clock, rowsOf([]string{"id"}, []driver.Value{int64(1)})))
+	f.handle("UPDATE", slowly(clock, nil))
+	ctx := context.Background()
+	if _, err := QueryValue[int64](ctx, g, "SELECT id FROM users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.ExecQuery(ctx, "UPDATE users SET active = false"); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.lines) != 0 {
+		t.Errorf("package logger got %q", log.lines)
+	}
+	if f.count("EXPLAIN") != 0 {
+		t.Errorf("statements = %v", f.statements())
+	}
+	if len(gormLog.logged("SELECT id FROM users")) != 1 || len(gormLog.logged("UPDATE users SET active = false")) != 1 {
+		t.Errorf("gorm logger got %q, want each statement once", gormLog.lines)
+	}
+
+	// SQL sessions have no Gorm logger to defer to
+	clock, log = newFakeClock(), &testLogger{}
+	f, r := newFakeSession(t, "postgres", WithClock(clock), WithLogger(log), WithSlowQueryThreshold(time.Second), WithUseGormLogger(true))
+	f.handle("UPDATE", slowly(clock, nil))
+	if _, err := r.ExecQuery(ctx, "UPDATE users SET active = false"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(log.logged("slow query took 2s")); n != 1 {
+		t.Errorf("%d slow queries logged by the SQL session, want 1", n)
+	}
+}
+
+// setMaxOpenConns Limit the pool of the *sql.DB under a session of either backend
+func setMaxOpenConns(t *testing.T, s ITransaction, n int) {
+	t.Helper()
+	switch s := s.(type) {
+	case *RDMSSession:
+		s.db.SetMaxOpenConns(n)
+	case *GormSession:
+		db, err := s.db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SetMaxOpenConns(n)
+	}
+}
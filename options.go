@@ -0,0 +1,717 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Option Configure optional session behavior
+type Option func(*options)
+
+type options struct {
+	eventHandler EventHandler
+	rewriters    []QueryRewriter
+	// beginHooks, afterBeginHooks Run right before and right after the database transaction begins
+	beginHooks      []func(ctx context.Context) error
+	afterBeginHooks []func(ctx context.Context) error
+	logger          Logger
+	// detectRowsLeaks Track rows handed out inside transactions and warn about the ones left open
+	detectRowsLeaks    bool
+	sqlCommenter       bool
+	commitOnCancel     bool
+	queryRetries       int
+	retryPolicy        RetryPolicy
+	autoReconnect      bool
+	slowThreshold      time.Duration
+	explainOnSlow      bool
+	typeConverters     map[string]func([]byte) (interface{}, error)
+	cache              QueryCache
+	cacheTTL           time.Duration
+	cacheInvalidation  bool
+	beforeQueryHooks   []BeforeQueryHook
+	afterQueryHooks    []AfterQueryHook
+	clock              Clock
+	placeholderStyle   PlaceholderStyle
+	partialOnTimeout   bool
+	errorMapper        func(err error) error
+	applicationName    string
+	requireTransaction bool
+	hedgeDelay         time.Duration
+	unmappedColumns    UnmappedColumnPolicy
+	txRegistry         bool
+	queryTimeout       time.Duration
+	savepointNamer     func(depth int) string
+	auditKey           interface{}
+	auditSetting       string
+	txBackoff          backoff
+	rand               *lockedRand
+	autoTransaction    bool
+	nilHandling        NilHandling
+	txOptions          *sql.TxOptions
+	maxTxDuration      time.Duration
+	useGormLogger      bool
+	valueConverters    []ValueConverter
+}
+
+// optionsProvider Session exposing its options to the generic helpers
+type optionsProvider interface {
+	sessionOptions() *options
+}
+
+func (r *RDMSSession) sessionOptions() *options {
+	return &r.opts
+}
+
+func (g *GormSession) sessionOptions() *options {
+	return &g.opts
+}
+
+func (m *multiSession) sessionOptions() *options {
+	return optionsOf(m.primary)
+}
+
+func (a *autocommitSession) sessionOptions() *options {
+	return optionsOf(a.ITransaction)
+}
+
+func (s *replicaSession) sessionOptions() *options {
+	return optionsOf(s.primary)
+}
+
+// optionsOf Options of tx, the defaults for sessions that do not expose theirs
+func optionsOf(tx ITransaction) *options {
+	if p, ok := tx.(optionsProvider); ok {
+		return p.sessionOptions()
+	}
+	return &options{}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// EventType Kind of transaction event
+type EventType int
+
+const (
+	// TxBegin Transaction started
+	TxBegin EventType = iota
+	// TxCommit Transaction committed, Err is set when the commit failed
+	TxCommit
+	// TxRollback Transaction rolled back, Err is the error that caused it
+	TxRollback
+)
+
+// Event Transaction lifecycle event
+type Event struct {
+	Type EventType
+	// Name Transaction name given to TransactionNamed, empty for unnamed transactions
+	Name string
+	// Duration Time since the transaction began, zero for TxBegin
+	Duration time.Duration
+	Err      error
+	// RollbackReason What caused a TxRollback, RollbackNone for the other events
+	RollbackReason RollbackReason
+	// Summary Cost of the transaction, set on TxCommit and TxRollback
+	Summary *TxSummary
+}
+
+// TxSummary Statements run by a transaction, the ones of the savepoints it took included
+type TxSummary struct {
+	// Queries Number of statements run through ExecQuery, QueryRow and QueryRows
+	Queries int
+	// TotalQueryDuration Time spent in those statements, reading rows after QueryRows returned excluded
+	TotalQueryDuration time.Duration
+	// RowsAffected Rows written by ExecQuery, not counting savepoints rolled back
+	RowsAffected int64
+	// Committed The transaction committed, false when it rolled back or its commit failed
+	Committed bool
+}
+
+// summary Summary of st once it ended, committed tells whether its commit succeeded
+func (st *txState) summary(committed bool) *TxSummary {
+	s := st.stats
+	s.Committed = committed
+	return &s
+}
+
+// RollbackReason Cause of a rollback reported in TxRollback events
+type RollbackReason int
+
+const (
+	// RollbackNone Event is not a rollback
+	RollbackNone RollbackReason = iota
+	// RollbackError fn, a pre-commit hook or the caller of finish returned an error
+	RollbackError
+	// RollbackPanic fn panicked, the panic is propagated once the transaction is rolled back
+	RollbackPanic
+	// RollbackCanceled The context of the transaction was done when it ended
+	RollbackCanceled
+	// RollbackExpired The transaction outlived WithMaxTransactionDuration and was rolled back in the background
+	RollbackExpired
+)
+
+func (r RollbackReason) String() string {
+	switch r {
+	case RollbackError:
+		return "error"
+	case RollbackPanic:
+		return "panic"
+	case RollbackCanceled:
+		return "canceled"
+	case RollbackExpired:
+		return "expired"
+	}
+	return "none"
+}
+
+// EventHandler Receive transaction lifecycle events
+type EventHandler func(ctx context.Context, e Event)
+
+// WithEventHandler Report transaction begin, commit and rollback to h
+func WithEventHandler(h EventHandler) Option {
+	return func(o *options) {
+		o.eventHandler = h
+	}
+}
+
+func (o *options) emit(ctx context.Context, e Event) {
+	if o.eventHandler != nil {
+		o.eventHandler(ctx, e)
+	}
+}
+
+// QueryRewriter Transform a query before it runs, a non-nil error blocks the query
+type QueryRewriter func(ctx context.Context, query string) (string, error)
+
+// WithQueryRewriter Run rw on every query passed to ExecQuery, QueryRow and QueryRows
+//
+// Rewriters chain in registration order, each one receives the output of the previous
+func WithQueryRewriter(rw QueryRewriter) Option {
+	return func(o *options) {
+		o.rewriters = append(o.rewriters, rw)
+	}
+}
+
+// BeforeQueryHook Rewrite a query and its args before it runs, a non-nil error blocks the query
+type BeforeQueryHook func(ctx context.Context, query string, args []interface{}) (string, []interface{}, error)
+
+// AfterQueryHook Observe a statement once it ran, with the query and args that were sent to the database
+type AfterQueryHook func(ctx context.Context, query string, args []interface{}, elapsed time.Duration, err error)
+
+// WithBeforeQuery Run hook on every query passed to ExecQuery, QueryRow and QueryRows
+//
+// Hooks run in registration order after the query rewriters, each one receives the output of the previous
+func WithBeforeQuery(hook BeforeQueryHook) Option {
+	return func(o *options) {
+		o.beforeQueryHooks = append(o.beforeQueryHooks, hook)
+	}
+}
+
+// WithAfterQuery Call hook after every statement run by ExecQuery, QueryRow and QueryRows, in registration order
+func WithAfterQuery(hook AfterQueryHook) Option {
+	return func(o *options) {
+		o.afterQueryHooks = append(o.afterQueryHooks, hook)
+	}
+}
+
+// prepare Produce the query and args that are actually sent to the database
+func (o *options) prepare(ctx context.Context, query string, args []interface{}) (string, []interface{}, error) {
+	query, args, err := o.prepareUncommented(ctx, query, args)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.annotate(ctx, query), args, nil
+}
+
+// prepareUncommented Rewrite query and convert args as prepare does, without the comments of annotate, so the
+// query keys the cache whatever the tags and label of the call
+func (o *options) prepareUncommented(ctx context.Context, query string, args []interface{}) (string, []interface{}, error) {
+	for _, rewrite := range o.rewriters {
+		var err error
+		if query, err = rewrite(ctx, query); err != nil {
+			return "", nil, err
+		}
+	}
+	for _, hook := range o.beforeQueryHooks {
+		var err error
+		if query, args, err = hook(ctx, query, args); err != nil {
+			return "", nil, err
+		}
+	}
+	args, err := o.convertValues(args)
+	if err != nil {
+		return "", nil, err
+	}
+	if o.nilHandling == NilAsNull {
+		args = nilsAsNull(args)
+	}
+	return query, args, nil
+}
+
+// annotate Append the sqlcommenter tags and the label of ctx to query
+func (o *options) annotate(ctx context.Context, query string) string {
+	if o.sqlCommenter {
+		query = appendComment(ctx, query)
+	}
+	return appendLabel(ctx, query)
+}
+
+// NilHandling How nil pointers passed as args reach the driver, see WithNilHandling
+type NilHandling int
+
+const (
+	// NilPassthrough Hand args to database/sql unchanged, the default
+	NilPassthrough NilHandling = iota
+	// NilAsNull Send a nil pointer of any type as an untyped nil, bound as SQL NULL by every driver
+	NilAsNull
+)
+
+// WithNilHandling Choose how ExecQuery, QueryRow and QueryRows pass nil pointer args to the driver
+//
+// With NilAsNull a (*string)(nil) is sent as NULL even when its type implements driver.Valuer with a pointer
+// receiver or the driver rejects the typed nil
+func WithNilHandling(policy NilHandling) Option {
+	return func(o *options) {
+		o.nilHandling = policy
+	}
+}
+
+// nilsAsNull Copy of args with the nil pointers replaced by nil
+func nilsAsNull(args []interface{}) []interface{} {
+	var out []interface{}
+	for i, arg := range args {
+		if v := reflect.ValueOf(arg); v.Kind() != reflect.Pointer || !v.IsNil() {
+			continue
+		}
+		if out == nil {
+			out = append([]interface{}(nil), args...)
+		}
+		out[i] = nil
+	}
+	if out == nil {
+		return args
+	}
+	return out
+}
+
+// ValueConverter Turn an arg into the value sent to the driver, handled is false to leave the arg to the
+// next converter
+type ValueConverter func(v interface{}) (value driver.Value, handled bool, err error)
+
+// WithValueConverter Convert the args of ExecQuery, QueryRow and QueryRows with convert before they reach the
+// driver, for types needing a SQL form of their own that cannot implement driver.Valuer, such as types of
+// other packages
+//
+// Converters run in registration order and the first one handling an arg wins, args no converter handles
+// are passed unchanged
+func WithValueConverter(convert ValueConverter) Option {
+	return func(o *options) {
+		o.valueConverters = append(o.valueConverters, convert)
+	}
+}
+
+// convertValues Copy of args with the values of WithValueConverter, args itself when no converter applies
+func (o *options) convertValues(args []interface{}) ([]interface{}, error) {
+	if len(o.valueConverters) == 0 {
+		return args, nil
+	}
+	var out []interface{}
+	for i, arg := range args {
+		for _, convert := range o.valueConverters {
+			value, handled, err := convert(arg)
+			if err != nil {
+				return nil, fmt.Errorf("cransaction: arg %d: %w", i+1, err)
+			}
+			if !handled {
+				continue
+			}
+			if out == nil {
+				out = append([]interface{}(nil), args...)
+			}
+			out[i] = value
+			break
+		}
+	}
+	if out == nil {
+		return args, nil
+	}
+	return out, nil
+}
+
+// WithBeginHook Call hook right before a transaction begins, a non-nil error aborts the begin and is returned
+func WithBeginHook(hook func(ctx context.Context) error) Option {
+	return func(o *options) {
+		o.beginHooks = append(o.beginHooks, hook)
+	}
+}
+
+// WithAfterBeginHook Call hook right after a transaction began, a non-nil error rolls it back and is returned
+func WithAfterBeginHook(hook func(ctx context.Context) error) Option {
+	return func(o *options) {
+		o.afterBeginHooks = append(o.afterBeginHooks, hook)
+	}
+}
+
+func runHooks(ctx context.Context, hooks []func(ctx context.Context) error) error {
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithApplicationName Tag every Postgres transaction with name, so pg_stat_activity shows which component holds it
+//
+// The name is set with SET LOCAL application_name at the start of the transaction, other drivers ignore it
+func WithApplicationName(name string) Option {
+	return func(o *options) {
+		o.applicationName = name
+	}
+}
+
+// setup Statements run at the start of every database transaction on dialect
+func (o *options) setup(dialect string) []string {
+	var stmts []string
+	if o.applicationName != "" && dialect == "postgres" {
+		stmts = append(stmts, "SET LOCAL application_name = "+quoteLiteral(o.applicationName))
+	}
+	return stmts
+}
+
+// WithRequireTransaction Make ExecQuery, QueryRow and QueryRows fail with ErrNoActiveTransaction outside a
+// transaction of the session instead of running autocommit. Off by default
+func WithRequireTransaction(enabled bool) Option {
+	return func(o *options) {
+		o.requireTransaction = enabled
+	}
+}
+
+// checkTx Enforce WithRequireTransaction and WithMaxTransactionDuration for a statement issued with ctx on the
+// session of base handle owner
+func (o *options) checkTx(ctx context.Context, owner interface{}) error {
+	st := stateFor(ctx, owner)
+	if o.requireTransaction && st == nil && ctx.Value(nonTransactionalKey{}) == nil {
+		return ErrNoActiveTransaction
+	}
+	if st != nil && st.root().expired.Load() {
+		return ErrTransactionExpired
+	}
+	return nil
+}
+
+// WithMaxTransactionDuration Roll back a database transaction still open d after it began, as a safety net for
+// transactions leaked by a missing finish or a stuck fn
+//
+// The rollback happens in the background and is logged. The statements issued in the transaction afterwards
+// and the end of the transaction fail with ErrTransactionExpired. The timer stops when the transaction ends.
+// Statements of the transaction run under the sooner of their context deadline and the expiry, so one still
+// running when the transaction expires is cancelled rather than holding up the rollback
+func WithMaxTransactionDuration(d time.Duration) Option {
+	return func(o *options) {
+		o.maxTxDuration = d
+	}
+}
+
+// startExpiry Arm the timer of WithMaxTransactionDuration for the database transaction st
+func (o *options) startExpiry(st *txState) {
+	if o.maxTxDuration <= 0 || st.outer != nil {
+		return
+	}
+	st.deadline = o.now().Add(o.maxTxDuration)
+	st.expiryDone = make(chan struct{})
+	st.stopExpiry = o.afterFunc(o.maxTxDuration, func() {
+		defer close(st.expiryDone)
+		st.expired.Store(true)
+		_ = st.ender.Rollback()
+		o.logf("cransaction: transaction %q rolled back after exceeding %s", st.name, o.maxTxDuration)
+	})
+}
+
+// WithQueryTimeout Bound every ExecQuery, QueryRow and QueryRows issued outside a transaction to d, a sooner
+// deadline already on the context is kept
+//
+// For QueryRow and QueryRows the bound also covers reading the result, which stays usable until d elapses.
+// The timeout of a row is released when Scan returns, the one of rows once they are closed
+func WithQueryTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.queryTimeout = d
+	}
+}
+
+// withQueryTimeout Bound a statement issued with ctx to its effective deadline, st is the transaction of ctx if
+// any. timed is false when ctx is returned as is, the deadline it has already being the sooner one
+func (o *options) withQueryTimeout(ctx context.Context, st *txState) (_ context.Context, _ context.CancelFunc, timed bool) {
+	limit, ok := o.sessionDeadline(st)
+	if deadline, bounded := ctx.Deadline(); !ok || (bounded && !o.fromWall(deadline).After(limit)) {
+		return ctx, func() {}, false
+	}
+	ctx, cancel := context.WithDeadline(ctx, o.toWall(limit))
+	return ctx, cancel, true
+}
+
+// releasingRow Row holding resources of the statement until Scan, such as the timeout context of
+// withQueryTimeout, released by Scan
+type releasingRow struct {
+	Row
+	release func()
+}
+
+func (r *releasingRow) Scan(dest ...interface{}) error {
+	defer r.release()
+	return r.Row.Scan(dest...)
+}
+
+// releaseOnScan Wrap row so scanning it calls release, the row QueryRow returns is a releasingRow with a query
+// timeout, a transaction duration or plans of slow queries, whatever the statement and its context
+func (o *options) releaseOnScan(row Row, release func()) Row {
+	if o.queryTimeout <= 0 && o.maxTxDuration <= 0 && !o.explainOnSlow {
+		release()
+		return row
+	}
+	return &releasingRow{Row: row, release: release}
+}
+
+// releaseOnClose Wrap rows so closing them also calls release, database/sql closes them itself when the deadline
+// of their context passes first
+func releaseOnClose(rows *sql.Rows, release func()) *ContextRows {
+	return &ContextRows{Rows: rows, release: release}
+}
+
+// effectiveDeadline Deadline a statement issued with ctx runs under, the sooner of the one of ctx and the one
+// set by the session, ok is false when neither sets one
+func (o *options) effectiveDeadline(ctx context.Context, st *txState) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+	if ok {
+		deadline = o.fromWall(deadline)
+	}
+	if limit, limited := o.sessionDeadline(st); limited && (!ok || limit.Before(deadline)) {
+		return limit, true
+	}
+	return deadline, ok
+}
+
+// EffectiveDeadline Deadline a statement issued with ctx would run under, the sooner of the deadline of ctx
+// and the one set by the session: the expiry of WithMaxTransactionDuration inside a transaction of the session,
+// now plus WithQueryTimeout outside one. ok is false when neither sets a deadline. The time is the one of the
+// Clock of WithClock
+func (r *RDMSSession) EffectiveDeadline(ctx context.Context) (deadline time.Time, ok bool) {
+	return r.opts.effectiveDeadline(ctx, stateFor(ctx, r.db))
+}
+
+// EffectiveDeadline Deadline a statement issued with ctx would run under, the sooner of the deadline of ctx
+// and the one set by the session: the expiry of WithMaxTransactionDuration inside a transaction of the session,
+// now plus WithQueryTimeout outside one. ok is false when neither sets a deadline. The time is the one of the
+// Clock of WithClock
+func (g *GormSession) EffectiveDeadline(ctx context.Context) (deadline time.Time, ok bool) {
+	return g.opts.effectiveDeadline(ctx, stateFor(ctx, g.db))
+}
+
+// sessionDeadline Deadline set by the session: the expiry of WithMaxTransactionDuration inside a transaction,
+// WithQueryTimeout from now outside one
+func (o *options) sessionDeadline(st *txState) (time.Time, bool) {
+	if st != nil {
+		deadline := st.root().deadline
+		return deadline, !deadline.IsZero()
+	}
+	if o.queryTimeout <= 0 {
+		return time.Time{}, false
+	}
+	return o.now().Add(o.queryTimeout), true
+}
+
+// WithSavepointNamer Name the savepoint of a nested Transaction with namer, given the depth the nested
+// transaction runs at, starting at 2. By default the name is sp_<depth>
+//
+// Names that are not plain identifiers make the nested Transaction fail with ErrBeginFailed
+func WithSavepointNamer(namer func(depth int) string) Option {
+	return func(o *options) {
+		o.savepointNamer = namer
+	}
+}
+
+func (o *options) savepointName(depth int) string {
+	if o.savepointNamer == nil {
+		return fmt.Sprintf("sp_%d", depth)
+	}
+	return o.savepointNamer(depth)
+}
+
+// WithAutoTransaction Run every write issued through ExecQuery outside a transaction of the session in a
+// transaction of its own, so it is committed explicitly and a failed commit is reported. Reads stay autocommit
+//
+// ExecNonTransactional is never wrapped
+func WithAutoTransaction(enabled bool) Option {
+	return func(o *options) {
+		o.autoTransaction = enabled
+	}
+}
+
+// autoTx Report whether WithAutoTransaction wraps query issued with ctx on the session of base handle owner
+func (o *options) autoTx(ctx context.Context, owner interface{}, query string) bool {
+	return o.autoTransaction && isWrite(query) && stateFor(ctx, owner) == nil && ctx.Value(nonTransactionalKey{}) == nil
+}
+
+// Logger Receive warnings from the session, satisfied by *log.Logger
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithLogger Send warnings to l
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+func (o *options) logf(format string, v ...interface{}) {
+	if o.logger != nil {
+		o.logger.Printf(format, v...)
+	}
+}
+
+// WithRowsLeakDetection Warn through the logger when a transaction ends while rows queried in it are still open
+//
+// The warning names the query that produced the rows. Off by default
+func WithRowsLeakDetection(enabled bool) Option {
+	return func(o *options) {
+		o.detectRowsLeaks = enabled
+	}
+}
+
+// trackedRows Rows handed out inside a transaction while leak detection is on
+type trackedRows struct {
+	rows  *sql.Rows
+	query string
+}
+
+func (o *options) trackRows(st *txState, rows *sql.Rows, err error, query string) {
+	if o.detectRowsLeaks && err == nil {
+		st.openRows = append(st.openRows, trackedRows{rows: rows, query: query})
+	}
+}
+
+// reportRowsLeaks Warn about tracked rows that were not closed, Columns only fails once rows are closed
+func (o *options) reportRowsLeaks(st *txState) {
+	for _, t := range st.openRows {
+		if _, err := t.rows.Columns(); err == nil {
+			o.logf("cransaction: transaction %q ended with open rows from query %q", st.name, t.query)
+		}
+	}
+}
+
+// WithCommitOnContextCancel Commit even when the context is done by the time fn returns
+//
+// By default such a transaction is rolled back and Transaction returns the context error. With it the
+// transaction itself is not bound to the context, a Timeout of WithTxDefaults only bounds its statements
+func WithCommitOnContextCancel(enabled bool) Option {
+	return func(o *options) {
+		o.commitOnCancel = enabled
+	}
+}
+
+// beginContext Context a database transaction begins with, database/sql rolls the transaction back once it is
+// done. With WithCommitOnContextCancel the values of ctx are kept but not its cancellation, so it can still commit
+func (o *options) beginContext(ctx context.Context) context.Context {
+	if o.commitOnCancel {
+		return context.WithoutCancel(ctx)
+	}
+	return ctx
+}
+
+// RetryPolicy Decide whether a failed attempt is retried and how long to wait before the next one
+type RetryPolicy struct {
+	// Retryable Report whether err may succeed on another attempt. When nil reads are retried on IsConnectionError
+	// and writes only on driver.ErrBadConn, which database/sql returns only when the statement was not sent
+	//
+	// A custom Retryable applies to writes too: a write whose connection dropped after the server ran it is then
+	// run again, making ExecQuery at-least-once
+	Retryable func(err error) bool
+	// Backoff Delay before retry number attempt, starting at 1, retries immediately when nil
+	Backoff func(attempt int) time.Duration
+}
+
+func (p RetryPolicy) retryable(err error, write bool) bool {
+	if p.Retryable == nil && write {
+		return errors.Is(err, driver.ErrBadConn)
+	}
+	if p.Retryable == nil {
+		return IsConnectionError(err)
+	}
+	return p.Retryable(err)
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.Backoff == nil {
+		return 0
+	}
+	return p.Backoff(attempt)
+}
+
+// WithQueryRetry Retry ExecQuery, QueryRow and QueryRows issued outside a transaction up to maxRetries times
+//
+// Statements inside a transaction are never retried, the transaction is bound to the failed connection and
+// replaying a single statement of it would not be safe. Writes of ExecQuery are retried only on driver.ErrBadConn
+// unless policy has a Retryable, see RetryPolicy. Without this option autocommit statements are still retried
+// once on driver.ErrBadConn
+func WithQueryRetry(maxRetries int, policy RetryPolicy) Option {
+	return func(o *options) {
+		o.queryRetries = maxRetries
+		o.retryPolicy = policy
+	}
+}
+
+// retry Call attempt until it succeeds, fails with an error the policy does not retry or runs out of retries
+//
+// driver.ErrBadConn is always retried once first, as database/sql does, for wrappers that let it surface
+func (o *options) retry(ctx context.Context, write bool, attempt func() error) error {
+	err := attempt()
+	if errors.Is(err, driver.ErrBadConn) && ctx.Err() == nil {
+		err = attempt()
+	}
+	for i := 1; i <= o.queryRetries && err != nil && o.retryPolicy.retryable(err, write); i++ {
+		if wait := o.retryPolicy.backoff(i); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-o.after(wait):
+			}
+		}
+		err = attempt()
+	}
+	return err
+}
+
+// WithAutoReconnect Ping the connection pool before a Gorm transaction begins and retry once after a short
+// delay on a connection error, so the transaction starts on a fresh connection after a database restart
+//
+// Only connection errors trigger the retry, query errors are returned unchanged
+func WithAutoReconnect(enabled bool) Option {
+	return func(o *options) {
+		o.autoReconnect = enabled
+	}
+}
+
+// WithTypeConverter Decode columns of dbType, such as DECIMAL or NUMERIC, with convert in the generic scanners
+//
+// dbType is matched case-insensitively against the database type name reported by the driver and convert
+// receives the raw column bytes, so it can produce for example a decimal.Decimal
+func WithTypeConverter(dbType string, convert func([]byte) (interface{}, error)) Option {
+	return func(o *options) {
+		if o.typeConverters == nil {
+			o.typeConverters = make(map[string]func([]byte) (interface{}, error))
+		}
+		o.typeConverters[strings.ToUpper(dbType)] = convert
+	}
+}
@@ -0,0 +1,607 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryRewriters(t *testing.T) {
+	errBlocked := errors.New("blocked")
+	f, r := newFakeSession(t, "postgres",
+		WithQueryRewriter(func(ctx context.Context, query string) (string, error) {
+			if strings.HasPrefix(query, "DELETE") && !strings.Contains(query, "WHERE") {
+				return "", errBlocked
+			}
+			return query, nil
+		}),
+		WithQueryRewriter(func(ctx context.Context, query string) (string, error) {
+			if strings.HasPrefix(query, "SELECT") {
+				return query + " WHERE tenant_id = 42", nil
+			}
+			return query, nil
+		}),
+	)
+	ctx := context.Background()
+	rows, err := queryRows(ctx, r, "SELECT id FROM accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+	if _, err = r.ExecQuery(ctx, "DELETE FROM accounts"); !errors.Is(err, errBlocked) {
+		t.Errorf("err = %v, want the rewriter error", err)
+	}
+	wantStatements(t, f, "SELECT id FROM accounts WHERE tenant_id = 42")
+}
+
+func TestBeginHooks(t *testing.T) {
+	ctx := context.Background()
+	errChaos := errors.New("chaos")
+	f, r := newFakeSession(t, "postgres", WithBeginHook(func(ctx context.Context) error {
+		return errChaos
+	}))
+	called := false
+	err := r.Transaction(ctx, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, errChaos) || !errors.Is(err, ErrBeginFailed) || called {
+		t.Fatalf("err = %v, fn called = %v", err, called)
+	}
+	wantStatements(t, f)
+
+	var order []string
+	f, r = newFakeSession(t, "postgres",
+		WithBeginHook(func(ctx context.Context) error {
+			order = append(order, "before")
+			return nil
+		}),
+		WithAfterBeginHook(func(ctx context.Context) error {
+			order = append(order, "after")
+			return nil
+		}),
+	)
+	if err = r.Transaction(ctx, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "before,after" {
+		t.Errorf("hooks ran as %v", order)
+	}
+	wantStatements(t, f, "BEGIN", "COMMIT")
+
+	f, r = newFakeSession(t, "postgres", WithAfterBeginHook(func(ctx context.Context) error {
+		return errChaos
+	}))
+	if err = r.Transaction(ctx, func(ctx context.Context) error { return nil }); !errors.Is(err, errChaos) {
+		t.Fatalf("err = %v", err)
+	}
+	wantStatements(t, f, "BEGIN", "ROLLBACK")
+}
+
+func TestRowsLeakDetection(t *testing.T) {
+	log := &testLogger{}
+	f, r := newFakeSession(t, "postgres", WithLogger(log), WithRowsLeakDetection(true))
+	f.on("SELECT", rowsOf([]string{"id"}, []driver.Value{int64(1)}))
+	err := r.TransactionNamed(context.Background(), "report", func(ctx context.Context) error {
+		closed, err := queryRows(ctx, r, "SELECT id FROM closed")
+		if err != nil {
+			return err
+		}
+		_ = closed.Close()
+		_, err = queryRows(ctx, r, "SELECT id FROM leaked")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaks := log.logged("open rows")
+	if len(leaks) != 1 || !strings.Contains(leaks[0], `"SELECT id FROM leaked"`) || !strings.Contains(leaks[0], `"report"`) {
+		t.Fatalf("leak warnings = %q", leaks)
+	}
+}
+
+func TestBeforeAndAfterQueryHooks(t *testing.T) {
+	type observed struct {
+		query string
+		args  []interface{}
+		err   error
+	}
+	var seen []observed
+	f, r := newFakeSession(t, "postgres",
+		WithBeforeQuery(func(ctx context.Context, query string, args []interface{}) (string, []interface{}, error) {
+			return query + fmt.Sprintf(" AND tenant_id = $%d", len(args)+1), append(args, 42), nil
+		}),
+		WithBeforeQuery(func(ctx context.Context, query string, args []interface{}) (string, []interface{}, error) {
+			return strings.Replace(query, "SELECT *", "SELECT id", 1), args, nil
+		}),
+		WithAfterQuery(func(ctx context.Context, query string, args []interface{}, elapsed time.Duration, err error) {
+			seen = append(seen, observed{query, args, err})
+		}),
+	)
+	f.fail("FROM missing", errFake)
+	ctx := context.Background()
+	rows, err := queryRows(ctx, r, "SELECT * FROM users WHERE active = $1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+	if _, err = r.ExecQuery(ctx, "DELETE FROM missing WHERE id = $1", 7); !errors.Is(err, errFake) {
+		t.Fatalf("err = %v", err)
+	}
+
+	want := "SELECT id FROM users WHERE active = $1 AND tenant_id = $2"
+	if s := f.last("FROM users"); s.query != want || len(s.args) != 2 || s.args[0] != true || s.args[1] != int64(42) {
+		t.Fatalf("driver got %q %v", s.query, s.args)
+	}
+	if len(seen) != 2 || seen[0].query != want || len(seen[0].args) != 2 || seen[0].err != nil || !errors.Is(seen[1].err, errFake) {
+		t.Errorf("after hooks saw %+v", seen)
+	}
+}
+
+// applicationNameOf Answer current_setting('application_name') with the name of the last SET LOCAL, as Postgres
+// would inside the transaction that ran it
+func applicationNameOf(f *fakeDB) func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+	return func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+		set := f.last("SET LOCAL application_name")
+		name := ""
+		if set.inTx {
+			quoted := strings.TrimPrefix(set.query, "SET LOCAL application_name = ")
+			name = strings.ReplaceAll(quoted[1:len(quoted)-1], "''", "'")
+		}
+		return rowsOf([]string{"current_setting"}, []driver.Value{name}), nil
+	}
+}
+
+func TestApplicationName(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres", WithApplicationName("billing's worker"))
+			f.handle("current_setting('application_name')", applicationNameOf(f))
+			var got string
+			err := tx.Transaction(context.Background(), func(ctx context.Context) error {
+				var err error
+				got, err = QueryValue[string](ctx, tx, "SELECT current_setting('application_name')")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "billing's worker" {
+				t.Errorf("application_name = %q", got)
+			}
+			wantStatements(t, f, "BEGIN", "SET LOCAL application_name = 'billing''s worker'",
+				"SELECT current_setting('application_name')", "COMMIT")
+		})
+	}
+	f, r := newFakeSession(t, "mysql", WithApplicationName("billing"))
+	if err := r.Transaction(context.Background(), func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, f, "BEGIN", "COMMIT")
+}
+
+func TestRequireTransaction(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres", WithRequireTransaction(true))
+			_, other := newFakeSession(t, "postgres")
+			check := func(ctx context.Context) {
+				t.Helper()
+				if _, err := tx.ExecQuery(ctx, "UPDATE users SET active = true"); !errors.Is(err, ErrNoActiveTransaction) {
+					t.Errorf("ExecQuery err = %v", err)
+				}
+				var n int
+				if err := tx.QueryRow(ctx, "SELECT 1").(Row).Scan(&n); !errors.Is(err, ErrNoActiveTransaction) {
+					t.Errorf("QueryRow err = %v", err)
+				}
+				if _, err := tx.QueryRows(ctx, "SELECT 1"); !errors.Is(err, ErrNoActiveTransaction) {
+					t.Errorf("QueryRows err = %v", err)
+				}
+			}
+			check(context.Background())
+			// A transaction of another session does not count
+			if err := other.Transaction(context.Background(), func(ctx context.Context) error {
+				check(ctx)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			wantStatements(t, f)
+
+			err := tx.Transaction(context.Background(), func(ctx context.Context) error {
+				_, err := tx.ExecQuery(ctx, "UPDATE users SET active = true")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatements(t, f, "BEGIN", "UPDATE users SET active = true", "COMMIT")
+		})
+	}
+}
+
+// blockUntilDone Answer once the statement context is done, as a query slower than any timeout
+func blockUntilDone(ctx context.Context, _ []driver.NamedValue) (*fakeResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestQueryTimeoutStopsSlowQuery(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres", WithQueryTimeout(20*time.Millisecond))
+			f.handle("pg_sleep", blockUntilDone)
+			start := time.Now()
+			if _, err := tx.ExecQuery(context.Background(), "SELECT pg_sleep(60)"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("ExecQuery err = %v", err)
+			}
+			if _, err := tx.QueryRows(context.Background(), "SELECT pg_sleep(60)"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("QueryRows err = %v", err)
+			}
+			var v int
+			if err := tx.QueryRow(context.Background(), "SELECT pg_sleep(60)").(Row).Scan(&v); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("QueryRow err = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestQueryTimeoutKeepsSoonerDeadline(t *testing.T) {
+	f, r := newFakeSession(t, "postgres", WithQueryTimeout(time.Hour))
+	var deadline time.Time
+	f.handle("UPDATE", func(ctx context.Context, _ []driver.NamedValue) (*fakeResult, error) {
+		deadline, _ = ctx.Deadline()
+		return nil, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := r.ExecQuery(ctx, "UPDATE users SET seen = now()"); err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := ctx.Deadline(); !deadline.Equal(want) {
+		t.Errorf("deadline = %v, want the minute of the caller %v", deadline, want)
+	}
+	if _, err := r.ExecQuery(context.Background(), "UPDATE users SET seen = now()"); err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(deadline); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("deadline in %v, want the hour of the session", until)
+	}
+}
+
+func TestQueryTimeoutReleasedWithResult(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres", WithQueryTimeout(time.Hour))
+			var queryCtx context.Context
+			f.handle("SELECT", func(ctx context.Context, _ []driver.NamedValue) (*fakeResult, error) {
+				queryCtx = ctx
+				return rowsOf([]string{"n"}, []driver.Value{int64(1)}, []driver.Value{int64(2)}), nil
+			})
+			ctx := context.Background()
+
+			row := tx.QueryRow(ctx, "SELECT n FROM numbers LIMIT 1").(Row)
+			if queryCtx.Err() != nil {
+				t.Fatal("row context released before Scan")
+			}
+			var n int64
+			if err := row.Scan(&n); err != nil || n != 1 {
+				t.Fatalf("n = %d, err = %v", n, err)
+			}
+			if !errors.Is(queryCtx.Err(), context.Canceled) {
+				t.Errorf("row context err after Scan = %v, want context.Canceled", queryCtx.Err())
+			}
+
+			result, err := tx.QueryRows(ctx, "SELECT n FROM numbers")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, ok := result.(*ContextRows)
+			if !ok {
+				t.Fatalf("rows bound by the timeout are %T", result)
+			}
+			for rows.Next() {
+				if queryCtx.Err() != nil {
+					t.Fatal("rows context released while reading")
+				}
+			}
+			if err = rows.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(queryCtx.Err(), context.Canceled) {
+				t.Errorf("rows context err after Close = %v, want context.Canceled", queryCtx.Err())
+			}
+		})
+	}
+}
+
+func TestAutoTransaction(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres", WithAutoTransaction(true))
+			f.on("UPDATE", &fakeResult{affected: 2})
+			result, err := s.Exec(context.Background(), "UPDATE users SET active = true")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, err := result.RowsAffected(); err != nil || n != 2 {
+				t.Errorf("RowsAffected = %d, %v, want 2", n, err)
+			}
+			if _, err = s.ExecQuery(context.Background(), "SELECT pg_sleep(0)"); err != nil {
+				t.Fatal(err)
+			}
+			err = s.Transaction(context.Background(), func(ctx context.Context) error {
+				_, err := s.ExecQuery(ctx, "DELETE FROM sessions")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatements(t, f, "BEGIN", "UPDATE users SET active = true", "COMMIT", "SELECT pg_sleep(0)",
+				"BEGIN", "DELETE FROM sessions", "COMMIT")
+		})
+	}
+}
+
+func TestAutoTransactionFailures(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres", WithAutoTransaction(true))
+			errCommit := errors.New("could not serialize access")
+			f.fail("COMMIT", errCommit)
+			f.fail("DELETE", errFake)
+			if _, err := s.ExecQuery(context.Background(), "INSERT INTO users (name) VALUES ('ada')"); !errors.Is(err, ErrCommitFailed) || !errors.Is(err, errCommit) {
+				t.Errorf("err = %v, want the failed commit", err)
+			}
+			if _, err := s.ExecQuery(context.Background(), "DELETE FROM users"); !errors.Is(err, errFake) {
+				t.Errorf("err = %v, want the statement error", err)
+			}
+			wantStatements(t, f, "BEGIN", "INSERT INTO users (name) VALUES ('ada')", "COMMIT",
+				"BEGIN", "DELETE FROM users", "ROLLBACK")
+		})
+	}
+}
+
+func TestNilHandling(t *testing.T) {
+	// strictCheck Reject typed nils as some drivers do, leaving the rest to the default conversion
+	strictCheck := func(v *driver.NamedValue) error {
+		if s, ok := v.Value.(*string); ok && s == nil {
+			return fmt.Errorf("fake: invalid type %T", v.Value)
+		}
+		return driver.ErrSkip
+	}
+	for name, open := range testSessions {
+		t.Run(name+"/passthrough", func(t *testing.T) {
+			f, s := open(t, "postgres")
+			f.checkValue = strictCheck
+			if _, err := s.ExecQuery(context.Background(), "UPDATE users SET nickname = ?", (*string)(nil)); err == nil || !strings.Contains(err.Error(), "invalid type") {
+				t.Errorf("err = %v, want the driver to see the typed nil", err)
+			}
+		})
+		t.Run(name+"/as null", func(t *testing.T) {
+			f, s := open(t, "postgres", WithNilHandling(NilAsNull))
+			f.checkValue = strictCheck
+			nickname := "ada"
+			args := []interface{}{(*string)(nil), &nickname, 1}
+			if _, err := s.ExecQuery(context.Background(), "UPDATE users SET nickname = ?, name = ? WHERE id = ?", args...); err != nil {
+				t.Fatal(err)
+			}
+			got := f.argsOf("UPDATE")
+			if len(got) != 3 || got[0] != nil || got[1] != "ada" || got[2] != int64(1) {
+				t.Errorf("driver args = %#v", got)
+			}
+			if args[0] != (*string)(nil) {
+				t.Errorf("caller args changed to %#v", args)
+			}
+		})
+	}
+}
+
+// testMoney Amount in cents of a type the default conversion of database/sql rejects
+type testMoney struct {
+	cents    int64
+	currency string
+}
+
+func TestValueConverter(t *testing.T) {
+	money := func(v interface{}) (driver.Value, bool, error) {
+		m, ok := v.(testMoney)
+		if !ok {
+			return nil, false, nil
+		}
+		if m.currency == "" {
+			return nil, true, errors.New("money without a currency")
+		}
+		return fmt.Sprintf("%d.%02d %s", m.cents/100, m.cents%100, m.currency), true, nil
+	}
+	// everyMoney Claim every testMoney, registered after money to check the first converter wins
+	everyMoney := func(v interface{}) (driver.Value, bool, error) {
+		if _, ok := v.(testMoney); ok {
+			return "second", true, nil
+		}
+		return nil, false, nil
+	}
+	for name, open := range testSessions {
+		t.Run(name+"/without", func(t *testing.T) {
+			_, s := open(t, "postgres")
+			if _, err := s.ExecQuery(context.Background(), "UPDATE accounts SET balance = $1", testMoney{1250, "EUR"}); err == nil {
+				t.Error("the driver accepted testMoney without a converter")
+			}
+		})
+		t.Run(name+"/chain", func(t *testing.T) {
+			f, s := open(t, "postgres", WithValueConverter(money), WithValueConverter(everyMoney))
+			args := []interface{}{testMoney{1250, "EUR"}, "ada", 7}
+			if _, err := s.ExecQuery(context.Background(), "UPDATE accounts SET balance = $1 WHERE owner = $2 AND id = $3", args...); err != nil {
+				t.Fatal(err)
+			}
+			got := f.argsOf("UPDATE")
+			if len(got) != 3 || got[0] != "12.50 EUR" || got[1] != "ada" || got[2] != int64(7) {
+				t.Errorf("driver args = %#v", got)
+			}
+			if args[0] != (testMoney{1250, "EUR"}) {
+				t.Errorf("caller args changed to %#v", args)
+			}
+		})
+		t.Run(name+"/reads", func(t *testing.T) {
+			f, s := open(t, "postgres", WithValueConverter(money))
+			f.on("SELECT id", rowsOf([]string{"id"}, []driver.Value{int64(1)}))
+			var id int64
+			if err := (&Result{ctx: context.Background(), tx: s, query: "SELECT id FROM accounts WHERE balance > $1", args: []interface{}{testMoney{5, "USD"}}}).Scalar(&id); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.argsOf("SELECT id"); len(got) != 1 || got[0] != "0.05 USD" {
+				t.Errorf("driver args = %#v", got)
+			}
+		})
+		t.Run(name+"/error", func(t *testing.T) {
+			f, s := open(t, "postgres", WithValueConverter(money))
+			_, err := s.ExecQuery(context.Background(), "UPDATE accounts SET balance = $1 WHERE id = $2", 7, testMoney{cents: 100})
+			if err == nil || !strings.Contains(err.Error(), "arg 2: money without a currency") {
+				t.Errorf("err = %v, want the converter error for arg 2", err)
+			}
+			if n := f.count("UPDATE"); n != 0 {
+				t.Errorf("statement ran %d times after the converter failed", n)
+			}
+		})
+	}
+}
+
+func TestMaxTransactionDuration(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			log := &testLogger{}
+			var reason RollbackReason
+			f, s := open(t, "postgres", WithClock(clock), WithLogger(log), WithMaxTransactionDuration(time.Minute),
+				WithEventHandler(func(_ context.Context, e Event) {
+					if e.Type == TxRollback {
+						reason = e.RollbackReason
+					}
+				}))
+			err := s.Transaction(context.Background(), func(ctx context.Context) error {
+				if _, err := s.ExecQuery(ctx, "UPDATE accounts SET balance = 0"); err != nil {
+					return err
+				}
+				clock.waitForWaiters(t, 1)
+				clock.Advance(time.Minute)
+				waitFor(t, func() bool { return f.count("ROLLBACK") == 1 })
+				if _, err := s.ExecQuery(ctx, "DELETE FROM accounts"); !errors.Is(err, ErrTransactionExpired) {
+					t.Errorf("statement after expiry err = %v, want ErrTransactionExpired", err)
+				}
+				return nil
+			})
+			if !errors.Is(err, ErrTransactionExpired) {
+				t.Errorf("Transaction err = %v, want ErrTransactionExpired", err)
+			}
+			if reason != RollbackExpired {
+				t.Errorf("rollback reason = %v, want expired", reason)
+			}
+			if len(log.logged("rolled back after exceeding 1m0s")) != 1 {
+				t.Errorf("logged %v", log.lines)
+			}
+			wantStatements(t, f, "BEGIN", "UPDATE accounts SET balance = 0", "ROLLBACK")
+		})
+	}
+}
+
+func TestMaxTransactionDurationStopsOnEnd(t *testing.T) {
+	clock := newFakeClock()
+	f, r := newFakeSession(t, "postgres", WithClock(clock), WithMaxTransactionDuration(time.Minute))
+	err := r.Transaction(context.Background(), func(ctx context.Context) error {
+		clock.Advance(59 * time.Second)
+		_, err := r.ExecQuery(ctx, "UPDATE accounts SET balance = 0")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	wantStatements(t, f, "BEGIN", "UPDATE accounts SET balance = 0", "COMMIT")
+}
+
+// deadliner Session offering EffectiveDeadline, both backends do
+type deadliner interface {
+	ITransaction
+	EffectiveDeadline(ctx context.Context) (time.Time, bool)
+}
+
+func TestEffectiveDeadline(t *testing.T) {
+	near := func(got, want time.Time) bool {
+		d := got.Sub(want)
+		return d > -time.Second && d < time.Second
+	}
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			now := clock.Now()
+			_, tx := open(t, "postgres", WithClock(clock), WithQueryTimeout(time.Minute), WithMaxTransactionDuration(5*time.Minute))
+			s := tx.(deadliner)
+			soon, cancelSoon := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancelSoon()
+			late, cancelLate := context.WithTimeout(context.Background(), time.Hour)
+			defer cancelLate()
+
+			if got, ok := s.EffectiveDeadline(context.Background()); !ok || !got.Equal(now.Add(time.Minute)) {
+				t.Errorf("without a context deadline = %v, %v, want the query timeout", got, ok)
+			}
+			if got, ok := s.EffectiveDeadline(late); !ok || !got.Equal(now.Add(time.Minute)) {
+				t.Errorf("with a later context deadline = %v, %v, want the query timeout", got, ok)
+			}
+			if got, ok := s.EffectiveDeadline(soon); !ok || !near(got, now.Add(10*time.Second)) {
+				t.Errorf("with a sooner context deadline = %v, %v, want the context's", got, ok)
+			}
+			err := s.Transaction(late, func(ctx context.Context) error {
+				if got, ok := s.EffectiveDeadline(ctx); !ok || !got.Equal(now.Add(5*time.Minute)) {
+					t.Errorf("in a transaction = %v, %v, want its expiry", got, ok)
+				}
+				return s.Transaction(soon, func(ctx context.Context) error {
+					if got, ok := s.EffectiveDeadline(ctx); !ok || !near(got, now.Add(10*time.Second)) {
+						t.Errorf("in a transaction with a sooner context deadline = %v, %v", got, ok)
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	_, r := newFakeSession(t, "postgres")
+	if got, ok := r.EffectiveDeadline(context.Background()); ok {
+		t.Errorf("without any limit = %v, want none", got)
+	}
+}
+
+func TestStatementsRunUnderTheSoonerDeadline(t *testing.T) {
+	f, r := newFakeSession(t, "postgres", WithQueryTimeout(time.Minute))
+	var seen []time.Duration
+	f.handle("UPDATE", func(ctx context.Context, _ []driver.NamedValue) (*fakeResult, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Error("statement ran without a deadline")
+		}
+		seen = append(seen, time.Until(deadline).Round(time.Second))
+		return nil, nil
+	})
+	late, cancelLate := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelLate()
+	soon, cancelSoon := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelSoon()
+	for _, ctx := range []context.Context{context.Background(), late, soon} {
+		if _, err := r.ExecQuery(ctx, "UPDATE accounts SET balance = 0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []time.Duration{time.Minute, time.Minute, 30 * time.Second}; !slices.Equal(seen, want) {
+		t.Errorf("deadlines %v, want %v", seen, want)
+	}
+}
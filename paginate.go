@@ -0,0 +1,56 @@
+package cransaction
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Paginate Fetch the page of baseQuery that follows afterKey, using keyset pagination on keyColumn
+//
+// baseQuery is wrapped as a subquery, filtered with keyColumn > afterKey, ordered by keyColumn and limited to
+// limit rows, the first page is requested with a nil afterKey. nextKey is the key of the last row, to pass
+// as afterKey for the following page, and nil once the last page was returned
+func Paginate[T any](ctx context.Context, tx ITransaction, baseQuery string, keyColumn string, afterKey interface{}, limit int) (items []T, nextKey interface{}, err error) {
+	if err = checkIdentifier(keyColumn); err != nil {
+		return nil, nil, err
+	}
+	if limit <= 0 {
+		return nil, nil, fmt.Errorf("cransaction: page limit must be positive, got %d", limit)
+	}
+	t := structType(reflect.TypeOf((*T)(nil)).Elem())
+	if t.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("cransaction: cannot paginate into %s, expected struct", t)
+	}
+	keyField, ok := structFields(t)[strings.ToLower(keyColumn)]
+	if !ok {
+		return nil, nil, fmt.Errorf("cransaction: no field in %s for key column %q", t, keyColumn)
+	}
+	query, args := pageQuery(dialectOf(tx), baseQuery, keyColumn, afterKey, limit)
+	if items, err = QueryRowsStruct[T](ctx, tx, query, args...); err != nil {
+		return nil, nil, err
+	}
+	if len(items) < limit {
+		return items, nil, nil
+	}
+	last := reflect.Indirect(reflect.ValueOf(&items[len(items)-1]).Elem())
+	key, err := last.FieldByIndexErr(keyField)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cransaction: key column %q: %w", keyColumn, err)
+	}
+	return items, key.Interface(), nil
+}
+
+func pageQuery(d dialecter, baseQuery, keyColumn string, afterKey interface{}, limit int) (string, []interface{}) {
+	var b strings.Builder
+	var args []interface{}
+	fmt.Fprintf(&b, "SELECT * FROM (%s) AS cransaction_page", strings.TrimRight(strings.TrimSpace(baseQuery), ";"))
+	if afterKey != nil {
+		args = append(args, afterKey)
+		fmt.Fprintf(&b, " WHERE %s > %s", keyColumn, d.bindVar(len(args)))
+	}
+	args = append(args, limit)
+	fmt.Fprintf(&b, " ORDER BY %s LIMIT %s", keyColumn, d.bindVar(len(args)))
+	return b.String(), args
+}
@@ -0,0 +1,70 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+)
+
+// pagedUsers Answer keyset pages over users with ids 1 to n, reading the cursor and limit from the args
+func pagedUsers(n int64) func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+	return func(_ context.Context, args []driver.NamedValue) (*fakeResult, error) {
+		after, limit := int64(0), args[len(args)-1].Value.(int64)
+		if len(args) == 2 {
+			after = args[0].Value.(int64)
+		}
+		res := rowsOf([]string{"id", "name"})
+		for id := after + 1; id <= n && int64(len(res.rows)) < limit; id++ {
+			res.rows = append(res.rows, []driver.Value{id, fmt.Sprintf("user %d", id)})
+		}
+		return res, nil
+	}
+}
+
+type testPagedUser struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestPaginate(t *testing.T) {
+	for _, dialect := range []string{"postgres", "mysql"} {
+		t.Run(dialect, func(t *testing.T) {
+			f, r := newFakeSession(t, dialect)
+			f.handle("FROM users", pagedUsers(7))
+			var ids []int64
+			var after interface{}
+			for pages := 0; ; pages++ {
+				if pages > 10 {
+					t.Fatal("pagination does not end")
+				}
+				items, next, err := Paginate[testPagedUser](context.Background(), r, "SELECT id, name FROM users", "id", after, 3)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, u := range items {
+					ids = append(ids, u.ID)
+				}
+				if next == nil {
+					break
+				}
+				after = next
+			}
+			if fmt.Sprint(ids) != "[1 2 3 4 5 6 7]" {
+				t.Errorf("ids = %v", ids)
+			}
+		})
+	}
+	f, r := newFakeSession(t, "postgres")
+	f.handle("FROM users", pagedUsers(7))
+	ctx := context.Background()
+	if _, _, err := Paginate[testPagedUser](ctx, r, "SELECT id, name FROM users", "id", nil, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Paginate[testPagedUser](ctx, r, "SELECT id, name FROM users", "id", int64(3), 3); err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, f,
+		"SELECT * FROM (SELECT id, name FROM users) AS cransaction_page ORDER BY id LIMIT $1",
+		"SELECT * FROM (SELECT id, name FROM users) AS cransaction_page WHERE id > $1 ORDER BY id LIMIT $2")
+}
@@ -0,0 +1,60 @@
+package cransaction
+
+import (
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TxInfo Open transaction listed by OpenTransactions
+type TxInfo struct {
+	// Name Transaction name given to TransactionNamed, empty for unnamed transactions
+	Name  string
+	Began time.Time
+	// Stack Stack trace of the goroutine that began the transaction
+	Stack string
+}
+
+var (
+	openTxMu sync.Mutex
+	openTx   = make(map[*txState]TxInfo)
+)
+
+// WithTransactionRegistry Record the transactions of the session in the process-wide registry listed by
+// OpenTransactions, to find transactions a code path forgot to end. Capturing the stack has a cost, off by default
+func WithTransactionRegistry(enabled bool) Option {
+	return func(o *options) {
+		o.txRegistry = enabled
+	}
+}
+
+// OpenTransactions Transactions currently open on the sessions with WithTransactionRegistry, oldest first
+func OpenTransactions() []TxInfo {
+	openTxMu.Lock()
+	defer openTxMu.Unlock()
+	infos := make([]TxInfo, 0, len(openTx))
+	for _, info := range openTx {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Began.Before(infos[j].Began)
+	})
+	return infos
+}
+
+// registerTx Add the database transaction st to the registry when it is enabled
+func (o *options) registerTx(st *txState) {
+	if !o.txRegistry || st.outer != nil {
+		return
+	}
+	openTxMu.Lock()
+	defer openTxMu.Unlock()
+	openTx[st] = TxInfo{Name: st.name, Began: st.start, Stack: string(debug.Stack())}
+}
+
+func unregisterTx(st *txState) {
+	openTxMu.Lock()
+	defer openTxMu.Unlock()
+	delete(openTx, st)
+}
@@ -0,0 +1,58 @@
+package cransaction
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// namedSession Session offering TransactionNamed, both backends do
+type namedSession interface {
+	TransactionNamed(ctx context.Context, name string, fn func(context.Context) error) error
+}
+
+func TestOpenTransactions(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			_, tx := open(t, "postgres", WithTransactionRegistry(true))
+			_, untracked := newFakeSession(t, "postgres")
+			if n := len(OpenTransactions()); n != 0 {
+				t.Fatalf("%d transactions open before the test", n)
+			}
+			named := tx.(namedSession)
+			err := named.TransactionNamed(context.Background(), "import", func(ctx context.Context) error {
+				return untracked.Transaction(ctx, func(ctx context.Context) error {
+					return tx.Transaction(ctx, func(ctx context.Context) error {
+						infos := OpenTransactions()
+						// Neither the savepoint nor the transaction of the untracked session is listed
+						if len(infos) != 1 {
+							t.Fatalf("open transactions = %+v", infos)
+						}
+						if infos[0].Name != "import" || infos[0].Began.IsZero() {
+							t.Errorf("info = %+v", infos[0])
+						}
+						if !strings.Contains(infos[0].Stack, "TestOpenTransactions") {
+							t.Errorf("stack does not show the caller:\n%s", infos[0].Stack)
+						}
+						return nil
+					})
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if infos := OpenTransactions(); len(infos) != 0 {
+				t.Errorf("still open after commit: %+v", infos)
+			}
+			_ = tx.Transaction(context.Background(), func(ctx context.Context) error {
+				if len(OpenTransactions()) != 1 {
+					t.Error("transaction not listed")
+				}
+				return errFake
+			})
+			if infos := OpenTransactions(); len(infos) != 0 {
+				t.Errorf("still open after rollback: %+v", infos)
+			}
+		})
+	}
+}
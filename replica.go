@@ -0,0 +1,183 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+type preferPrimaryKey struct{}
+
+// PreferPrimary Return a context whose reads on a ReplicaSession go to the primary, to read the writes just made
+// despite replica lag
+func PreferPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, preferPrimaryKey{}, true)
+}
+
+func prefersPrimary(ctx context.Context) bool {
+	prefer, _ := ctx.Value(preferPrimaryKey{}).(bool)
+	return prefer
+}
+
+// replicaSession Read/write-splitting session, see ReplicaSession
+type replicaSession struct {
+	primary  ITransaction
+	replicas []ITransaction
+	next     atomic.Uint64
+}
+
+// ReplicaSession Route writes and transactions to primary and spread autocommit reads over replicas in turn
+//
+// Reads go to the primary inside a transaction, with a context from PreferPrimary and when there is no replica
+func ReplicaSession(primary ITransaction, replicas ...ITransaction) ITransaction {
+	return &replicaSession{primary: primary, replicas: replicas}
+}
+
+// reader Session serving a read issued with ctx
+func (s *replicaSession) reader(ctx context.Context) ITransaction {
+	if s.readsPrimary(ctx) {
+		return s.primary
+	}
+	return s.replicas[s.nextReplica()]
+}
+
+func (s *replicaSession) readsPrimary(ctx context.Context) bool {
+	return len(s.replicas) == 0 || txStateFrom(ctx) != nil || prefersPrimary(ctx)
+}
+
+func (s *replicaSession) nextReplica() int {
+	return int((s.next.Add(1) - 1) % uint64(len(s.replicas)))
+}
+
+func (s *replicaSession) Transaction(ctx context.Context, fn func(context.Context) error) error {
+	return s.primary.Transaction(ctx, fn)
+}
+
+func (s *replicaSession) ExecQuery(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
+	return s.primary.ExecQuery(ctx, query, args...)
+}
+
+func (s *replicaSession) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.primary.Exec(ctx, query, args...)
+}
+
+func (s *replicaSession) Driver() string {
+	return s.primary.Driver()
+}
+
+func (s *replicaSession) QueryRow(ctx context.Context, query string, args ...interface{}) interface{} {
+	return s.reader(ctx).QueryRow(ctx, query, args...)
+}
+
+func (s *replicaSession) QueryRows(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
+	o := optionsOf(s.primary)
+	if o.hedgeDelay <= 0 || len(s.replicas) < 2 || s.readsPrimary(ctx) || !hedgeable(query) {
+		return s.reader(ctx).QueryRows(ctx, query, args...)
+	}
+	return s.hedgedQueryRows(ctx, o, query, args)
+}
+
+// WithReadHedging Hedge the replica reads of a ReplicaSession: a QueryRows still running after delay is sent to
+// a second replica as well, the first successful result is returned and the other attempt is cancelled
+//
+// Set it on the primary session given to ReplicaSession. Only autocommit reads routed to replicas are hedged,
+// which needs at least two replicas, and only when they are a SELECT without locking clause: any other statement
+// is sent once, to a single replica. The context of the winning attempt is released when its rows are closed
+func WithReadHedging(delay time.Duration) Option {
+	return func(o *options) {
+		o.hedgeDelay = delay
+	}
+}
+
+var lockingClausePattern = regexp.MustCompile(`(?i)\bFOR\s+(?:NO\s+KEY\s+UPDATE|UPDATE|KEY\s+SHARE|SHARE)\b|\bLOCK\s+IN\s+SHARE\s+MODE\b`)
+
+// hedgeable Report whether query can safely run twice at once, a SELECT that takes no row lock
+func hedgeable(query string) bool {
+	return isSelect(query) && !lockingClausePattern.MatchString(query)
+}
+
+// hedgedAttempt Outcome of one attempt of a hedged read
+type hedgedAttempt struct {
+	// index Launch order of the attempt, its cancel is cancels[index] of the hedged read
+	index  int
+	rows   interface{}
+	err    error
+	cancel context.CancelFunc
+}
+
+func (s *replicaSession) hedgedQueryRows(ctx context.Context, o *options, query string, args []interface{}) (interface{}, error) {
+	first := s.nextReplica()
+	attempts := make(chan hedgedAttempt, 2)
+	var cancels []context.CancelFunc
+	launch := func(replica ITransaction) {
+		ctx, cancel := context.WithCancel(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			rows, err := replica.QueryRows(ctx, query, args...)
+			attempts <- hedgedAttempt{index: index, rows: rows, err: err, cancel: cancel}
+		}()
+	}
+	launch(s.replicas[first])
+	pending := 1
+	hedge := o.after(o.hedgeDelay)
+	var failed *hedgedAttempt
+	for {
+		select {
+		case <-hedge:
+			hedge = nil
+			launch(s.replicas[(first+1)%len(s.replicas)])
+			pending++
+		case a := <-attempts:
+			pending--
+			if a.err != nil {
+				a.cancel()
+				if pending > 0 {
+					// Give the other attempt a chance to succeed
+					failed = &a
+					continue
+				}
+				if failed != nil {
+					return nil, failed.err
+				}
+				return nil, a.err
+			}
+			// The attempts still running are cancelled now, not once they end on their own
+			for i, cancel := range cancels {
+				if i != a.index {
+					cancel()
+				}
+			}
+			go discardAttempts(attempts, pending)
+			return releaseWith(a.rows, a.cancel), nil
+		}
+	}
+}
+
+// releaseWith Make closing the rows of the winning attempt also call cancel, rows of a type other than the ones
+// of QueryRows are returned as is and their context is released when the one of the read is done
+func releaseWith(rows interface{}, cancel context.CancelFunc) interface{} {
+	if rows, ok := rows.(*ContextRows); ok {
+		release := rows.release
+		rows.release = func() {
+			if release != nil {
+				release()
+			}
+			cancel()
+		}
+	}
+	return rows
+}
+
+// discardAttempts Close the rows of the n attempts that lost a hedged read and release their contexts
+func discardAttempts(attempts <-chan hedgedAttempt, n int) {
+	for i := 0; i < n; i++ {
+		a := <-attempts
+		a.cancel()
+		if closer, ok := a.rows.(interface{ Close() error }); ok && a.err == nil {
+			_ = closer.Close()
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReplicaSessionRouting(t *testing.T) {
+	fp, primary := newFakeSession(t, "postgres")
+	f1, replica1 := newFakeSession(t, "postgres")
+	f2, replica2 := newFakeSession(t, "postgres")
+	for _, f := range []*fakeDB{fp, f1, f2} {
+		f.on("SELECT", rowsOf([]string{"n"}, []driver.Value{int64(1)}))
+	}
+	s := ReplicaSession(primary, replica1, replica2)
+	ctx := context.Background()
+	read := func(ctx context.Context, query string) {
+		t.Helper()
+		if _, err := QueryValue[int64](ctx, s, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read(ctx, "SELECT 'first read'")
+	read(ctx, "SELECT 'second read'")
+	if _, err := s.ExecQuery(ctx, "UPDATE users SET name = 'ada'"); err != nil {
+		t.Fatal(err)
+	}
+	read(PreferPrimary(ctx), "SELECT 'own write'")
+	if err := s.Transaction(ctx, func(ctx context.Context) error {
+		read(ctx, "SELECT 'in transaction'")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	wantStatements(t, f1, "SELECT 'first read'")
+	wantStatements(t, f2, "SELECT 'second read'")
+	wantStatements(t, fp, "UPDATE users SET name = 'ada'", "SELECT 'own write'", "BEGIN", "SELECT 'in transaction'", "COMMIT")
+}
+
+func TestReplicaSessionWithoutReplicas(t *testing.T) {
+	fp, primary := newFakeSession(t, "postgres")
+	fp.on("SELECT", rowsOf([]string{"n"}, []driver.Value{int64(1)}))
+	if _, err := QueryValue[int64](context.Background(), ReplicaSession(primary), "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, fp, "SELECT 1")
+}
+
+func TestReadHedgingFastReplicaWins(t *testing.T) {
+	clock := newFakeClock()
+	fp, primary := newFakeSession(t, "postgres", WithReadHedging(50*time.Millisecond), WithClock(clock))
+	slow, replica1 := newFakeSession(t, "postgres")
+	fast, replica2 := newFakeSession(t, "postgres")
+	cancelled := make(chan error, 1)
+	slow.handle("FROM reports", func(ctx context.Context, _ []driver.NamedValue) (*fakeResult, error) {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	var fastCtx context.Context
+	fast.handle("FROM reports", func(ctx context.Context, _ []driver.NamedValue) (*fakeResult, error) {
+		fastCtx = ctx
+		return rowsOf([]string{"source"}, []driver.Value{"fast"}), nil
+	})
+	s := ReplicaSession(primary, replica1, replica2)
+
+	done := make(chan error, 1)
+	var source string
+	go func() {
+		var err error
+		source, err = QueryValue[string](context.Background(), s, "SELECT source FROM reports")
+		done <- err
+	}()
+	clock.waitForWaiters(t, 1)
+	if fast.count("FROM reports") != 0 {
+		t.Fatal("hedged before the delay")
+	}
+	clock.Advance(50 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if source != "fast" {
+		t.Errorf("source = %q", source)
+	}
+	if !errors.Is(fastCtx.Err(), context.Canceled) {
+		t.Errorf("winning attempt context err after its rows were closed = %v, want context.Canceled", fastCtx.Err())
+	}
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("slow attempt ended with %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the slow attempt was not cancelled")
+	}
+	wantStatements(t, fp)
+}
+
+func TestReadHedgingNotInTransaction(t *testing.T) {
+	clock := newFakeClock()
+	fp, primary := newFakeSession(t, "postgres", WithReadHedging(time.Nanosecond), WithClock(clock))
+	f1, replica1 := newFakeSession(t, "postgres")
+	f2, replica2 := newFakeSession(t, "postgres")
+	s := ReplicaSession(primary, replica1, replica2)
+	err := s.Transaction(context.Background(), func(ctx context.Context) error {
+		rows, err := s.QueryRows(ctx, "SELECT source FROM reports")
+		if err != nil {
+			return err
+		}
+		return rows.(*ContextRows).Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, fp, "BEGIN", "SELECT source FROM reports", "COMMIT")
+	wantStatements(t, f1)
+	wantStatements(t, f2)
+	if waits := clock.recorded(); len(waits) != 0 {
+		t.Errorf("hedge timer armed in a transaction: %v", waits)
+	}
+}
+
+func TestReadHedgingOnlyPlainSelects(t *testing.T) {
+	for _, query := range []string{
+		"SELECT id FROM jobs FOR UPDATE",
+		"SELECT id FROM jobs FOR NO KEY UPDATE SKIP LOCKED",
+		"SELECT id FROM jobs LOCK IN SHARE MODE",
+		"DELETE FROM jobs RETURNING id",
+	} {
+		t.Run(query, func(t *testing.T) {
+			clock := newFakeClock()
+			_, primary := newFakeSession(t, "postgres", WithReadHedging(time.Nanosecond), WithClock(clock))
+			f1, replica1 := newFakeSession(t, "postgres")
+			f2, replica2 := newFakeSession(t, "postgres")
+			s := ReplicaSession(primary, replica1, replica2)
+			rows, err := s.QueryRows(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := rows.(*ContextRows).Close(); err != nil {
+				t.Fatal(err)
+			}
+			if n := f1.count("jobs") + f2.count("jobs"); n != 1 {
+				t.Errorf("sent %d times, want once", n)
+			}
+			if waits := clock.recorded(); len(waits) != 0 {
+				t.Errorf("hedge timer armed: %v", waits)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+)
+
+// Result Query waiting to be decoded, built by Query on a session
+//
+// The statement runs when a decoder is called, once per call, and the rows are closed before the decoder
+// returns. The decoders take the same struct shapes and db tags as QueryRowsStruct
+type Result struct {
+	ctx   context.Context
+	tx    ITransaction
+	query string
+	args  []interface{}
+}
+
+// Query Prepare query for one of the decoders of Result, such as One or All
+func (r *RDMSSession) Query(ctx context.Context, query string, args ...interface{}) *Result {
+	return &Result{ctx: ctx, tx: r, query: query, args: args}
+}
+
+// Query Prepare query for one of the decoders of Result, such as One or All
+func (g *GormSession) Query(ctx context.Context, query string, args ...interface{}) *Result {
+	return &Result{ctx: ctx, tx: g, query: query, args: args}
+}
+
+// One Scan the first row into dest, a pointer to a struct or to a value scanned from a single column
+//
+// Returns sql.ErrNoRows when the result set is empty, the rows after the first one are discarded
+func (q *Result) One(dest interface{}) error {
+	v, err := decodeTarget(dest, reflect.Invalid)
+	if err != nil {
+		return err
+	}
+	rows, err := queryRows(q.ctx, q.tx, q.query, q.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	d, err := newRowDecoder(rows.Rows, v.Type(), optionsOf(q.tx))
+	if err != nil {
+		return err
+	}
+	if err = d.decode(rows.Rows, v); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// All Scan every row into dest, a pointer to a slice of structs, of pointers to structs or of single-column
+// values, replacing its content
+//
+// Like QueryRowsStruct, the query is cancelled as soon as a row fails to scan
+func (q *Result) All(dest interface{}) error {
+	v, err := decodeTarget(dest, reflect.Slice)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	rows, err := queryRows(ctx, q.tx, q.query, q.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	items := reflect.MakeSlice(v.Type(), 0, 0)
+	var d *rowDecoder
+	for rows.Next() {
+		if d == nil {
+			if d, err = newRowDecoder(rows.Rows, v.Type().Elem(), optionsOf(q.tx)); err != nil {
+				return err
+			}
+		}
+		item := reflect.New(v.Type().Elem()).Elem()
+		if err = d.decode(rows.Rows, item); err != nil {
+			return err
+		}
+		items = reflect.Append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	v.Set(items)
+	return rows.Close()
+}
+
+// Scalar Scan the single column of the first row into dest, such as a COUNT(*) into an int64
+//
+// Returns sql.ErrNoRows when the result set is empty and an error when the query returns more than one column
+func (q *Result) Scalar(dest interface{}) error {
+	if _, err := decodeTarget(dest, reflect.Invalid); err != nil {
+		return err
+	}
+	rows, err := queryRows(q.ctx, q.tx, q.query, q.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if len(columns) != 1 {
+		return fmt.Errorf("cransaction: expected a single column, query returned %d", len(columns))
+	}
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err = rows.Scan(dest); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// Map Return every row as a map of column name to the value returned by the driver, empty when there are none
+func (q *Result) Map() ([]map[string]interface{}, error) {
+	rows, err := queryRows(q.ctx, q.tx, q.query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	items := []map[string]interface{}{}
+	for rows.Next() {
+		if err = rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+		item := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			item[column] = values[i]
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, rows.Close()
+}
+
+// decodeTarget Value dest points to, checking it is a non-nil pointer and, unless kind is reflect.Invalid,
+// that it points to a value of that kind
+func decodeTarget(dest interface{}, kind reflect.Kind) (reflect.Value, error) {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return reflect.Value{}, fmt.Errorf("cransaction: cannot decode into %T, expected a non-nil pointer", dest)
+	}
+	if kind != reflect.Invalid && v.Elem().Kind() != kind {
+		return reflect.Value{}, fmt.Errorf("cransaction: cannot decode into %T, expected a pointer to a %s", dest, kind)
+	}
+	return v.Elem(), nil
+}
+
+// rowDecoder Scan a row into a value of one type, through the struct scanner for structs
+type rowDecoder struct {
+	s *structScanner
+}
+
+func newRowDecoder(rows *sql.Rows, t reflect.Type, o *options) (*rowDecoder, error) {
+	st := structType(t)
+	if st.Kind() != reflect.Struct || isLeafStruct(st) {
+		return &rowDecoder{}, nil
+	}
+	s, err := newStructScanner(rows, st, o)
+	if err != nil {
+		return nil, err
+	}
+	return &rowDecoder{s: s}, nil
+}
+
+// decode Scan the current row into v, allocating it when it is a pointer to a struct
+func (d *rowDecoder) decode(rows *sql.Rows, v reflect.Value) error {
+	if d.s == nil {
+		return rows.Scan(v.Addr().Interface())
+	}
+	return d.s.scan(rows, allocStruct(v))
+}
@@ -0,0 +1,90 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// querier Session offering Query, both backends do
+type querier interface {
+	Query(ctx context.Context, query string, args ...interface{}) *Result
+}
+
+func usersResult(f *fakeDB) {
+	f.on("FROM users", rowsOf([]string{"id", "name"},
+		[]driver.Value{int64(1), "ada"},
+		[]driver.Value{int64(2), "grace"}))
+	f.on("COUNT", rowsOf([]string{"count"}, []driver.Value{int64(2)}))
+}
+
+func TestResultDecoders(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			usersResult(f)
+			s := tx.(querier)
+			ctx := context.Background()
+
+			var one testPagedUser
+			if err := s.Query(ctx, "SELECT id, name FROM users WHERE id > $1", 0).One(&one); err != nil || one != (testPagedUser{1, "ada"}) {
+				t.Errorf("One = %+v, %v", one, err)
+			}
+			var all []testPagedUser
+			if err := s.Query(ctx, "SELECT id, name FROM users").All(&all); err != nil || !slices.Equal(all, []testPagedUser{{1, "ada"}, {2, "grace"}}) {
+				t.Errorf("All = %+v, %v", all, err)
+			}
+			var ptrs []*testPagedUser
+			if err := s.Query(ctx, "SELECT id, name FROM users").All(&ptrs); err != nil || len(ptrs) != 2 || *ptrs[1] != (testPagedUser{2, "grace"}) {
+				t.Errorf("All of pointers = %v, %v", ptrs, err)
+			}
+			var count int64
+			if err := s.Query(ctx, "SELECT COUNT(*) FROM accounts").Scalar(&count); err != nil || count != 2 {
+				t.Errorf("Scalar = %d, %v", count, err)
+			}
+			maps, err := s.Query(ctx, "SELECT id, name FROM users").Map()
+			if err != nil || len(maps) != 2 || maps[1]["name"] != "grace" || maps[0]["id"] != int64(1) {
+				t.Errorf("Map = %v, %v", maps, err)
+			}
+		})
+	}
+}
+
+func TestResultDecoderErrors(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	usersResult(f)
+	ctx := context.Background()
+	var user testPagedUser
+	if err := r.Query(ctx, "SELECT id, name FROM accounts").One(&user); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("One on no rows = %v, want sql.ErrNoRows", err)
+	}
+	var n int64
+	f.on("closed_accounts", rowsOf([]string{"id"}))
+	if err := r.Query(ctx, "SELECT id FROM closed_accounts").Scalar(&n); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Scalar on no rows = %v, want sql.ErrNoRows", err)
+	}
+	if err := r.Query(ctx, "SELECT id, name FROM users").Scalar(&n); err == nil || !strings.Contains(err.Error(), "single column") {
+		t.Errorf("Scalar on two columns = %v", err)
+	}
+	if err := r.Query(ctx, "SELECT id, name FROM users").One(user); err == nil || !strings.Contains(err.Error(), "non-nil pointer") {
+		t.Errorf("One into a value = %v", err)
+	}
+	if err := r.Query(ctx, "SELECT id, name FROM users").All(&user); err == nil || !strings.Contains(err.Error(), "slice") {
+		t.Errorf("All into a struct = %v", err)
+	}
+	all := []testPagedUser{{9, "stale"}}
+	if err := r.Query(ctx, "SELECT id, name FROM accounts").All(&all); err != nil || len(all) != 0 {
+		t.Errorf("All on no rows = %v, %v, want the slice emptied", all, err)
+	}
+	f.fail("broken", errFake)
+	if _, err := r.Query(ctx, "SELECT * FROM broken").Map(); !errors.Is(err, errFake) {
+		t.Errorf("Map err = %v", err)
+	}
+	if got := f.count("SELECT id, name FROM users"); got != 1 {
+		t.Errorf("%d queries, the invalid targets should not run theirs", got)
+	}
+}
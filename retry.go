@@ -0,0 +1,169 @@
+package cransaction
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// backoff Exponential delay between transaction attempts, see WithBackoff
+type backoff struct {
+	base   time.Duration
+	max    time.Duration
+	jitter bool
+}
+
+// WithBackoff Wait between the attempts of TransactionWithRetry, base doubled after every attempt up to max
+//
+// With jitter the wait is drawn uniformly between zero and that delay, so transactions that failed together do
+// not retry together. A zero max leaves the delay uncapped. Without WithBackoff attempts follow immediately
+func WithBackoff(base, max time.Duration, jitter bool) Option {
+	return func(o *options) {
+		o.txBackoff = backoff{base: base, max: max, jitter: jitter}
+	}
+}
+
+// WithRand Draw the backoff jitter from r instead of the shared source of math/rand, meant for tests that need
+// a reproducible sequence
+func WithRand(r *rand.Rand) Option {
+	return func(o *options) {
+		o.rand = &lockedRand{r: r}
+	}
+}
+
+// lockedRand Serialize the use of a *rand.Rand, which is not safe for concurrent use
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (l *lockedRand) int63n(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int63n(n)
+}
+
+func (o *options) int63n(n int64) int64 {
+	if o.rand == nil {
+		return rand.Int63n(n)
+	}
+	return o.rand.int63n(n)
+}
+
+// delay Wait before retry number attempt, starting at 1
+func (b backoff) delay(attempt int, int63n func(n int64) int64) time.Duration {
+	d := b.base
+	for i := 1; i < attempt && d > 0 && (b.max <= 0 || d < b.max); i++ {
+		d *= 2
+	}
+	if d < 0 {
+		// Doubling overflowed
+		d = math.MaxInt64
+	}
+	if b.max > 0 && d > b.max {
+		d = b.max
+	}
+	if !b.jitter || d <= 0 {
+		return d
+	}
+	return time.Duration(int63n(int64(d)))
+}
+
+// TransactionWithRetry Run fn in a transaction like Transaction, running it again in a new transaction up to
+// maxRetries times while it fails with a serialization failure or a deadlock
+//
+// fn may run several times, so it must not have effects outside the database that cannot be repeated. Nested
+// in a transaction of the session it runs once, the enclosing transaction is the one to retry
+func (r *RDMSSession) TransactionWithRetry(ctx context.Context, maxRetries int, fn func(context.Context) error) error {
+	if stateFor(ctx, r.db) != nil {
+		maxRetries = 0
+	}
+	return transactionWithRetry(ctx, r, &r.opts, maxRetries, fn)
+}
+
+// TransactionWithRetry Run fn in a transaction like Transaction, running it again in a new transaction up to
+// maxRetries times while it fails with a serialization failure or a deadlock
+//
+// fn may run several times, so it must not have effects outside the database that cannot be repeated. Nested
+// in a transaction of the session it runs once, the enclosing transaction is the one to retry
+func (g *GormSession) TransactionWithRetry(ctx context.Context, maxRetries int, fn func(context.Context) error) error {
+	if stateFor(ctx, g.db) != nil {
+		maxRetries = 0
+	}
+	return transactionWithRetry(ctx, g, &g.opts, maxRetries, fn)
+}
+
+func transactionWithRetry(ctx context.Context, tx ITransaction, o *options, maxRetries int, fn func(context.Context) error) error {
+	err := tx.Transaction(ctx, fn)
+	for i := 1; i <= maxRetries && IsSerializationFailure(err); i++ {
+		if wait := o.txBackoff.delay(i, o.int63n); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-o.after(wait):
+			}
+		}
+		err = tx.Transaction(ctx, fn)
+	}
+	return err
+}
+
+// ExecQueryRetryInTx Execute query in its own savepoint of the transaction in ctx, rolling back to the savepoint
+// and running it again up to maxRetries times while it fails with a serialization failure, a deadlock on
+// Postgres or a lock wait timeout on MySQL. The rest of the transaction is kept
+//
+// MySQL rolls back the whole transaction on a deadlock, so deadlocks are only retried on Postgres. Waits between
+// attempts follow WithBackoff. Returns ErrNoActiveTransaction outside a transaction of the session
+func (r *RDMSSession) ExecQueryRetryInTx(ctx context.Context, maxRetries int, query string, args ...interface{}) (interface{}, error) {
+	if stateFor(ctx, r.db) == nil {
+		return nil, ErrNoActiveTransaction
+	}
+	return execRetryInTx(ctx, r, &r.opts, maxRetries, query, args)
+}
+
+// ExecQueryRetryInTx Execute query in its own savepoint of the transaction in ctx, rolling back to the savepoint
+// and running it again up to maxRetries times while it fails with a serialization failure, a deadlock on
+// Postgres or a lock wait timeout on MySQL. The rest of the transaction is kept
+//
+// MySQL rolls back the whole transaction on a deadlock, so deadlocks are only retried on Postgres. Waits between
+// attempts follow WithBackoff. Returns ErrNoActiveTransaction outside a transaction of the session
+func (g *GormSession) ExecQueryRetryInTx(ctx context.Context, maxRetries int, query string, args ...interface{}) (interface{}, error) {
+	if stateFor(ctx, g.db) == nil {
+		return nil, ErrNoActiveTransaction
+	}
+	return execRetryInTx(ctx, g, &g.opts, maxRetries, query, args)
+}
+
+func execRetryInTx(ctx context.Context, tx ITransaction, o *options, maxRetries int, query string, args []interface{}) (interface{}, error) {
+	var result interface{}
+	attempt := func() error {
+		// The nested Transaction runs in a savepoint that is rolled back when the statement fails
+		return tx.Transaction(ctx, func(ctx context.Context) (err error) {
+			result, err = tx.ExecQuery(ctx, query, args...)
+			return err
+		})
+	}
+	err := attempt()
+	for i := 1; i <= maxRetries && isStatementRetryable(err); i++ {
+		if wait := o.txBackoff.delay(i, o.int63n); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, err
+			case <-o.after(wait):
+			}
+		}
+		err = attempt()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// isStatementRetryable Report whether a statement that failed with err can run again in the same transaction
+// once rolled back to a savepoint
+func isStatementRetryable(err error) bool {
+	return hasSQLState(err, "40001", "40P01") || hasMySQLNumber(err, 1205)
+}
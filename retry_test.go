@@ -0,0 +1,319 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// flaky Fail the first n statements it answers with err, then answer with res
+func flaky(n int, err error, res *fakeResult) func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+	return func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+		if n > 0 {
+			n--
+			return nil, err
+		}
+		return res, nil
+	}
+}
+
+func TestQueryRetryOutsideTransaction(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres", WithQueryRetry(3, RetryPolicy{}))
+			f.handle("SELECT", flaky(2, io.ErrUnexpectedEOF, rowsOf([]string{"n"}, []driver.Value{int64(7)})))
+			n, err := QueryValue[int64](context.Background(), s, "SELECT COUNT(*) FROM users")
+			if err != nil || n != 7 {
+				t.Fatalf("n = %d, err = %v", n, err)
+			}
+			if got := f.count("SELECT"); got != 3 {
+				t.Errorf("%d attempts, want 3", got)
+			}
+		})
+	}
+}
+
+func TestQueryRetryWritesOnlyWhenNotSent(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres", WithQueryRetry(3, RetryPolicy{}))
+			f.handle("UPDATE", flaky(1, io.ErrUnexpectedEOF, nil))
+			if _, err := s.ExecQuery(context.Background(), "UPDATE users SET visits = visits + 1"); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("err = %v", err)
+			}
+			if got := f.count("UPDATE"); got != 1 {
+				t.Errorf("%d attempts, a write the server may have run was retried", got)
+			}
+
+			f, s = open(t, "postgres", WithQueryRetry(3, RetryPolicy{Retryable: IsConnectionError}))
+			f.handle("UPDATE", flaky(1, io.ErrUnexpectedEOF, nil))
+			if _, err := s.ExecQuery(context.Background(), "UPDATE users SET visits = visits + 1"); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.count("UPDATE"); got != 2 {
+				t.Errorf("%d attempts, want the custom policy applied to writes", got)
+			}
+		})
+	}
+}
+
+func TestQueryRetryNeverInsideTransaction(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres", WithQueryRetry(3, RetryPolicy{}))
+			f.handle("UPDATE", flaky(1, io.ErrUnexpectedEOF, nil))
+			err := s.Transaction(context.Background(), func(ctx context.Context) error {
+				_, err := s.ExecQuery(ctx, "UPDATE users SET active = true")
+				return err
+			})
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("err = %v", err)
+			}
+			if got := f.count("UPDATE"); got != 1 {
+				t.Errorf("%d attempts inside the transaction, want 1", got)
+			}
+		})
+	}
+}
+
+func TestTransactionBackoffFollowsClock(t *testing.T) {
+	clock := &fakeClock{instant: true}
+	f, r := newFakeSession(t, "postgres", WithBackoff(10*time.Millisecond, 40*time.Millisecond, false), WithClock(clock))
+	f.handle("UPDATE", flaky(4, &pgError{"40001"}, nil))
+	err := r.TransactionWithRetry(context.Background(), 5, func(ctx context.Context) error {
+		_, err := r.ExecQuery(ctx, "UPDATE stock SET qty = qty - 1")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond}
+	if got := clock.recorded(); !slices.Equal(got, want) {
+		t.Errorf("waited %v, want %v", got, want)
+	}
+}
+
+func TestQueryRetryWaitsOnClock(t *testing.T) {
+	clock := newFakeClock()
+	policy := RetryPolicy{Backoff: func(attempt int) time.Duration { return time.Duration(attempt) * time.Hour }}
+	f, r := newFakeSession(t, "postgres", WithQueryRetry(2, policy), WithClock(clock))
+	f.handle("SELECT", flaky(2, io.ErrUnexpectedEOF, rowsOf([]string{"n"}, []driver.Value{int64(1)})))
+	done := make(chan error, 1)
+	go func() {
+		_, err := QueryValue[int64](context.Background(), r, "SELECT 1")
+		done <- err
+	}()
+	for attempt, wait := range []time.Duration{time.Hour, 2 * time.Hour} {
+		clock.waitForWaiters(t, 1)
+		if got := f.count("SELECT"); got != attempt+1 {
+			t.Fatalf("%d attempts before retry %d", got, attempt+1)
+		}
+		clock.Advance(wait - time.Minute)
+		select {
+		case <-done:
+			t.Fatalf("retry %d ran before its backoff elapsed", attempt+1)
+		default:
+		}
+		clock.Advance(time.Minute)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := f.count("SELECT"); got != 3 {
+		t.Errorf("%d attempts, want 3", got)
+	}
+}
+
+func TestTransactionBackoffJitter(t *testing.T) {
+	const base, max = 10 * time.Millisecond, 80 * time.Millisecond
+	run := func() []time.Duration {
+		clock := &fakeClock{instant: true}
+		f, r := newFakeSession(t, "postgres", WithBackoff(base, max, true), WithClock(clock), WithRand(rand.New(rand.NewSource(7))))
+		f.handle("UPDATE", flaky(6, &pgError{"40P01"}, nil))
+		err := r.TransactionWithRetry(context.Background(), 6, func(ctx context.Context) error {
+			_, err := r.ExecQuery(ctx, "UPDATE stock SET qty = qty - 1")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return clock.recorded()
+	}
+	got := run()
+	if len(got) != 6 {
+		t.Fatalf("waited %v, want 6 waits", got)
+	}
+	src := rand.New(rand.NewSource(7))
+	for i, wait := range got {
+		ceiling := min(base<<i, max)
+		if wait < 0 || wait >= ceiling {
+			t.Errorf("wait %d = %v, want it in [0, %v)", i+1, wait, ceiling)
+		}
+		if want := time.Duration(src.Int63n(int64(ceiling))); wait != want {
+			t.Errorf("wait %d = %v, want %v drawn from the seeded source", i+1, wait, want)
+		}
+	}
+	if again := run(); !slices.Equal(got, again) {
+		t.Errorf("same seed waited %v then %v", got, again)
+	}
+}
+
+func TestTransactionWithRetry(t *testing.T) {
+	t.Run("gives up", func(t *testing.T) {
+		f, r := newFakeSession(t, "mysql")
+		deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+		f.fail("UPDATE", deadlock)
+		attempts := 0
+		err := r.TransactionWithRetry(context.Background(), 2, func(ctx context.Context) error {
+			attempts++
+			_, err := r.ExecQuery(ctx, "UPDATE stock SET qty = qty - 1")
+			return err
+		})
+		if !errors.Is(err, deadlock) || attempts != 3 {
+			t.Errorf("err = %v after %d attempts, want the deadlock after 3", err, attempts)
+		}
+		if f.count("BEGIN") != 3 || f.count("ROLLBACK") != 3 {
+			t.Errorf("statements = %v", f.statements())
+		}
+	})
+	t.Run("other errors", func(t *testing.T) {
+		_, r := newFakeSession(t, "postgres")
+		attempts := 0
+		err := r.TransactionWithRetry(context.Background(), 3, func(context.Context) error {
+			attempts++
+			return errFake
+		})
+		if !errors.Is(err, errFake) || attempts != 1 {
+			t.Errorf("err = %v after %d attempts, want one attempt", err, attempts)
+		}
+	})
+	t.Run("nested", func(t *testing.T) {
+		f, g := newFakeGorm(t, "postgres")
+		attempts := 0
+		err := g.Transaction(context.Background(), func(ctx context.Context) error {
+			return g.TransactionWithRetry(ctx, 3, func(context.Context) error {
+				attempts++
+				return &pgError{"40001"}
+			})
+		})
+		if !IsSerializationFailure(err) || attempts != 1 {
+			t.Errorf("err = %v after %d attempts, want one attempt in the enclosing transaction", err, attempts)
+		}
+		if f.count("BEGIN") != 1 {
+			t.Errorf("statements = %v", f.statements())
+		}
+	})
+}
+
+func TestBadConnRetriedOnceOutsideTransaction(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			// database/sql gives up after three bad connections, the fourth attempt is the retry of the session
+			f.handle("UPDATE", flaky(3, driver.ErrBadConn, &fakeResult{affected: 1}))
+			if _, err := s.ExecQuery(context.Background(), "UPDATE users SET active = true"); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.count("UPDATE"); got != 4 {
+				t.Errorf("%d attempts, want 4", got)
+			}
+
+			f.handle("DELETE", flaky(6, driver.ErrBadConn, nil))
+			if _, err := s.ExecQuery(context.Background(), "DELETE FROM users"); !errors.Is(err, driver.ErrBadConn) {
+				t.Errorf("err = %v, want driver.ErrBadConn once the retry fails too", err)
+			}
+			if got := f.count("DELETE"); got != 6 {
+				t.Errorf("%d attempts, want a single retry", got)
+			}
+		})
+	}
+}
+
+func TestBadConnNotRetriedInTransaction(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			f.handle("UPDATE", flaky(1, driver.ErrBadConn, nil))
+			err := s.Transaction(context.Background(), func(ctx context.Context) error {
+				_, err := s.ExecQuery(ctx, "UPDATE users SET active = true")
+				return err
+			})
+			if !errors.Is(err, driver.ErrBadConn) {
+				t.Fatalf("err = %v, want driver.ErrBadConn", err)
+			}
+			if got := f.count("UPDATE"); got != 1 {
+				t.Errorf("%d attempts inside the transaction, want 1", got)
+			}
+		})
+	}
+}
+
+// retryInTxSession Session offering ExecQueryRetryInTx, both backends do
+type retryInTxSession interface {
+	ITransaction
+	ExecQueryRetryInTx(ctx context.Context, maxRetries int, query string, args ...interface{}) (interface{}, error)
+}
+
+func TestExecQueryRetryInTx(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			s := tx.(retryInTxSession)
+			f.handle("UPDATE", flaky(1, &pgError{"40P01"}, &fakeResult{affected: 1}))
+			err := s.Transaction(context.Background(), func(ctx context.Context) error {
+				if _, err := s.ExecQuery(ctx, "INSERT INTO orders VALUES (1)"); err != nil {
+					return err
+				}
+				if _, err := s.ExecQueryRetryInTx(ctx, 2, "UPDATE stock SET qty = qty - 1"); err != nil {
+					return err
+				}
+				_, err := s.ExecQuery(ctx, "INSERT INTO ledger VALUES (1)")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatements(t, f, "BEGIN", "INSERT INTO orders VALUES (1)",
+				"SAVEPOINT sp_2", "UPDATE stock SET qty = qty - 1", "ROLLBACK TO SAVEPOINT sp_2",
+				"SAVEPOINT sp_2", "UPDATE stock SET qty = qty - 1", "RELEASE SAVEPOINT sp_2",
+				"INSERT INTO ledger VALUES (1)", "COMMIT")
+		})
+	}
+}
+
+func TestExecQueryRetryInTxLimits(t *testing.T) {
+	t.Run("outside a transaction", func(t *testing.T) {
+		f, r := newFakeSession(t, "postgres")
+		if _, err := r.ExecQueryRetryInTx(context.Background(), 2, "UPDATE stock SET qty = 0"); !errors.Is(err, ErrNoActiveTransaction) {
+			t.Errorf("err = %v", err)
+		}
+		if len(f.statements()) != 0 {
+			t.Errorf("statements = %v", f.statements())
+		}
+	})
+	t.Run("mysql", func(t *testing.T) {
+		f, g := newFakeGorm(t, "mysql")
+		f.handle("UPDATE stock", flaky(1, &mysql.MySQLError{Number: 1205}, nil))
+		f.fail("UPDATE orders", &mysql.MySQLError{Number: 1213})
+		err := g.Transaction(context.Background(), func(ctx context.Context) error {
+			if _, err := g.ExecQueryRetryInTx(ctx, 2, "UPDATE stock SET qty = 0"); err != nil {
+				t.Errorf("lock wait timeout not retried: %v", err)
+			}
+			_, err := g.ExecQueryRetryInTx(ctx, 2, "UPDATE orders SET state = 'paid'")
+			return err
+		})
+		if !IsSerializationFailure(err) {
+			t.Fatalf("err = %v, want the deadlock", err)
+		}
+		if f.count("UPDATE stock") != 2 || f.count("UPDATE orders") != 1 {
+			t.Errorf("statements = %v, deadlocks are not retried on MySQL", f.statements())
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// ContextRows Rows returned by QueryRows, whatever the options of the session
+//
+// Close also finishes the work the session has on the rows once they are closed, such as releasing the timeout
+// context of WithQueryTimeout or logging the plan of WithExplainOnSlow. QueryRows used to return *sql.Rows:
+// callers asserting that type assert *ContextRows instead, the *sql.Rows stays reachable as its Rows field
+type ContextRows struct {
+	*sql.Rows
+	release   func()
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Close Close the underlying rows and finish the work of the session, only the first call reaches them and later
+// calls return its result
+func (r *ContextRows) Close() error {
+	r.closeOnce.Do(func() {
+		r.closeErr = r.Rows.Close()
+		if r.release != nil {
+			r.release()
+		}
+	})
+	return r.closeErr
+}
+
+// RowsResult Rows of a query bundled with their column types, for readers that need the types while iterating
+type RowsResult struct {
+	*sql.Rows
+	typesOnce sync.Once
+	types     []*sql.ColumnType
+	typesErr  error
+	closeOnce sync.Once
+	closeErr  error
+	// closer Rows returned by QueryRows, closed in place of Rows to release their context
+	closer *ContextRows
+}
+
+// ColumnTypes Column types of the result, fetched from the driver on the first call and cached
+func (r *RowsResult) ColumnTypes() ([]*sql.ColumnType, error) {
+	r.typesOnce.Do(func() {
+		r.types, r.typesErr = r.Rows.ColumnTypes()
+	})
+	return r.types, r.typesErr
+}
+
+// Close Close the underlying rows, only the first call reaches them and later calls return its result
+func (r *RowsResult) Close() error {
+	r.closeOnce.Do(func() {
+		if r.closer != nil {
+			r.closeErr = r.closer.Close()
+			return
+		}
+		r.closeErr = r.Rows.Close()
+	})
+	return r.closeErr
+}
+
+// QueryRowsWithMeta Query multiple rows like QueryRows and bundle them with their column types
+func (r *RDMSSession) QueryRowsWithMeta(ctx context.Context, query string, args ...interface{}) (*RowsResult, error) {
+	return queryRowsWithMeta(ctx, r, query, args)
+}
+
+// QueryRowsWithMeta Query multiple rows like QueryRows and bundle them with their column types
+func (g *GormSession) QueryRowsWithMeta(ctx context.Context, query string, args ...interface{}) (*RowsResult, error) {
+	return queryRowsWithMeta(ctx, g, query, args)
+}
+
+func queryRowsWithMeta(ctx context.Context, tx ITransaction, query string, args []interface{}) (*RowsResult, error) {
+	rows, err := queryRows(ctx, tx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &RowsResult{Rows: rows.Rows, closer: rows}, nil
+}
+
+// MultiRows Rows of a query returning several result sets, such as a stored procedure or a multi-statement query
+//
+// Next and Scan read the current result set and NextResultSet moves to the following one. Close releases all
+// of them, also the ones not read yet
+type MultiRows struct {
+	*sql.Rows
+	set int
+	// closer Rows returned by QueryRows, closed in place of Rows to release their context
+	closer *ContextRows
+}
+
+// Close Close every result set and release the context of the query
+func (m *MultiRows) Close() error {
+	if m.closer != nil {
+		return m.closer.Close()
+	}
+	return m.Rows.Close()
+}
+
+// NextResultSet Move to the next result set, false when there is none left or on error, reported by Err
+func (m *MultiRows) NextResultSet() bool {
+	if !m.Rows.NextResultSet() {
+		return false
+	}
+	m.set++
+	return true
+}
+
+// ResultSet Position of the current result set, starting at 0
+func (m *MultiRows) ResultSet() int {
+	return m.set
+}
+
+// QueryMultiple Query like QueryRows a statement returning several result sets
+//
+// The driver must support them: MySQL needs multiStatements=true in the DSN for multi-statement queries, not
+// for CALL, and lib/pq only returns them for queries without args
+func (r *RDMSSession) QueryMultiple(ctx context.Context, query string, args ...interface{}) (*MultiRows, error) {
+	return queryMultiple(ctx, r, query, args)
+}
+
+// QueryMultiple Query like QueryRows a statement returning several result sets
+//
+// The driver must support them: MySQL needs multiStatements=true in the DSN for multi-statement queries, not
+// for CALL, and lib/pq only returns them for queries without args
+func (g *GormSession) QueryMultiple(ctx context.Context, query string, args ...interface{}) (*MultiRows, error) {
+	return queryMultiple(ctx, g, query, args)
+}
+
+func queryMultiple(ctx context.Context, tx ITransaction, query string, args []interface{}) (*MultiRows, error) {
+	// The cache only keeps the first result set
+	rows, err := queryRows(context.WithValue(ctx, noCacheKey{}, true), tx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiRows{Rows: rows.Rows, closer: rows}, nil
+}
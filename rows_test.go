@@ -0,0 +1,148 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestQueryRowsWithMeta(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	r.db.SetMaxOpenConns(1)
+	res := rowsOf([]string{"id", "price"}, []driver.Value{int64(1), "9.99"}, []driver.Value{int64(2), "19.99"})
+	res.types = []string{"INT8", "NUMERIC"}
+	f.on("FROM products", res)
+	f.on("SELECT 1", rowsOf([]string{"one"}, []driver.Value{int64(1)}))
+
+	rows, err := r.QueryRowsWithMeta(context.Background(), "SELECT id, price FROM products")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen int
+	for rows.Next() {
+		types, err := rows.ColumnTypes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(types) != 2 || types[0].DatabaseTypeName() != "INT8" || types[1].DatabaseTypeName() != "NUMERIC" {
+			t.Fatalf("types = %v", types)
+		}
+		again, _ := rows.ColumnTypes()
+		if &again[0] != &types[0] {
+			t.Error("column types are fetched again")
+		}
+		var id int64
+		var price string
+		if err = rows.Scan(&id, &price); err != nil {
+			t.Fatal(err)
+		}
+		seen++
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if seen != 2 {
+		t.Errorf("iterated %d rows", seen)
+	}
+	if err = rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = rows.Close(); err != nil {
+		t.Errorf("second Close err = %v", err)
+	}
+	// the single connection of the pool is free again once the rows are closed
+	var n int
+	if err = r.QueryRow(context.Background(), "SELECT 1").(Row).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// multiQuerier Session offering QueryMultiple, both backends do
+type multiQuerier interface {
+	ITransaction
+	QueryMultiple(ctx context.Context, query string, args ...interface{}) (*MultiRows, error)
+}
+
+func TestQueryMultipleReadsEverySet(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, tx := open(t, "mysql", WithQueryCache(NewMemoryQueryCache(), time.Minute))
+			users := rowsOf([]string{"id", "name"}, []driver.Value{int64(1), "ada"}, []driver.Value{int64(2), "grace"})
+			users.next = rowsOf([]string{"total"}, []driver.Value{int64(2)})
+			f.on("FROM users", users)
+			s := tx.(multiQuerier)
+			for attempt := 0; attempt < 2; attempt++ {
+				rows, err := s.QueryMultiple(context.Background(), "SELECT id, name FROM users WHERE team = ?; SELECT COUNT(*) FROM users WHERE team = ?", 7, 7)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for rows.Next() {
+					var id int64
+					var name string
+					if err = rows.Scan(&id, &name); err != nil {
+						t.Fatal(err)
+					}
+					names = append(names, name)
+				}
+				if !rows.NextResultSet() || rows.ResultSet() != 1 {
+					t.Fatalf("no second result set: %v", rows.Err())
+				}
+				var total int64
+				if !rows.Next() {
+					t.Fatalf("second result set empty: %v", rows.Err())
+				}
+				if err = rows.Scan(&total); err != nil {
+					t.Fatal(err)
+				}
+				if rows.NextResultSet() {
+					t.Error("a third result set")
+				}
+				if err = rows.Err(); err != nil {
+					t.Fatal(err)
+				}
+				if err = rows.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(names, []string{"ada", "grace"}) || total != 2 {
+					t.Errorf("read %v and %d", names, total)
+				}
+			}
+			if got := f.count("FROM users"); got != 2 {
+				t.Errorf("%d queries, want every QueryMultiple to bypass the cache", got)
+			}
+		})
+	}
+}
+
+func TestQueryRowsTypeIndependentOfOptions(t *testing.T) {
+	configs := map[string][]Option{
+		"plain":   nil,
+		"timeout": {WithQueryTimeout(time.Minute)},
+		"explain": {WithExplainOnSlow(true), WithSlowQueryThreshold(time.Hour)},
+		"cache":   {WithQueryCache(NewMemoryQueryCache(), time.Minute)},
+	}
+	for backend, open := range testSessions {
+		for name, opts := range configs {
+			t.Run(backend+"/"+name, func(t *testing.T) {
+				f, tx := open(t, "postgres", opts...)
+				f.on("FROM users", rowsOf([]string{"id"}, []driver.Value{int64(1)}))
+				for attempt := 0; attempt < 2; attempt++ {
+					result, err := tx.QueryRows(context.Background(), "SELECT id FROM users")
+					if err != nil {
+						t.Fatal(err)
+					}
+					rows, ok := result.(*ContextRows)
+					if !ok {
+						t.Fatalf("QueryRows returned %T", result)
+					}
+					if err = rows.Close(); err != nil {
+						t.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,656 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// QueryRowStruct Query single row and scan it into T, returns sql.ErrNoRows when the result set is empty
+func QueryRowStruct[T any](ctx context.Context, tx ITransaction, query string, args ...interface{}) (T, error) {
+	v, found, err := QueryRowStructOK[T](ctx, tx, query, args...)
+	if err != nil {
+		return v, err
+	}
+	if !found {
+		return v, sql.ErrNoRows
+	}
+	return v, nil
+}
+
+// QueryRowStructOK Query single row and scan it into T, found is false with nil error when the result set is empty
+func QueryRowStructOK[T any](ctx context.Context, tx ITransaction, query string, args ...interface{}) (T, bool, error) {
+	var v T
+	rows, err := queryRows(ctx, tx, query, args...)
+	if err != nil {
+		return v, false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return v, false, rows.Err()
+	}
+	s, err := newStructScanner(rows.Rows, structType(reflect.TypeOf(&v).Elem()), optionsOf(tx))
+	if err != nil {
+		return v, false, err
+	}
+	if err = s.scan(rows.Rows, allocStruct(reflect.ValueOf(&v).Elem())); err != nil {
+		return v, false, err
+	}
+	return v, true, rows.Close()
+}
+
+// QueryRowsStruct Query multiple rows and scan each one into T, a struct or a pointer to one allocated per row
+//
+// The query runs under a context that is cancelled as soon as scanning stops, so a scan error
+// mid-iteration stops the server-side work instead of waiting for the result set to drain
+//
+// With WithPartialOnTimeout the rows scanned before the context deadline are returned along with an
+// error wrapping context.DeadlineExceeded
+func QueryRowsStruct[T any](ctx context.Context, tx ITransaction, query string, args ...interface{}) ([]T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rows, err := queryRows(ctx, tx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	o := optionsOf(tx)
+	var items []T
+	var s *structScanner
+	for rows.Next() {
+		var v T
+		if s == nil {
+			if s, err = newStructScanner(rows.Rows, structType(reflect.TypeOf(&v).Elem()), o); err != nil {
+				cancel()
+				return nil, err
+			}
+		}
+		if err = s.scan(rows.Rows, allocStruct(reflect.ValueOf(&v).Elem())); err != nil {
+			cancel()
+			return partialResult(ctx, o, items, err)
+		}
+		items = append(items, v)
+	}
+	if err = rows.Err(); err != nil {
+		return partialResult(ctx, o, items, err)
+	}
+	return items, rows.Close()
+}
+
+// WithPartialOnTimeout Make QueryRowsStruct return the rows scanned so far when the context deadline is hit
+// mid-iteration, along with an error wrapping context.DeadlineExceeded, instead of discarding them
+func WithPartialOnTimeout(enabled bool) Option {
+	return func(o *options) {
+		o.partialOnTimeout = enabled
+	}
+}
+
+// partialResult Result of a rows helper interrupted by err, items are kept only for a deadline with WithPartialOnTimeout
+func partialResult[T any](ctx context.Context, o *options, items []T, err error) ([]T, error) {
+	if !o.partialOnTimeout || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, err
+	}
+	return items, fmt.Errorf("cransaction: partial result of %d rows: %w", len(items), context.DeadlineExceeded)
+}
+
+// QueryValue Query a single row with a single column and scan the value into T, such as a COUNT(*) into int64
+//
+// Returns sql.ErrNoRows when the result set is empty and an error when the query returns more than one column
+func QueryValue[T any](ctx context.Context, tx ITransaction, query string, args ...interface{}) (T, error) {
+	var v T
+	rows, err := queryRows(ctx, tx, query, args...)
+	if err != nil {
+		return v, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return v, err
+	}
+	if len(columns) != 1 {
+		return v, fmt.Errorf("cransaction: expected a single column, query returned %d", len(columns))
+	}
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return v, err
+		}
+		return v, sql.ErrNoRows
+	}
+	if err = rows.Scan(&v); err != nil {
+		return v, err
+	}
+	return v, rows.Close()
+}
+
+// queryRows Run QueryRows on tx and unwrap the *ContextRows returned by the session
+func queryRows(ctx context.Context, tx ITransaction, query string, args ...interface{}) (*ContextRows, error) {
+	result, err := tx.QueryRows(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	rows, ok := result.(*ContextRows)
+	if !ok {
+		return nil, fmt.Errorf("cransaction: unexpected rows type %T", result)
+	}
+	return rows, nil
+}
+
+// structScanner Scan rows into a struct, the column to field mapping is resolved once per result set
+type structScanner struct {
+	columns []string
+	fields  [][]int
+	// decoders Normalize the raw value of a column before it is assigned, nil for columns scanned directly
+	decoders []valueDecoder
+	// nullable Keys of the pointer-to-struct fields on the path of each column, see nullableField
+	nullable [][]string
+	// extra Index of the field collecting the unmapped columns, nil unless UnmappedCollectInto applies
+	extra []int
+}
+
+// UnmappedColumnPolicy How the generic scanners treat a column that matches no struct field
+type UnmappedColumnPolicy int
+
+const (
+	// UnmappedError Fail the scan, the default
+	UnmappedError UnmappedColumnPolicy = iota
+	// UnmappedIgnore Drop the column
+	UnmappedIgnore
+	// UnmappedCollectInto Store the column in the map[string]interface{} field tagged `db:",extra"`, keyed by
+	// column name
+	UnmappedCollectInto
+)
+
+// WithUnmappedColumnPolicy Set how the generic scanners treat columns that match no struct field
+func WithUnmappedColumnPolicy(policy UnmappedColumnPolicy) Option {
+	return func(o *options) {
+		o.unmappedColumns = policy
+	}
+}
+
+var extraMapType = reflect.TypeOf(map[string]interface{}(nil))
+
+// extraField Index of the field of t tagged `db:",extra"`
+func extraField(t reflect.Type) ([]int, error) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !hasTagOption(f, "extra") {
+			continue
+		}
+		if f.Type != extraMapType {
+			return nil, fmt.Errorf("cransaction: extra field %s.%s must be a map[string]interface{}", t, f.Name)
+		}
+		return f.Index, nil
+	}
+	return nil, fmt.Errorf("cransaction: no field in %s tagged db:\",extra\" to collect unmapped columns", t)
+}
+
+// valueDecoder Turn a raw column value into the value assigned to the field
+type valueDecoder func(src interface{}) (interface{}, error)
+
+func newStructScanner(rows *sql.Rows, t reflect.Type, o *options) (*structScanner, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cransaction: cannot scan into %s, expected struct", t)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	byName, all := mapStructFields(t)
+	occurrences := make(map[string]int)
+	for _, column := range columns {
+		occurrences[strings.ToLower(column)]++
+	}
+	seen := make(map[string]int)
+	s := &structScanner{
+		columns:  columns,
+		fields:   make([][]int, len(columns)),
+		decoders: make([]valueDecoder, len(columns)),
+		nullable: make([][]string, len(columns)),
+	}
+	for i, column := range columns {
+		name := strings.ToLower(column)
+		index, ok := byName[name]
+		if occurrences[name] > 1 || !ok {
+			// A column repeated by a join, such as the id of two tables, goes to the fields it may map to in
+			// order, and a column without a field of its own to the one prefixed field ending with it
+			candidates := columnCandidates(all, name)
+			switch {
+			case occurrences[name] > 1 && seen[name] < len(candidates):
+				index, ok = candidates[seen[name]], true
+			case !ok && len(candidates) == 1:
+				index, ok = candidates[0], true
+			}
+			seen[name]++
+		}
+		if !ok {
+			switch o.unmappedColumns {
+			case UnmappedIgnore:
+				continue
+			case UnmappedCollectInto:
+				if s.extra == nil {
+					if s.extra, err = extraField(t); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+			return nil, fmt.Errorf("cransaction: no field in %s for column %q", t, column)
+		}
+		s.fields[i] = index
+		s.nullable[i] = pointerPrefixes(t, index)
+		field := t.FieldByIndex(index)
+		if hasTagOption(field, "json") {
+			s.decoders[i] = decodeJSON(field.Type)
+			continue
+		}
+		s.decoders[i] = o.decoderFor(types[i].DatabaseTypeName(), field.Type)
+	}
+	return s, nil
+}
+
+// scan Scan the current row into v
+//
+// Columns under a pointer to a struct are scanned into holders first: when all the columns of that struct
+// are NULL, as for the unmatched side of a LEFT JOIN, the pointer is left nil
+func (s *structScanner) scan(rows *sql.Rows, v reflect.Value) error {
+	targets := make([]interface{}, len(s.fields))
+	raw := make([]interface{}, len(s.fields))
+	holders := make([]reflect.Value, len(s.fields))
+	for i, index := range s.fields {
+		switch {
+		case index == nil || s.decoders[i] != nil:
+			targets[i] = &raw[i]
+		case len(s.nullable[i]) > 0:
+			holders[i] = reflect.New(nullableType(v.Type().FieldByIndex(index).Type))
+			targets[i] = holders[i].Interface()
+		default:
+			targets[i] = v.FieldByIndex(index).Addr().Interface()
+		}
+	}
+	if err := rows.Scan(targets...); err != nil {
+		return err
+	}
+	if s.extra != nil {
+		extra := make(map[string]interface{})
+		for i, index := range s.fields {
+			if index == nil {
+				extra[s.columns[i]] = raw[i]
+			}
+		}
+		v.FieldByIndex(s.extra).Set(reflect.ValueOf(extra))
+	}
+	present := make(map[string]bool)
+	for i, keys := range s.nullable {
+		if (holders[i].IsValid() && !holders[i].Elem().IsNil()) || (!holders[i].IsValid() && raw[i] != nil) {
+			for _, key := range keys {
+				present[key] = true
+			}
+		}
+	}
+	for i, index := range s.fields {
+		if index == nil || (s.decoders[i] == nil && len(s.nullable[i]) == 0) {
+			continue
+		}
+		field, ok := nullableField(v, index, present)
+		if !ok {
+			continue
+		}
+		var err error
+		if s.decoders[i] == nil {
+			setFromHolder(field, holders[i])
+		} else {
+			var value interface{}
+			if value, err = s.decoders[i](raw[i]); err == nil {
+				err = assignValue(field, value)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("cransaction: column %q: %w", s.columns[i], err)
+		}
+	}
+	return nil
+}
+
+// structType Struct scanned for a T of type t, a struct or a pointer to one
+func structType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+// allocStruct Struct to scan into for v, allocated when v is a pointer
+func allocStruct(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Pointer {
+		v.Set(reflect.New(v.Type().Elem()))
+		return v.Elem()
+	}
+	return v
+}
+
+// pointerPrefixes Keys of the pointer fields crossed on the way from t to the field at index
+func pointerPrefixes(t reflect.Type, index []int) []string {
+	var keys []string
+	for j, x := range index[:len(index)-1] {
+		ft := t.Field(x).Type
+		if ft.Kind() == reflect.Pointer {
+			keys = append(keys, fmt.Sprint(index[:j+1]))
+			ft = ft.Elem()
+		}
+		t = ft
+	}
+	return keys
+}
+
+// nullableField Field at index in v, allocating the pointers to structs along the way, ok is false when one
+// of them stays nil because all its columns are NULL
+func nullableField(v reflect.Value, index []int, present map[string]bool) (reflect.Value, bool) {
+	for j, x := range index {
+		if v.Kind() == reflect.Pointer {
+			if !present[fmt.Sprint(index[:j])] {
+				v.Set(reflect.Zero(v.Type()))
+				return reflect.Value{}, false
+			}
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// nullableType Type of the holder a column of a field of type t is scanned into, a pointer set to nil for NULL
+func nullableType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t
+	}
+	return reflect.PointerTo(t)
+}
+
+// setFromHolder Store the value scanned into holder, a NULL leaves the zero value
+func setFromHolder(field reflect.Value, holder reflect.Value) {
+	value := holder.Elem()
+	if field.Kind() != reflect.Pointer {
+		if value.IsNil() {
+			field.Set(reflect.Zero(field.Type()))
+			return
+		}
+		value = value.Elem()
+	}
+	field.Set(value)
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// structFields Map lower-cased column names to field indexes, using the db tag or the field name and its snake_case form
+//
+// Fields of embedded structs are mapped under their own names and fields of other struct fields, or pointers to
+// structs, under the name of the field followed by a dot, as in "team.name". Structs scanned from one column,
+// such as time.Time and sql.NullString, are mapped as a whole
+//
+// A column the result set repeats, such as the id of both sides of a join, is scanned into the fields named
+// after it or tagged with a prefix ending with it, as db:"users.id", one per occurrence in field order. A
+// column with no field of its own goes to the one prefixed field ending with it
+func structFields(t reflect.Type) map[string][]int {
+	fields, _ := mapStructFields(t)
+	return fields
+}
+
+// fieldName Name a field is mapped under, shadowed names included
+type fieldName struct {
+	name  string
+	index []int
+}
+
+// mapStructFields Map the fields of t like structFields and also list every name with its field, outer
+// fields first and nested structs in declaration order
+func mapStructFields(t reflect.Type) (map[string][]int, []fieldName) {
+	fields := make(map[string][]int)
+	var all []fieldName
+	addStructFields(fields, &all, t, "", nil, map[reflect.Type]bool{t: true})
+	return fields, all
+}
+
+// columnCandidates Fields column may be scanned into: the ones mapped under column itself or under a prefixed
+// name ending with it, such as users.id for id, each field listed once
+func columnCandidates(all []fieldName, column string) [][]int {
+	var candidates [][]int
+	seen := make(map[string]bool)
+	for _, f := range all {
+		if f.name != column && !strings.HasSuffix(f.name, "."+column) {
+			continue
+		}
+		if key := fmt.Sprint(f.index); !seen[key] {
+			seen[key] = true
+			candidates = append(candidates, f.index)
+		}
+	}
+	return candidates
+}
+
+func addStructFields(fields map[string][]int, all *[]fieldName, t reflect.Type, prefix string, parent []int, visiting map[reflect.Type]bool) {
+	level := make(map[string][]int)
+	var nested []func()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("db"), ",")
+		if tag == "-" || hasTagOption(f, "extra") {
+			continue
+		}
+		index := append(append([]int(nil), parent...), i)
+		if !f.IsExported() {
+			// The exported fields of an embedded struct of an unexported type are still promoted, as with
+			// encoding/json, but a pointer to it could not be allocated
+			if st := f.Type; f.Anonymous && st.Kind() == reflect.Struct && !isLeafStruct(st) && !visiting[st] {
+				nested = append(nested, func() {
+					visiting[st] = true
+					addStructFields(fields, all, st, prefix, index, visiting)
+					delete(visiting, st)
+				})
+			}
+			continue
+		}
+		var names []string
+		if tag != "" {
+			names = []string{strings.ToLower(tag)}
+		} else {
+			names = []string{strings.ToLower(f.Name), toSnakeCase(f.Name)}
+		}
+		for _, name := range names {
+			level[prefix+name] = index
+			*all = append(*all, fieldName{name: prefix + name, index: index})
+		}
+		st := structType(f.Type)
+		if !isNestedStruct(f, st) || visiting[st] {
+			continue
+		}
+		nestedPrefix := prefix
+		if !f.Anonymous || tag != "" {
+			nestedPrefix = prefix + names[0] + "."
+		}
+		nested = append(nested, func() {
+			visiting[st] = true
+			addStructFields(fields, all, st, nestedPrefix, index, visiting)
+			delete(visiting, st)
+		})
+	}
+	// Outer fields win over the ones promoted from nested structs, as in Go
+	for name, index := range level {
+		if _, ok := fields[name]; !ok {
+			fields[name] = index
+		}
+	}
+	for _, add := range nested {
+		add()
+	}
+}
+
+// isNestedStruct Whether the columns of field f of struct type st map to the fields of st
+func isNestedStruct(f reflect.StructField, st reflect.Type) bool {
+	return st.Kind() == reflect.Struct && !isLeafStruct(st) && !hasTagOption(f, "json")
+}
+
+// isLeafStruct Whether a struct type is scanned from a single column, as time.Time and the sql.Scanner
+// implementations such as sql.NullString, sql.NullTime and sql.Null[T]
+func isLeafStruct(st reflect.Type) bool {
+	return st == timeType || reflect.PointerTo(st).Implements(scannerType)
+}
+
+// hasTagOption Whether the db tag of f lists option after the column name, as in `db:"payload,json"`
+func hasTagOption(f reflect.StructField, option string) bool {
+	options := strings.Split(f.Tag.Get("db"), ",")
+	for _, o := range options[1:] {
+		if strings.TrimSpace(o) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeJSON Unmarshal a JSON or JSONB column into a value of type t, NULL leaves the zero value
+func decodeJSON(t reflect.Type) valueDecoder {
+	return func(src interface{}) (interface{}, error) {
+		var data []byte
+		switch v := src.(type) {
+		case nil:
+			return nil, nil
+		case []byte:
+			data = v
+		case string:
+			data = []byte(v)
+		default:
+			return nil, fmt.Errorf("cannot decode %T as JSON", src)
+		}
+		ptr := reflect.New(t)
+		if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+			return nil, err
+		}
+		return ptr.Elem().Interface(), nil
+	}
+}
+
+func toSnakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	nullTimeType = reflect.TypeOf(sql.NullTime{})
+)
+
+// decoderFor Pick how a column of dbType is assigned to a field of type field, nil to scan directly
+func (o *options) decoderFor(dbType string, field reflect.Type) valueDecoder {
+	dbType = strings.ToUpper(dbType)
+	if convert, ok := o.typeConverters[dbType]; ok {
+		return func(src interface{}) (interface{}, error) {
+			switch v := src.(type) {
+			case []byte:
+				return convert(v)
+			case string:
+				return convert([]byte(v))
+			}
+			return src, nil
+		}
+	}
+	if field.Kind() == reflect.Pointer {
+		field = field.Elem()
+	}
+	if isTimestampType(dbType) && (field == timeType || field == nullTimeType) {
+		return decodeTimestamp
+	}
+	return nil
+}
+
+func isTimestampType(dbType string) bool {
+	switch dbType {
+	case "DATE", "DATETIME", "TIMESTAMP", "TIMESTAMPTZ", "TIMESTAMP WITH TIME ZONE", "TIMESTAMP WITHOUT TIME ZONE":
+		return true
+	}
+	return false
+}
+
+// timestampLayouts Text forms of timestamps, MySQL returns them as text unless parseTime is set on the DSN
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// decodeTimestamp Normalize a timestamp column to time.Time whatever form the driver returned it in
+func decodeTimestamp(src interface{}) (interface{}, error) {
+	var text string
+	switch v := src.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return src, nil
+	}
+	if strings.HasPrefix(text, "0000-00-00") {
+		return time.Time{}, nil
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot parse %q as a timestamp", text)
+}
+
+// assignValue Store a decoded value into field, allocating pointers and honoring sql.Scanner
+func assignValue(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	if scanner, ok := field.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(value)
+	}
+	if field.Kind() == reflect.Pointer {
+		ptr := reflect.New(field.Type().Elem())
+		if err := assignValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	switch {
+	case rv.Type().AssignableTo(field.Type()):
+		field.Set(rv)
+	case rv.Kind() == field.Kind() && rv.Type().ConvertibleTo(field.Type()):
+		field.Set(rv.Convert(field.Type()))
+	case field.Kind() == reflect.String && rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		// Enum and text columns come back as []byte, store them into string-based types such as type Status string
+		field.SetString(string(rv.Bytes()))
+	default:
+		return fmt.Errorf("cannot assign %T to %s", value, field.Type())
+	}
+	return nil
+}
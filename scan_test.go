@@ -0,0 +1,482 @@
+package cransaction
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAccount struct {
+	ID      int64  `db:"id"`
+	Name    string `db:"name"`
+	Balance int64  `db:"balance"`
+}
+
+func TestQueryRowStructOK(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	f.on("id = 1", rowsOf([]string{"id", "name", "balance"}, []driver.Value{int64(1), "ada", int64(30)}))
+	f.on("id = 2", rowsOf([]string{"id", "name", "balance"}))
+	ctx := context.Background()
+
+	got, found, err := QueryRowStructOK[testAccount](ctx, r, "SELECT * FROM accounts WHERE id = 1")
+	if err != nil || !found {
+		t.Fatalf("found = %v, err = %v", found, err)
+	}
+	if got != (testAccount{ID: 1, Name: "ada", Balance: 30}) {
+		t.Errorf("got %+v", got)
+	}
+
+	got, found, err = QueryRowStructOK[testAccount](ctx, r, "SELECT * FROM accounts WHERE id = 2")
+	if err != nil || found {
+		t.Fatalf("found = %v, err = %v", found, err)
+	}
+	if got != (testAccount{}) {
+		t.Errorf("got %+v, want the zero value", got)
+	}
+
+	if _, err = QueryRowStruct[testAccount](ctx, r, "SELECT * FROM accounts WHERE id = 2"); err != sql.ErrNoRows {
+		t.Errorf("QueryRowStruct err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestQueryRowsStructCancelsOnScanError(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	var queryCtx context.Context
+	f.handle("FROM accounts", func(ctx context.Context, _ []driver.NamedValue) (*fakeResult, error) {
+		queryCtx = ctx
+		return rowsOf([]string{"id", "name", "balance"},
+			[]driver.Value{int64(1), "ada", int64(30)},
+			[]driver.Value{int64(2), "bob", "not a number"},
+			[]driver.Value{int64(3), "eve", int64(10)},
+		), nil
+	})
+	items, err := QueryRowsStruct[testAccount](context.Background(), r, "SELECT * FROM accounts")
+	if err == nil {
+		t.Fatal("expected the scan error of the second row")
+	}
+	if items != nil {
+		t.Errorf("items = %+v, want none", items)
+	}
+	if queryCtx.Err() != context.Canceled {
+		t.Errorf("query context err = %v, want context.Canceled", queryCtx.Err())
+	}
+}
+
+// slowAccounts Five accounts whose rows after the second take delay each to arrive
+func slowAccounts(delay time.Duration) *fakeResult {
+	res := rowsOf([]string{"id", "name", "balance"})
+	for id := int64(1); id <= 5; id++ {
+		res.rows = append(res.rows, []driver.Value{id, "user", int64(10)})
+	}
+	res.fastRows, res.delay = 2, delay
+	return res
+}
+
+func TestQueryRowsStructPartialOnTimeout(t *testing.T) {
+	f, r := newFakeSession(t, "postgres", WithPartialOnTimeout(true))
+	f.on("FROM accounts", slowAccounts(100*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	items, err := QueryRowsStruct[testAccount](ctx, r, "SELECT * FROM accounts")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want it to wrap context.DeadlineExceeded", err)
+	}
+	// The third row may land while the deadline closes the rows
+	if len(items) < 2 || len(items) > 3 {
+		t.Fatalf("%d partial rows, want the 2 fast ones and at most the one in flight", len(items))
+	}
+	for i, item := range items {
+		if item.ID != int64(i+1) {
+			t.Errorf("row %d has id %d", i, item.ID)
+		}
+	}
+	// The pool of one connection is free again, the rows were closed
+	r.db.SetMaxOpenConns(1)
+	if _, err = r.ExecQuery(context.Background(), "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryRowsStructAllOrNothingOnTimeout(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	f.on("FROM accounts", slowAccounts(100*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	items, err := QueryRowsStruct[testAccount](ctx, r, "SELECT * FROM accounts")
+	if err == nil || items != nil {
+		t.Fatalf("items = %+v, err = %v, want no rows and an error", items, err)
+	}
+}
+
+func TestQueryValue(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			f.on("COUNT(*)", rowsOf([]string{"count"}, []driver.Value{int64(42)}))
+			f.on("SELECT name", rowsOf([]string{"name"}, []driver.Value{"ada"}))
+			f.on("SELECT id, name", rowsOf([]string{"id", "name"}, []driver.Value{int64(1), "ada"}))
+			f.on("SELECT missing", rowsOf([]string{"missing"}))
+			ctx := context.Background()
+
+			n, err := QueryValue[int64](ctx, s, "SELECT COUNT(*) FROM users")
+			if err != nil || n != 42 {
+				t.Errorf("count = %d, err = %v", n, err)
+			}
+			name, err := QueryValue[string](ctx, s, "SELECT name FROM users")
+			if err != nil || name != "ada" {
+				t.Errorf("name = %q, err = %v", name, err)
+			}
+			if _, err = QueryValue[string](ctx, s, "SELECT id, name FROM users"); err == nil {
+				t.Error("two columns accepted")
+			}
+			if _, err = QueryValue[string](ctx, s, "SELECT missing FROM users"); err != sql.ErrNoRows {
+				t.Errorf("empty result err = %v, want sql.ErrNoRows", err)
+			}
+		})
+	}
+}
+
+// testDecimal Stand-in for a decimal type of another package
+type testDecimal struct {
+	text string
+}
+
+type testInvoice struct {
+	ID       int64       `db:"id"`
+	IssuedAt time.Time   `db:"issued_at"`
+	Total    testDecimal `db:"total"`
+}
+
+func TestTimestampAndDecimalNormalization(t *testing.T) {
+	issued := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	results := map[string]*fakeResult{
+		// Postgres drivers return timestamps as time.Time and numerics as text
+		"postgres": {
+			columns: []string{"id", "issued_at", "total"},
+			types:   []string{"INT8", "TIMESTAMPTZ", "NUMERIC"},
+			rows:    [][]driver.Value{{int64(1), issued, []byte("12.50")}},
+		},
+		// MySQL returns both as bytes without parseTime
+		"mysql": {
+			columns: []string{"id", "issued_at", "total"},
+			types:   []string{"BIGINT", "DATETIME", "DECIMAL"},
+			rows:    [][]driver.Value{{int64(1), []byte("2024-03-01 10:00:00"), []byte("12.50")}},
+		},
+	}
+	decimal := func(b []byte) (interface{}, error) {
+		return testDecimal{text: string(b)}, nil
+	}
+	for backend, open := range testSessions {
+		for dialect, res := range results {
+			t.Run(backend+"/"+dialect, func(t *testing.T) {
+				f, s := open(t, dialect, WithTypeConverter("numeric", decimal), WithTypeConverter("decimal", decimal))
+				f.on("FROM invoices", res)
+				got, err := QueryRowStruct[testInvoice](context.Background(), s, "SELECT * FROM invoices")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !got.IssuedAt.Equal(issued) || got.Total.text != "12.50" {
+					t.Errorf("got %+v", got)
+				}
+			})
+		}
+	}
+}
+
+type testAddress struct {
+	City string `json:"city"`
+	Zip  string `json:"zip"`
+}
+
+type testProfile struct {
+	ID       int64                  `db:"id"`
+	Address  testAddress            `db:"address,json"`
+	Settings map[string]interface{} `db:"settings,json"`
+	Tags     []string               `db:"tags, json"`
+}
+
+func TestScanJSONColumns(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			res := rowsOf([]string{"id", "address", "settings", "tags"},
+				[]driver.Value{int64(1), []byte(`{"city":"Hanoi","zip":"100000"}`), []byte(`{"theme":"dark","size":12}`), `["a","b"]`},
+				[]driver.Value{int64(2), nil, nil, nil},
+			)
+			res.types = []string{"INT8", "JSONB", "JSONB", "JSON"}
+			f.on("FROM profiles", res)
+			items, err := QueryRowsStruct[testProfile](context.Background(), tx, "SELECT * FROM profiles")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(items) != 2 {
+				t.Fatalf("items = %+v", items)
+			}
+			p := items[0]
+			if p.Address != (testAddress{City: "Hanoi", Zip: "100000"}) {
+				t.Errorf("address = %+v", p.Address)
+			}
+			if p.Settings["theme"] != "dark" || p.Settings["size"] != float64(12) {
+				t.Errorf("settings = %v", p.Settings)
+			}
+			if !slices.Equal(p.Tags, []string{"a", "b"}) {
+				t.Errorf("tags = %v", p.Tags)
+			}
+			if empty := items[1]; empty.Address != (testAddress{}) || empty.Settings != nil || empty.Tags != nil {
+				t.Errorf("NULL columns decoded as %+v", empty)
+			}
+		})
+	}
+}
+
+func TestScanJSONColumnInvalid(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	f.on("FROM profiles", rowsOf([]string{"id", "address", "settings", "tags"},
+		[]driver.Value{int64(1), []byte(`{"city":`), nil, nil}))
+	if _, err := QueryRowsStruct[testProfile](context.Background(), r, "SELECT * FROM profiles"); err == nil {
+		t.Fatal("expected the JSON error of the address column")
+	}
+}
+
+type testTeam struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+type testMember struct {
+	ID   int64     `db:"id"`
+	Name string    `db:"name"`
+	Team *testTeam `db:"team"`
+}
+
+func TestQueryRowsStructPointers(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			f.on("LEFT JOIN teams", rowsOf([]string{"id", "name", "team.id", "team.name"},
+				[]driver.Value{int64(1), "ada", int64(10), "core"},
+				[]driver.Value{int64(2), "bob", nil, nil},
+				[]driver.Value{int64(3), "eve", int64(11), nil},
+			))
+			members, err := QueryRowsStruct[*testMember](context.Background(), tx,
+				"SELECT m.id, m.name, t.id, t.name FROM members m LEFT JOIN teams t ON t.id = m.team_id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(members) != 3 || members[0] == members[1] {
+				t.Fatalf("members = %v", members)
+			}
+			if m := members[0]; m.ID != 1 || m.Team == nil || *m.Team != (testTeam{ID: 10, Name: "core"}) {
+				t.Errorf("matched member = %+v, team %+v", m, m.Team)
+			}
+			if m := members[1]; m.Name != "bob" || m.Team != nil {
+				t.Errorf("unmatched member has team %+v", m.Team)
+			}
+			// A team with only some NULL columns is still there
+			if m := members[2]; m.Team == nil || *m.Team != (testTeam{ID: 11}) {
+				t.Errorf("partly NULL team = %+v", m.Team)
+			}
+		})
+	}
+}
+
+func TestQueryRowStructPointer(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	f.on("FROM members", rowsOf([]string{"id", "name", "team.id", "team.name"}, []driver.Value{int64(2), "bob", nil, nil}))
+	m, err := QueryRowStruct[*testMember](context.Background(), r, "SELECT * FROM members")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "bob" || m.Team != nil {
+		t.Errorf("member = %+v", m)
+	}
+}
+
+type testAccountExtra struct {
+	ID    int64                  `db:"id"`
+	Name  string                 `db:"name"`
+	Extra map[string]interface{} `db:",extra"`
+}
+
+func TestUnmappedColumnPolicies(t *testing.T) {
+	result := rowsOf([]string{"id", "name", "score", "rank"},
+		[]driver.Value{int64(1), "ada", int64(90), "gold"},
+		[]driver.Value{int64(2), "bob", nil, "silver"},
+	)
+	query := "SELECT id, name, score, rank FROM accounts"
+	t.Run("error", func(t *testing.T) {
+		f, r := newFakeSession(t, "postgres")
+		f.on("FROM accounts", result)
+		if _, err := QueryRowsStruct[testAccount](context.Background(), r, query); err == nil || !strings.Contains(err.Error(), "score") {
+			t.Errorf("err = %v, want it to name the unmapped column", err)
+		}
+	})
+	t.Run("ignore", func(t *testing.T) {
+		f, r := newFakeSession(t, "postgres", WithUnmappedColumnPolicy(UnmappedIgnore))
+		f.on("FROM accounts", result)
+		items, err := QueryRowsStruct[testAccount](context.Background(), r, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 2 || items[1] != (testAccount{ID: 2, Name: "bob"}) {
+			t.Errorf("items = %+v", items)
+		}
+	})
+	t.Run("collect", func(t *testing.T) {
+		f, r := newFakeSession(t, "postgres", WithUnmappedColumnPolicy(UnmappedCollectInto))
+		f.on("FROM accounts", result)
+		items, err := QueryRowsStruct[testAccountExtra](context.Background(), r, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 2 {
+			t.Fatalf("items = %+v", items)
+		}
+		if got := fmt.Sprint(items[0].Extra); got != "map[rank:gold score:90]" {
+			t.Errorf("first extra = %s", got)
+		}
+		if got := fmt.Sprint(items[1].Extra); got != "map[rank:silver score:<nil>]" {
+			t.Errorf("second extra = %s", got)
+		}
+		if _, err = QueryRowsStruct[testAccount](context.Background(), r, query); err == nil {
+			t.Error("collected into a struct without an extra field")
+		}
+	})
+}
+
+type testContact struct {
+	ID        int64           `db:"id"`
+	Email     sql.NullString  `db:"email"`
+	Phone     sql.NullString  `db:"phone"`
+	Age       sql.NullInt64   `db:"age"`
+	Verified  sql.NullTime    `db:"verified_at"`
+	Deleted   sql.NullTime    `db:"deleted_at"`
+	Score     sql.Null[int64] `db:"score"`
+	CreatedAt time.Time       `db:"created_at"`
+}
+
+func TestScanSQLNullTypes(t *testing.T) {
+	created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	verified := created.Add(time.Hour)
+	f, r := newFakeSession(t, "postgres")
+	f.on("FROM contacts", rowsOf([]string{"id", "email", "phone", "age", "verified_at", "deleted_at", "score", "created_at"},
+		[]driver.Value{int64(1), "ada@example.com", nil, int64(36), verified, nil, nil, created}))
+	c, err := QueryRowStruct[testContact](context.Background(), r, "SELECT * FROM contacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Email != (sql.NullString{String: "ada@example.com", Valid: true}) || c.Phone.Valid {
+		t.Errorf("email = %+v, phone = %+v", c.Email, c.Phone)
+	}
+	if c.Age != (sql.NullInt64{Int64: 36, Valid: true}) || c.Score.Valid {
+		t.Errorf("age = %+v, score = %+v", c.Age, c.Score)
+	}
+	if !c.Verified.Valid || !c.Verified.Time.Equal(verified) || c.Deleted.Valid {
+		t.Errorf("verified = %+v, deleted = %+v", c.Verified, c.Deleted)
+	}
+	if !c.CreatedAt.Equal(created) {
+		t.Errorf("created = %v", c.CreatedAt)
+	}
+}
+
+type testStatus string
+
+type testTicket struct {
+	ID     int64       `db:"id"`
+	Status testStatus  `db:"status"`
+	Prev   *testStatus `db:"prev"`
+}
+
+func TestScanEnumIntoStringType(t *testing.T) {
+	res := rowsOf([]string{"id", "status", "prev"},
+		[]driver.Value{int64(1), []byte("open"), nil},
+		[]driver.Value{int64(2), "closed", []byte("open")},
+	)
+	res.types = []string{"INT8", "TICKET_STATUS", "TICKET_STATUS"}
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			f.on("FROM tickets", res)
+			items, err := QueryRowsStruct[testTicket](context.Background(), tx, "SELECT * FROM tickets")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(items) != 2 || items[0].Status != "open" || items[0].Prev != nil || items[1].Status != "closed" {
+				t.Fatalf("items = %+v", items)
+			}
+			if items[1].Prev == nil || *items[1].Prev != "open" {
+				t.Errorf("prev = %v", items[1].Prev)
+			}
+		})
+	}
+	t.Run("converter returning bytes", func(t *testing.T) {
+		f, r := newFakeSession(t, "postgres", WithTypeConverter("ticket_status", func(b []byte) (interface{}, error) {
+			return bytes.ToUpper(b), nil
+		}))
+		f.on("FROM tickets", res)
+		items, err := QueryRowsStruct[testTicket](context.Background(), r, "SELECT * FROM tickets")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if items[0].Status != "OPEN" || items[1].Status != "CLOSED" || *items[1].Prev != "OPEN" {
+			t.Errorf("items = %+v, prev %v", items, *items[1].Prev)
+		}
+	})
+}
+
+type testJoinUser struct {
+	ID   int64  `db:"users.id"`
+	Name string `db:"name"`
+}
+
+type testJoinOrder struct {
+	ID    int64 `db:"orders.id"`
+	Total int64 `db:"total"`
+}
+
+type testUserOrder struct {
+	testJoinUser
+	testJoinOrder
+}
+
+func TestScanJoinIntoEmbeddedStructs(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			f.on("JOIN", rowsOf([]string{"id", "name", "id", "total"},
+				[]driver.Value{int64(1), "ada", int64(10), int64(250)},
+				[]driver.Value{int64(2), "grace", int64(11), int64(90)}))
+			items, err := QueryRowsStruct[testUserOrder](context.Background(), tx,
+				"SELECT u.id, u.name, o.id, o.total FROM users u JOIN orders o ON o.user_id = u.id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []testUserOrder{
+				{testJoinUser{1, "ada"}, testJoinOrder{10, 250}},
+				{testJoinUser{2, "grace"}, testJoinOrder{11, 90}},
+			}
+			if !slices.Equal(items, want) {
+				t.Errorf("items = %+v, want %+v", items, want)
+			}
+		})
+	}
+}
+
+func TestScanSingleColumnIntoPrefixedField(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	f.on("orders", rowsOf([]string{"id", "total"}, []driver.Value{int64(10), int64(250)}))
+	order, err := QueryRowStruct[testJoinOrder](context.Background(), r, "SELECT id, total FROM orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order != (testJoinOrder{10, 250}) {
+		t.Errorf("order = %+v", order)
+	}
+}
@@ -0,0 +1,255 @@
+package cransaction
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExecScript Run the SQL statements of script in a single transaction, any failure rolls back the whole script
+//
+// Statements are separated by ";", string literals, quoted identifiers and comments are respected, as well as
+// dollar-quoted bodies on Postgres and DELIMITER changes on MySQL. MySQL commits implicitly before and after
+// DDL statements such as CREATE TABLE, the statements of a script that ran until then are not rolled back
+func (r *RDMSSession) ExecScript(ctx context.Context, script io.Reader) error {
+	return execScript(ctx, r, script)
+}
+
+// ExecScript Run the SQL statements of script in a single transaction, any failure rolls back the whole script
+//
+// Statements are separated by ";", string literals, quoted identifiers and comments are respected, as well as
+// dollar-quoted bodies on Postgres and DELIMITER changes on MySQL. MySQL commits implicitly before and after
+// DDL statements such as CREATE TABLE, the statements of a script that ran until then are not rolled back
+func (g *GormSession) ExecScript(ctx context.Context, script io.Reader) error {
+	return execScript(ctx, g, script)
+}
+
+func execScript(ctx context.Context, tx ITransaction, script io.Reader) error {
+	b, err := io.ReadAll(script)
+	if err != nil {
+		return err
+	}
+	statements, err := splitStatements(string(b), dialectOf(tx).dialect())
+	if err != nil {
+		return err
+	}
+	return inTransaction(ctx, tx, func(ctx context.Context) error {
+		for i, stmt := range statements {
+			if _, err := tx.ExecQuery(ctx, stmt); err != nil {
+				return fmt.Errorf("cransaction: script statement %d: %w", i+1, err)
+			}
+		}
+		return nil
+	})
+}
+
+// scriptSplitter Split a SQL script into statements, scanning it once byte by byte
+type scriptSplitter struct {
+	script    string
+	postgres  bool
+	mysql     bool
+	delimiter string
+	pos       int
+	current   strings.Builder
+	// hasCode Whether the current statement holds anything besides whitespace and comments
+	hasCode    bool
+	statements []string
+}
+
+func splitStatements(script, dialect string) ([]string, error) {
+	s := &scriptSplitter{
+		script:    script,
+		postgres:  dialect == "postgres",
+		mysql:     dialect == "mysql",
+		delimiter: ";",
+	}
+	if err := s.split(); err != nil {
+		return nil, err
+	}
+	return s.statements, nil
+}
+
+func (s *scriptSplitter) split() error {
+	lineStart := true
+	for s.pos < len(s.script) {
+		if lineStart && s.mysql && s.delimiterCommand() {
+			continue
+		}
+		lineStart = false
+		c := s.script[s.pos]
+		rest := s.script[s.pos:]
+		switch {
+		case strings.HasPrefix(rest, s.delimiter):
+			s.flush()
+			s.pos += len(s.delimiter)
+		case c == '\n':
+			lineStart = true
+			s.current.WriteByte(c)
+			s.pos++
+		case strings.HasPrefix(rest, "--") || (s.mysql && c == '#'):
+			s.skipLine()
+		case strings.HasPrefix(rest, "/*"):
+			if err := s.blockComment(); err != nil {
+				return err
+			}
+		case c == '\'':
+			backslash := s.mysql || (s.postgres && s.escapeStringPrefix())
+			if err := s.quoted(c, backslash); err != nil {
+				return err
+			}
+		case c == '"' || (s.mysql && c == '`'):
+			if err := s.quoted(c, s.mysql && c == '"'); err != nil {
+				return err
+			}
+		case c == '$' && s.postgres && s.dollarTag() != "":
+			if err := s.dollarQuoted(s.dollarTag()); err != nil {
+				return err
+			}
+		default:
+			if !isSpace(c) {
+				s.hasCode = true
+			}
+			s.current.WriteByte(c)
+			s.pos++
+		}
+	}
+	s.flush()
+	return nil
+}
+
+// delimiterCommand Handle a MySQL client DELIMITER line at the current position, reports whether there was one
+func (s *scriptSplitter) delimiterCommand() bool {
+	i := s.pos
+	for i < len(s.script) && (s.script[i] == ' ' || s.script[i] == '\t') {
+		i++
+	}
+	const keyword = "DELIMITER"
+	if len(s.script)-i <= len(keyword) || !strings.EqualFold(s.script[i:i+len(keyword)], keyword) || !isSpace(s.script[i+len(keyword)]) {
+		return false
+	}
+	end := strings.IndexByte(s.script[i:], '\n')
+	if end < 0 {
+		end = len(s.script) - i
+	}
+	delimiter := strings.TrimSpace(s.script[i+len(keyword) : i+end])
+	if delimiter == "" {
+		return false
+	}
+	s.flush()
+	s.delimiter = delimiter
+	s.pos = i + end
+	return true
+}
+
+func (s *scriptSplitter) skipLine() {
+	end := strings.IndexByte(s.script[s.pos:], '\n')
+	if end < 0 {
+		s.pos = len(s.script)
+		return
+	}
+	s.pos += end
+}
+
+// blockComment Copy a block comment, kept because MySQL reads optimizer hints and /*! */ code from them
+func (s *scriptSplitter) blockComment() error {
+	depth := 0
+	for i := s.pos; i+1 < len(s.script); i++ {
+		switch s.script[i : i+2] {
+		case "/*":
+			depth++
+			i++
+		case "*/":
+			depth--
+			i++
+			// Only Postgres nests block comments
+			if depth == 0 || !s.postgres {
+				if strings.HasPrefix(s.script[s.pos:], "/*!") || strings.HasPrefix(s.script[s.pos:], "/*+") {
+					s.hasCode = true
+				}
+				s.current.WriteString(s.script[s.pos : i+1])
+				s.pos = i + 1
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("cransaction: unterminated block comment at offset %d", s.pos)
+}
+
+// quoted Copy a literal or identifier quoted with q, a doubled quote is an escaped one
+func (s *scriptSplitter) quoted(q byte, backslash bool) error {
+	for i := s.pos + 1; i < len(s.script); i++ {
+		switch s.script[i] {
+		case '\\':
+			if backslash {
+				i++
+			}
+		case q:
+			if i+1 < len(s.script) && s.script[i+1] == q {
+				i++
+				continue
+			}
+			s.current.WriteString(s.script[s.pos : i+1])
+			s.hasCode = true
+			s.pos = i + 1
+			return nil
+		}
+	}
+	return fmt.Errorf("cransaction: unterminated %c quote at offset %d", q, s.pos)
+}
+
+// escapeStringPrefix Whether the quote at the current position opens a Postgres E'...' string with backslash escapes
+func (s *scriptSplitter) escapeStringPrefix() bool {
+	if s.pos == 0 || (s.script[s.pos-1] != 'E' && s.script[s.pos-1] != 'e') {
+		return false
+	}
+	return s.pos == 1 || !isIdentByte(s.script[s.pos-2])
+}
+
+// dollarTag Opening $tag$ of a Postgres dollar-quoted string at the current position, empty when there is none
+//
+// Positional parameters such as $1 and identifiers containing $ are not dollar quotes
+func (s *scriptSplitter) dollarTag() string {
+	if s.pos > 0 && isIdentByte(s.script[s.pos-1]) {
+		return ""
+	}
+	i := s.pos + 1
+	if i < len(s.script) && s.script[i] >= '0' && s.script[i] <= '9' {
+		return ""
+	}
+	for i < len(s.script) && s.script[i] != '$' && isIdentByte(s.script[i]) {
+		i++
+	}
+	if i >= len(s.script) || s.script[i] != '$' {
+		return ""
+	}
+	return s.script[s.pos : i+1]
+}
+
+func (s *scriptSplitter) dollarQuoted(tag string) error {
+	end := strings.Index(s.script[s.pos+len(tag):], tag)
+	if end < 0 {
+		return fmt.Errorf("cransaction: unterminated dollar-quoted string %s at offset %d", tag, s.pos)
+	}
+	end += s.pos + 2*len(tag)
+	s.current.WriteString(s.script[s.pos:end])
+	s.hasCode = true
+	s.pos = end
+	return nil
+}
+
+func (s *scriptSplitter) flush() {
+	if s.hasCode {
+		s.statements = append(s.statements, strings.TrimSpace(s.current.String()))
+	}
+	s.current.Reset()
+	s.hasCode = false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
@@ -0,0 +1,128 @@
+package cransaction
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		name    string
+		dialect string
+		script  string
+		want    []string
+	}{
+		{
+			name:    "plain",
+			dialect: "postgres",
+			script:  "CREATE TABLE a (id int);\n\nINSERT INTO a VALUES (1);\n-- trailing comment\n",
+			want:    []string{"CREATE TABLE a (id int)", "INSERT INTO a VALUES (1)"},
+		},
+		{
+			name:    "literals",
+			dialect: "postgres",
+			script:  `INSERT INTO notes VALUES ('a;b', 'it''s'); SELECT "odd;name" FROM t; SELECT E'\';x'`,
+			want:    []string{`INSERT INTO notes VALUES ('a;b', 'it''s')`, `SELECT "odd;name" FROM t`, `SELECT E'\';x'`},
+		},
+		{
+			name:    "comments",
+			dialect: "postgres",
+			script:  "SELECT 1; -- not; a statement\n/* outer /* nested; */ still; */ SELECT 2;",
+			want:    []string{"SELECT 1", "/* outer /* nested; */ still; */ SELECT 2"},
+		},
+		{
+			name:    "dollar-quoted function",
+			dialect: "postgres",
+			script: `CREATE FUNCTION touch() RETURNS trigger AS $body$
+BEGIN
+  NEW.updated_at := now();
+  RETURN NEW;
+END;
+$body$ LANGUAGE plpgsql;
+DO $$ BEGIN PERFORM 1; END $$;`,
+			want: []string{`CREATE FUNCTION touch() RETURNS trigger AS $body$
+BEGIN
+  NEW.updated_at := now();
+  RETURN NEW;
+END;
+$body$ LANGUAGE plpgsql`, "DO $$ BEGIN PERFORM 1; END $$"},
+		},
+		{
+			name:    "dollar signs that are not quotes",
+			dialect: "postgres",
+			script:  "SELECT price $ 2; SELECT $1::int; SELECT a$b FROM t; SELECT 3",
+			want:    []string{"SELECT price $ 2", "SELECT $1::int", "SELECT a$b FROM t", "SELECT 3"},
+		},
+		{
+			name:    "mysql delimiter",
+			dialect: "mysql",
+			script: "CREATE TABLE t (id int);\nDELIMITER //\nCREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END//\nDELIMITER ;\n" +
+				"# hash comment; here\nINSERT INTO t VALUES (1);",
+			want: []string{"CREATE TABLE t (id int)", "CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END", "INSERT INTO t VALUES (1)"},
+		},
+		{
+			name:    "mysql backslash and backquote",
+			dialect: "mysql",
+			script:  "INSERT INTO `se;mi` VALUES ('\\';', \"x;\"); SELECT 1",
+			want:    []string{"INSERT INTO `se;mi` VALUES ('\\';', \"x;\")", "SELECT 1"},
+		},
+		{
+			name:    "mysql optimizer hint",
+			dialect: "mysql",
+			script:  "/*!40101 SET NAMES utf8 */; /* only a comment */;",
+			want:    []string{"/*!40101 SET NAMES utf8 */"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := splitStatements(c.script, c.dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, c.want) {
+				t.Errorf("statements:\n%q\nwant:\n%q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSplitStatementsUnterminated(t *testing.T) {
+	for _, script := range []string{"SELECT 'open", "SELECT 1 /* open", "DO $x$ BEGIN END"} {
+		if _, err := splitStatements(script, "postgres"); err == nil {
+			t.Errorf("%q split without error", script)
+		}
+	}
+}
+
+// scriptSession Session running scripts, both backends are
+type scriptSession interface {
+	ExecScript(ctx context.Context, script io.Reader) error
+}
+
+func TestExecScript(t *testing.T) {
+	script := "CREATE TABLE a (id int);\nINSERT INTO a VALUES (1);\nINSERT INTO a VALUES (2);"
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			s := tx.(scriptSession)
+			if err := s.ExecScript(context.Background(), strings.NewReader(script)); err != nil {
+				t.Fatal(err)
+			}
+			wantStatements(t, f, "BEGIN", "CREATE TABLE a (id int)", "INSERT INTO a VALUES (1)", "INSERT INTO a VALUES (2)", "COMMIT")
+		})
+		t.Run(backend+"/rollback", func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			f.fail("VALUES (2)", errFake)
+			s := tx.(scriptSession)
+			err := s.ExecScript(context.Background(), strings.NewReader(script))
+			if !errors.Is(err, errFake) || !strings.Contains(err.Error(), "statement 3") {
+				t.Fatalf("err = %v", err)
+			}
+			wantStatements(t, f, "BEGIN", "CREATE TABLE a (id int)", "INSERT INTO a VALUES (1)", "INSERT INTO a VALUES (2)", "ROLLBACK")
+		})
+	}
+}
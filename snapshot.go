@@ -0,0 +1,57 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var errSnapshotInTransaction = errors.New("cransaction: WithSnapshot cannot run inside a transaction of the session")
+
+// WithSnapshot Run fn in a read-only REPEATABLE READ transaction, so all its reads see the database as of the
+// same point in time whatever commits in between
+//
+// The snapshot is taken by the first statement of fn, on MySQL as on Postgres, and ExportSnapshot shares it
+// with other Postgres sessions. On MySQL the transaction is begun by the driver with a plain START TRANSACTION,
+// not START TRANSACTION WITH CONSISTENT SNAPSHOT, so commits made before the first read of fn are visible. ctx
+// must not carry a transaction of the session already, its isolation level can no longer change.
+// The Timeout of WithTxDefaults still applies
+func (r *RDMSSession) WithSnapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withSnapshot(ctx, r, r.db, fn)
+}
+
+// WithSnapshot Run fn in a read-only REPEATABLE READ transaction, so all its reads see the database as of the
+// same point in time whatever commits in between
+//
+// The snapshot is taken by the first statement of fn, on MySQL as on Postgres, and ExportSnapshot shares it
+// with other Postgres sessions. On MySQL the transaction is begun by the driver with a plain START TRANSACTION,
+// not START TRANSACTION WITH CONSISTENT SNAPSHOT, so commits made before the first read of fn are visible. ctx
+// must not carry a transaction of the session already, its isolation level can no longer change.
+// The Timeout of WithTxDefaults still applies
+func (g *GormSession) WithSnapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withSnapshot(ctx, g, g.db, fn)
+}
+
+func withSnapshot(ctx context.Context, tx ITransaction, owner interface{}, fn func(ctx context.Context) error) error {
+	if stateFor(ctx, owner) != nil {
+		return errSnapshotInTransaction
+	}
+	prev, _ := ctx.Value(txDefaultsKey{}).(TxDefaults)
+	d := TxDefaults{Isolation: sql.LevelRepeatableRead, ReadOnly: true, Timeout: prev.Timeout}
+	return tx.Transaction(WithTxDefaults(ctx, d), func(ctx context.Context) error {
+		// Transactions of other sessions begun with the context of fn keep the defaults of the caller
+		return fn(WithTxDefaults(ctx, prev))
+	})
+}
+
+// ExportSnapshot Export the snapshot of the Postgres transaction of tx in ctx with pg_export_snapshot, other
+// sessions adopt it with SET TRANSACTION SNAPSHOT while the transaction stays open
+func ExportSnapshot(ctx context.Context, tx ITransaction) (string, error) {
+	if dialectOf(tx).dialect() != "postgres" {
+		return "", errors.New("cransaction: ExportSnapshot requires a Postgres session")
+	}
+	if sessionState(ctx, tx) == nil {
+		return "", ErrNoActiveTransaction
+	}
+	return QueryValue[string](ctx, tx, "SELECT pg_export_snapshot()")
+}
@@ -0,0 +1,130 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// snapshotSession Session offering WithSnapshot, both backends do
+type snapshotSession interface {
+	ITransaction
+	WithSnapshot(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// snapshotBalance Emulate a balance read under REPEATABLE READ: reads of a transaction see the value committed
+// when it first read, UPDATE statements outside it commit a new one
+func snapshotBalance(f *fakeDB, balance int64) {
+	var mu sync.Mutex
+	var snapshot *int64
+	f.handle("SELECT balance", func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		value := balance
+		if f.last("SELECT balance").inTx {
+			if snapshot == nil {
+				snapshot = &value
+			}
+			value = *snapshot
+		}
+		return rowsOf([]string{"balance"}, []driver.Value{value}), nil
+	})
+	f.handle("UPDATE", func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		balance += 50
+		return &fakeResult{affected: 1}, nil
+	})
+	end := func(context.Context, []driver.NamedValue) (*fakeResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		snapshot = nil
+		return nil, nil
+	}
+	f.handle("COMMIT", end)
+	f.handle("ROLLBACK", end)
+}
+
+func TestWithSnapshotConsistentReads(t *testing.T) {
+	for name, open := range testSessions {
+		for _, dialect := range []string{"postgres", "mysql"} {
+			t.Run(name+"/"+dialect, func(t *testing.T) {
+				f, tx := open(t, dialect)
+				snapshotBalance(f, 100)
+				s := tx.(snapshotSession)
+				// The writer is another session on the same pool
+				var pool *sql.DB
+				if r, ok := tx.(*RDMSSession); ok {
+					pool = r.db
+				} else if pool, _ = tx.(*GormSession).db.DB(); pool == nil {
+					t.Fatal("no pool behind the Gorm session")
+				}
+				writer := NewSession(dialect, pool, nil, context.Background())
+				var first, second int64
+				err := s.WithSnapshot(context.Background(), func(ctx context.Context) error {
+					var err error
+					if first, err = QueryValue[int64](ctx, s, "SELECT balance FROM accounts WHERE id = 1"); err != nil {
+						return err
+					}
+					// A writer outside the snapshot commits in between
+					if _, err = writer.ExecQuery(context.Background(), "UPDATE accounts SET balance = balance + 50 WHERE id = 1"); err != nil {
+						return err
+					}
+					second, err = QueryValue[int64](ctx, s, "SELECT balance FROM accounts WHERE id = 1")
+					return err
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if first != 100 || second != 100 {
+					t.Errorf("snapshot read %d then %d, want 100 twice", first, second)
+				}
+				if now, err := QueryValue[int64](context.Background(), s, "SELECT balance FROM accounts WHERE id = 1"); err != nil || now != 150 {
+					t.Errorf("after the snapshot = %d, %v, want the committed 150", now, err)
+				}
+				if len(f.txOpts) != 1 || !f.txOpts[0].ReadOnly || sql.IsolationLevel(f.txOpts[0].Isolation) != sql.LevelRepeatableRead {
+					t.Errorf("began with %+v, want read-only repeatable read", f.txOpts)
+				}
+			})
+		}
+	}
+}
+
+func TestExportSnapshot(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	f.on("pg_export_snapshot", rowsOf([]string{"pg_export_snapshot"}, []driver.Value{"00000003-0000001B-1"}))
+	_, other := newFakeSession(t, "postgres")
+	err := r.WithSnapshot(context.Background(), func(ctx context.Context) error {
+		id, err := ExportSnapshot(ctx, r)
+		if err != nil || id != "00000003-0000001B-1" {
+			t.Errorf("ExportSnapshot = %q, %v", id, err)
+		}
+		if _, err = ExportSnapshot(ctx, other); !errors.Is(err, ErrNoActiveTransaction) {
+			t.Errorf("ExportSnapshot of another session = %v, want ErrNoActiveTransaction", err)
+		}
+		if err = r.WithSnapshot(ctx, func(context.Context) error { return nil }); !errors.Is(err, errSnapshotInTransaction) {
+			t.Errorf("nested WithSnapshot = %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.last("pg_export_snapshot").inTx {
+		t.Error("snapshot exported outside the transaction")
+	}
+	if _, err = ExportSnapshot(context.Background(), r); !errors.Is(err, ErrNoActiveTransaction) {
+		t.Errorf("ExportSnapshot outside a transaction = %v", err)
+	}
+	_, m := newFakeSession(t, "mysql")
+	err = m.WithSnapshot(context.Background(), func(ctx context.Context) error {
+		_, err := ExportSnapshot(ctx, m)
+		return err
+	})
+	if err == nil {
+		t.Error("ExportSnapshot on MySQL succeeded")
+	}
+}
@@ -0,0 +1,157 @@
+package cransaction
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkIdentifier Reject names that cannot be interpolated into SQL as a bare identifier
+func checkIdentifier(name string) error {
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("cransaction: invalid identifier %q", name)
+	}
+	return nil
+}
+
+// checkTable Validate a table name, optionally qualified by a schema
+func checkTable(name string) error {
+	for _, part := range strings.Split(name, ".") {
+		if err := checkIdentifier(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quoteLiteral Quote s as a standard SQL string literal
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// dialecter Session able to describe the SQL it accepts
+type dialecter interface {
+	// dialect Database flavor, such as postgres or mysql
+	dialect() string
+	// bindVar Placeholder for the n-th argument, starting at 1
+	bindVar(n int) string
+}
+
+func (r *RDMSSession) dialect() string {
+	return r.driver
+}
+
+func (r *RDMSSession) bindVar(n int) string {
+	if r.opts.placeholderStyle != 0 {
+		return r.opts.placeholderStyle.placeholder(n)
+	}
+	if r.driver == "postgres" {
+		return Dollar.placeholder(n)
+	}
+	return Question.placeholder(n)
+}
+
+func (g *GormSession) dialect() string {
+	return g.db.Dialector.Name()
+}
+
+// bindVar Gorm rewrites ? into the placeholder of its dialect, WithPlaceholderStyle does not apply: Gorm reads
+// @name as a named arg and leaves $n and :n untouched
+func (g *GormSession) bindVar(n int) string {
+	return Question.placeholder(n)
+}
+
+func (m *multiSession) dialect() string {
+	return dialectOf(m.primary).dialect()
+}
+
+func (m *multiSession) bindVar(n int) string {
+	return dialectOf(m.primary).bindVar(n)
+}
+
+func (a *autocommitSession) dialect() string {
+	return dialectOf(a.ITransaction).dialect()
+}
+
+func (a *autocommitSession) bindVar(n int) string {
+	return dialectOf(a.ITransaction).bindVar(n)
+}
+
+func (s *replicaSession) dialect() string {
+	return dialectOf(s.primary).dialect()
+}
+
+func (s *replicaSession) bindVar(n int) string {
+	return dialectOf(s.primary).bindVar(n)
+}
+
+// PlaceholderStyle Syntax of the bind variables in the SQL generated by the helpers
+type PlaceholderStyle int
+
+const (
+	// Question ? for every argument, as on MySQL and SQLite
+	Question PlaceholderStyle = iota + 1
+	// Dollar $1, $2, ... as on Postgres
+	Dollar
+	// At @p1, @p2, ... as on SQL Server
+	At
+	// Colon :1, :2, ... as on Oracle
+	Colon
+)
+
+func (s PlaceholderStyle) placeholder(n int) string {
+	switch s {
+	case Dollar:
+		return "$" + strconv.Itoa(n)
+	case At:
+		return "@p" + strconv.Itoa(n)
+	case Colon:
+		return ":" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// WithPlaceholderStyle Generate bind variables in style in the SQL building helpers whatever the driver is
+//
+// By default the style is inferred from the driver, Dollar for postgres and Question otherwise. Gorm sessions
+// ignore it and always use ?, which Gorm rewrites for its dialect
+func WithPlaceholderStyle(style PlaceholderStyle) Option {
+	return func(o *options) {
+		o.placeholderStyle = style
+	}
+}
+
+// genericDialect Fallback for sessions that do not implement dialecter
+type genericDialect struct{}
+
+func (genericDialect) dialect() string {
+	return ""
+}
+
+func (genericDialect) bindVar(n int) string {
+	return "?"
+}
+
+func dialectOf(tx ITransaction) dialecter {
+	if d, ok := tx.(dialecter); ok {
+		return d
+	}
+	return genericDialect{}
+}
+
+// rowsAffected Affected row count of an ExecQuery result from either backend
+func rowsAffected(result interface{}) (int64, error) {
+	switch r := result.(type) {
+	case sql.Result:
+		return r.RowsAffected()
+	case *gorm.DB:
+		return r.RowsAffected, r.Error
+	}
+	return 0, fmt.Errorf("cransaction: unexpected exec result type %T", result)
+}
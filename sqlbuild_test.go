@@ -0,0 +1,56 @@
+package cransaction
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPlaceholderStyles(t *testing.T) {
+	cases := []struct {
+		style  PlaceholderStyle
+		insert string
+		page   string
+	}{
+		{Question, "INSERT INTO pairs (a, b) VALUES (?, ?), (?, ?)", "WHERE id > ? ORDER BY id LIMIT ?"},
+		{Dollar, "INSERT INTO pairs (a, b) VALUES ($1, $2), ($3, $4)", "WHERE id > $1 ORDER BY id LIMIT $2"},
+		{At, "INSERT INTO pairs (a, b) VALUES (@p1, @p2), (@p3, @p4)", "WHERE id > @p1 ORDER BY id LIMIT @p2"},
+		{Colon, "INSERT INTO pairs (a, b) VALUES (:1, :2), (:3, :4)", "WHERE id > :1 ORDER BY id LIMIT :2"},
+	}
+	for _, c := range cases {
+		t.Run(c.style.placeholder(1), func(t *testing.T) {
+			// The driver name of a custom driver says nothing of its placeholders
+			f, r := newFakeSession(t, "mysql", WithPlaceholderStyle(c.style))
+			ctx := context.Background()
+			if _, err := BulkInsert(ctx, r, "pairs", []string{"a", "b"}, [][]interface{}{{1, 2}, {3, 4}}, BulkOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := Paginate[testPagedUser](ctx, r, "SELECT id, name FROM users", "id", int64(1), 2); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.last("INSERT INTO pairs").query; got != c.insert {
+				t.Errorf("insert = %q, want %q", got, c.insert)
+			}
+			if got := f.last("FROM users").query; !strings.HasSuffix(got, c.page) {
+				t.Errorf("page = %q, want suffix %q", got, c.page)
+			}
+		})
+	}
+}
+
+func TestPlaceholderStyleInferred(t *testing.T) {
+	for dialect, want := range map[string]string{"postgres": "$1", "mysql": "?"} {
+		_, r := newFakeSession(t, dialect)
+		if got := r.bindVar(1); got != want {
+			t.Errorf("%s: bindVar(1) = %q, want %q", dialect, got, want)
+		}
+	}
+	// Gorm rewrites ? itself, a style on a Gorm session would reach Gorm as a literal
+	f, g := newFakeGorm(t, "postgres", WithPlaceholderStyle(Dollar))
+	if _, err := BulkInsert(context.Background(), g, "pairs", []string{"a"}, [][]interface{}{{1}, {2}}, BulkOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.last("INSERT INTO pairs").query; got != "INSERT INTO pairs (a) VALUES (?), (?)" {
+		t.Errorf("gorm insert = %q", got)
+	}
+}
@@ -0,0 +1,57 @@
+package cransaction
+
+import (
+	"context"
+	"fmt"
+)
+
+// CreateTempTable Create a temporary table in the active transaction, ddl is the column definition list
+//
+// The table is dropped when the transaction ends: through ON COMMIT DROP on Postgres and an explicit
+// DROP TEMPORARY TABLE on MySQL
+func (r *RDMSSession) CreateTempTable(ctx context.Context, name string, ddl string) error {
+	tx, ok := r.txFrom(ctx)
+	if !ok {
+		return ErrNoActiveTransaction
+	}
+	return createTempTable(ctx, r.driver, name, ddl, stateFor(ctx, r.db), func(ctx context.Context, query string) error {
+		_, err := tx.ExecContext(ctx, query)
+		return err
+	})
+}
+
+// CreateTempTable Create a temporary table in the active transaction, ddl is the column definition list
+//
+// The table is dropped when the transaction ends: through ON COMMIT DROP on Postgres and an explicit
+// DROP TEMPORARY TABLE on MySQL
+func (g *GormSession) CreateTempTable(ctx context.Context, name string, ddl string) error {
+	tx, ok := g.txFrom(ctx)
+	if !ok {
+		return ErrNoActiveTransaction
+	}
+	return createTempTable(ctx, g.dialect(), name, ddl, stateFor(ctx, g.db), func(ctx context.Context, query string) error {
+		return tx.WithContext(ctx).Exec(query).Error
+	})
+}
+
+func createTempTable(ctx context.Context, driver, name, ddl string, st *txState, exec func(ctx context.Context, query string) error) error {
+	if err := checkIdentifier(name); err != nil {
+		return err
+	}
+	switch driver {
+	case "postgres":
+		return exec(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (%s) ON COMMIT DROP", name, ddl))
+	case "mysql":
+		if err := exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE %s (%s)", name, ddl)); err != nil {
+			return err
+		}
+		// MySQL keeps temporary tables for the life of the connection, which goes back to the pool
+		st.beforeEnd = append(st.beforeEnd, func() {
+			ctx, cancel := cleanupContext(ctx)
+			defer cancel()
+			_ = exec(ctx, fmt.Sprintf("DROP TEMPORARY TABLE IF EXISTS %s", name))
+		})
+		return nil
+	}
+	return fmt.Errorf("cransaction: temporary tables are not supported for driver %s", driver)
+}
@@ -0,0 +1,88 @@
+package cransaction
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCreateTempTable(t *testing.T) {
+	ctx := context.Background()
+	t.Run("postgres", func(t *testing.T) {
+		f, r := newFakeSession(t, "postgres")
+		err := r.Transaction(ctx, func(ctx context.Context) error {
+			if err := r.CreateTempTable(ctx, "report", "id bigint"); err != nil {
+				return err
+			}
+			_, err := r.ExecQuery(ctx, "INSERT INTO report SELECT id FROM accounts")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatements(t, f,
+			"BEGIN",
+			"CREATE TEMP TABLE report (id bigint) ON COMMIT DROP",
+			"INSERT INTO report SELECT id FROM accounts",
+			"COMMIT")
+	})
+	t.Run("mysql", func(t *testing.T) {
+		f, r := newFakeSession(t, "mysql")
+		err := r.Transaction(ctx, func(ctx context.Context) error {
+			if err := r.CreateTempTable(ctx, "report", "id bigint"); err != nil {
+				return err
+			}
+			_, err := r.ExecQuery(ctx, "INSERT INTO report SELECT id FROM accounts")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatements(t, f,
+			"BEGIN",
+			"CREATE TEMPORARY TABLE report (id bigint)",
+			"INSERT INTO report SELECT id FROM accounts",
+			"DROP TEMPORARY TABLE IF EXISTS report",
+			"COMMIT")
+	})
+}
+
+func TestCreateTempTableRejects(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	ctx := context.Background()
+	if err := r.CreateTempTable(ctx, "report", "id bigint"); !errors.Is(err, ErrNoActiveTransaction) {
+		t.Errorf("outside a transaction err = %v", err)
+	}
+	err := r.Transaction(ctx, func(ctx context.Context) error {
+		return r.CreateTempTable(ctx, "report; DROP TABLE accounts", "id bigint")
+	})
+	if err == nil {
+		t.Error("unsafe table name accepted")
+	}
+	if n := f.count("CREATE"); n != 0 {
+		t.Errorf("%d CREATE statements sent", n)
+	}
+}
+
+func TestTempTableDroppedAfterCancel(t *testing.T) {
+	f, r := newFakeSession(t, "mysql")
+	err := r.Transaction(context.Background(), func(ctx context.Context) error {
+		step, cancel := context.WithCancel(ctx)
+		err := r.CreateTempTable(step, "report", "id bigint")
+		cancel()
+		if err != nil {
+			return err
+		}
+		_, err = r.ExecQuery(ctx, "INSERT INTO report SELECT id FROM accounts")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, f,
+		"BEGIN",
+		"CREATE TEMPORARY TABLE report (id bigint)",
+		"INSERT INTO report SELECT id FROM accounts",
+		"DROP TEMPORARY TABLE IF EXISTS report",
+		"COMMIT")
+}
@@ -3,15 +3,259 @@ package cransaction
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"gorm.io/gorm"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
-var supportedSQLDrivers = []string{"postgres", "mysql"}
+var (
+	driversMu           sync.RWMutex
+	supportedSQLDrivers = []string{"postgres", "mysql"}
+)
 
 type dbKey struct{}
 
+type txNameKey struct{}
+
+// txState Active transaction stored in the context under dbKey
+type txState struct {
+	// owner Base handle the transaction was started on, parent the transaction that was active in the context
+	owner  interface{}
+	parent *txState
+	sqlTx  *sql.Tx
+	gormTx *gorm.DB
+	ender  txEnder
+	name   string
+	start  time.Time
+	// beforeEnd Cleanups run inside the transaction right before it commits or rolls back
+	beforeEnd []func()
+	// continueOnError ExecQuery statements run in their own savepoint and failures are collected in skipped
+	continueOnError bool
+	skipped         []error
+	openRows        []trackedRows
+	afterCommit     []func(ctx context.Context) error
+	// preCommit Checks run inside the transaction once fn succeeded, the first error rolls it back
+	preCommit []func() error
+	// failed A statement of the transaction failed, Postgres rejects everything after that until rollback
+	failed bool
+	// depth Number of transactions and savepoints active in the context, this one included
+	depth int
+	// outer Transaction of the same session a savepoint was taken in, nil for a database transaction
+	outer *txState
+	// lastQuery Most recent statement run in the transaction, named in the error of a failed transaction
+	lastQuery string
+	// clock Clock of the session that began the transaction, start was read from it
+	clock Clock
+	// panicked fn panicked, the transaction is being rolled back before the panic resumes
+	panicked bool
+	// stats Statements run so far, reported in the Summary of the end event
+	stats TxSummary
+	// stopExpiry Stop the timer of WithMaxTransactionDuration, false once it fired, expired is set once it
+	// rolled the transaction back
+	stopExpiry func() bool
+	expired    atomic.Bool
+	// expiryDone Closed once the timer of WithMaxTransactionDuration rolled the transaction back
+	expiryDone chan struct{}
+	// deadline Time the timer fires at on the session clock, zero without WithMaxTransactionDuration
+	deadline time.Time
+}
+
+// root Database transaction st runs in, st itself unless it is a savepoint
+func (st *txState) root() *txState {
+	for st.outer != nil {
+		st = st.outer
+	}
+	return st
+}
+
+// aborted Report whether a statement failed in st or in a transaction it runs in, see failed
+func (st *txState) aborted() bool {
+	for ; st != nil; st = st.outer {
+		if st.failed {
+			return true
+		}
+	}
+	return false
+}
+
+// abortedBy Report whether the failure err of a statement aborts the transaction, a statement rolled back to its
+// savepoint by TransactionContinueOnError does not
+func (st *txState) abortedBy(err error) bool {
+	return err != nil && (len(st.skipped) == 0 || !errors.Is(st.skipped[len(st.skipped)-1], err))
+}
+
+// maxErrorQueryLen Length past which the query named in a transaction error is truncated
+const maxErrorQueryLen = 200
+
+// failedAfter Wrap the error that rolled back the transaction with the last statement it ran, args are left out
+// as they may carry personal data
+func (st *txState) failedAfter(err error) error {
+	if st.lastQuery == "" {
+		return err
+	}
+	query := st.lastQuery
+	if len(query) > maxErrorQueryLen {
+		cut := maxErrorQueryLen
+		for cut > 0 && !utf8.RuneStart(query[cut]) {
+			cut--
+		}
+		query = query[:cut] + "..."
+	}
+	return fmt.Errorf("cransaction: transaction failed after query %q: %w", query, err)
+}
+
+// skipOnError Run exec inside a savepoint, a failing statement is rolled back to the savepoint and recorded
+func (st *txState) skipOnError(ctx context.Context, savepoint func(ctx context.Context, stmt string) error, exec func() error) error {
+	if err := savepoint(ctx, "SAVEPOINT cransaction_stmt"); err != nil {
+		return err
+	}
+	err := exec()
+	ctx, cancel := cleanupContext(ctx)
+	defer cancel()
+	if err != nil {
+		if rbErr := savepoint(ctx, "ROLLBACK TO SAVEPOINT cransaction_stmt"); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		st.skipped = append(st.skipped, err)
+		return err
+	}
+	return savepoint(ctx, "RELEASE SAVEPOINT cransaction_stmt")
+}
+
+// cleanupTimeout Bound on a statement undoing or closing work, see cleanupContext
+const cleanupTimeout = 5 * time.Second
+
+// cleanupContext Context for a statement ending work begun under ctx, such as ROLLBACK TO SAVEPOINT
+//
+// It keeps the values of ctx but not its cancellation: the statement still runs when ctx is done, which is
+// when it matters most, instead of leaving the savepoint behind in a transaction that carries on
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+}
+
+// savepoint Nested transaction of a session that already has one in the context
+type savepoint struct {
+	name string
+	// ctx Context the savepoint was taken with, its release and rollback run under cleanupContext of it
+	ctx  context.Context
+	exec func(ctx context.Context, stmt string) error
+}
+
+// Commit Release the savepoint, its changes become part of the enclosing transaction
+func (s savepoint) Commit() error {
+	ctx, cancel := cleanupContext(s.ctx)
+	defer cancel()
+	return s.exec(ctx, "RELEASE SAVEPOINT "+s.name)
+}
+
+// Rollback Undo the changes made since the savepoint, the enclosing transaction stays usable
+func (s savepoint) Rollback() error {
+	ctx, cancel := cleanupContext(s.ctx)
+	defer cancel()
+	return s.exec(ctx, "ROLLBACK TO SAVEPOINT "+s.name)
+}
+
+// nestedState Take a savepoint in outer and return the state of the nested transaction
+func (o *options) nestedState(ctx context.Context, outer *txState, exec func(ctx context.Context, stmt string) error) (*txState, error) {
+	sp := savepoint{name: o.savepointName(TransactionDepth(ctx) + 1), ctx: ctx, exec: exec}
+	if err := checkIdentifier(sp.name); err != nil {
+		return nil, beginFailed(err)
+	}
+	if err := exec(ctx, "SAVEPOINT "+sp.name); err != nil {
+		return nil, beginFailed(err)
+	}
+	return &txState{owner: outer.owner, sqlTx: outer.sqlTx, gormTx: outer.gormTx, ender: sp, outer: outer}, nil
+}
+
+// TransactionDepth Number of transactions and savepoints active in ctx, 0 outside any transaction
+//
+// The outermost transaction is 1 and every Transaction nested in it, which runs in a savepoint, adds one
+func TransactionDepth(ctx context.Context) int {
+	if st := txStateFrom(ctx); st != nil {
+		return st.depth
+	}
+	return 0
+}
+
+// TransactionAge How long the database transaction in ctx has been open, ok is false outside a transaction
+//
+// Inside a savepoint the age is the one of the enclosing database transaction, which holds the locks
+func TransactionAge(ctx context.Context) (time.Duration, bool) {
+	st := txStateFrom(ctx)
+	if st == nil {
+		return 0, false
+	}
+	for st.outer != nil {
+		st = st.outer
+	}
+	o := options{clock: st.clock}
+	return o.since(st.start), true
+}
+
+func txStateFrom(ctx context.Context) *txState {
+	st, _ := ctx.Value(dbKey{}).(*txState)
+	return st
+}
+
+// stateFor Innermost transaction in ctx that was started on owner, the base handle of a session
+func stateFor(ctx context.Context, owner interface{}) *txState {
+	for st := txStateFrom(ctx); st != nil; st = st.parent {
+		if st.owner == owner {
+			return st
+		}
+	}
+	return nil
+}
+
+// baseHandler Session exposing the base handle its transactions are started on, see sessionState
+type baseHandler interface {
+	baseHandle() interface{}
+}
+
+func (r *RDMSSession) baseHandle() interface{} {
+	return r.db
+}
+
+func (g *GormSession) baseHandle() interface{} {
+	return g.db
+}
+
+func (m *multiSession) baseHandle() interface{} {
+	return handleOf(m.primary)
+}
+
+func (a *autocommitSession) baseHandle() interface{} {
+	return handleOf(a.ITransaction)
+}
+
+func (s *replicaSession) baseHandle() interface{} {
+	return handleOf(s.primary)
+}
+
+func handleOf(tx ITransaction) interface{} {
+	if h, ok := tx.(baseHandler); ok {
+		return h.baseHandle()
+	}
+	return nil
+}
+
+// sessionState Innermost transaction of tx in ctx, nil when there is none. The statements of the generic
+// helpers only join that one, a transaction of another session leaves them autocommit. Sessions of other
+// packages, which do not expose their handle, match any transaction
+func sessionState(ctx context.Context, tx ITransaction) *txState {
+	if h := handleOf(tx); h != nil {
+		return stateFor(ctx, h)
+	}
+	return txStateFrom(ctx)
+}
+
 func isSupportedSQLDriver(driver string) bool {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
 	for _, d := range supportedSQLDrivers {
 		if d == driver {
 			return true
@@ -20,127 +264,1063 @@ func isSupportedSQLDriver(driver string) bool {
 	return false
 }
 
+// RegisterDriver Allow NewSession to build an RDMSSession for another database/sql driver name
+func RegisterDriver(driver string) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	for _, d := range supportedSQLDrivers {
+		if d == driver {
+			return
+		}
+	}
+	supportedSQLDrivers = append(supportedSQLDrivers, driver)
+}
+
+// SupportedDrivers Driver names accepted by NewSession, including gorm and registered drivers
+func SupportedDrivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	drivers := make([]string, 0, len(supportedSQLDrivers)+1)
+	drivers = append(drivers, supportedSQLDrivers...)
+	return append(drivers, "gorm")
+}
+
+// IsSupported Report whether NewSession accepts driver
+func IsSupported(driver string) bool {
+	return driver == "gorm" || isSupportedSQLDriver(driver)
+}
+
+// Named Create a named argument such as @p1 for ExecQuery, QueryRow and QueryRows
+//
+// Named arguments are forwarded to the driver unchanged on the RDMS backend, Gorm binds them itself
+func Named(name string, value interface{}) sql.NamedArg {
+	return sql.Named(name, value)
+}
+
+// Row Single row returned by QueryRow, satisfied by *sql.Row
+type Row interface {
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+// errRow Row returned by QueryRow when the query was not sent to the database
+type errRow struct {
+	err error
+}
+
+func (e *errRow) Scan(dest ...interface{}) error {
+	return e.err
+}
+
+func (e *errRow) Err() error {
+	return e.err
+}
+
 // ITransaction Interface for transaction
+//
+// # Every method returns ctx.Err() without a database round trip when ctx is already done
+//
+// A session holds no mutable state and is safe for concurrent use by multiple goroutines. A context carrying a
+// transaction is not: the transaction is bound to one connection, so it must only be used by one goroutine at a time
 type ITransaction interface {
-	// Transaction Start transaction
+	// Transaction Start transaction, a call nested in a transaction of the same session runs in a savepoint
+	//
+	// A panic in fn rolls the transaction back before it propagates
 	Transaction(ctx context.Context, fn func(context.Context) error) error
 
 	// ExecQuery Execute query
 	ExecQuery(ctx context.Context, query string, args ...interface{}) (interface{}, error)
 
-	// QueryRow Query single row
+	// Exec Execute query like ExecQuery and return its result typed, the same on every backend
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+
+	// QueryRow Query single row, the returned value implements Row
 	QueryRow(ctx context.Context, query string, args ...interface{}) interface{}
 
-	// QueryRows Query multiple rows
+	// QueryRows Query multiple rows, the returned value is *ContextRows
 	QueryRows(ctx context.Context, query string, args ...interface{}) (interface{}, error)
+
+	// Driver Driver type the session was created with by NewSession, such as postgres, mysql or gorm
+	Driver() string
 }
 
 // RDMSSession Transaction struct for PostgresSQL
 type RDMSSession struct {
 	db        *sql.DB
+	driver    string
 	txOptions *sql.TxOptions
-	ctx       context.Context
+	opts      options
 }
 
 // GormSession Transaction struct for Gorm
 type GormSession struct {
 	db        *gorm.DB
 	txOptions *sql.TxOptions
-	ctx       context.Context
+	opts      options
+}
+
+func (r *RDMSSession) Driver() string {
+	return r.driver
+}
+
+func (g *GormSession) Driver() string {
+	return "gorm"
 }
 
 // NewSession Create new session
-func NewSession(driverType string, db interface{}, txOptions *sql.TxOptions, ctx context.Context) ITransaction {
+//
+// The context argument is ignored and only kept for compatibility, every method takes its own context
+func NewSession(driverType string, db interface{}, txOptions *sql.TxOptions, _ context.Context, opts ...Option) ITransaction {
+	o := newOptions(opts)
 	if isSupportedSQLDriver(driverType) {
 		return &RDMSSession{
 			db:        db.(*sql.DB),
-			txOptions: txOptions,
-			ctx:       ctx,
+			driver:    driverType,
+			txOptions: o.txOptionsOr(txOptions),
+			opts:      o,
 		}
 	} else if driverType == "gorm" {
 		return &GormSession{
 			db:        db.(*gorm.DB),
-			txOptions: txOptions,
-			ctx:       ctx,
+			txOptions: o.txOptionsOr(txOptions),
+			opts:      o,
 		}
-	} else {
-		panic(fmt.Sprintf("Unsupported driver: %s", driverType))
 	}
+	panic(fmt.Sprintf("Unsupported driver: %s", driverType))
+}
+
+// TransactionName Name of the transaction given to TransactionNamed, empty when unnamed
+func TransactionName(ctx context.Context) string {
+	name, _ := ctx.Value(txNameKey{}).(string)
+	return name
+}
+
+// WithoutTransaction Return a context without the active transactions, queries issued with it run autocommit
+//
+// Useful for writes that must persist even when the surrounding transaction rolls back, such as audit logs
+func WithoutTransaction(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dbKey{}, (*txState)(nil))
+}
+
+// RegisterAfterCommit Run fn after the transaction in ctx commits, its error is returned by Transaction
+//
+// The database has already committed when fn runs, so a failing hook cannot undo the transaction and a
+// caller retrying on that error will apply the transaction again: make the work behind a retried
+// transaction idempotent. All hooks run in registration order, their errors are joined
+//
+// fn receives a context carrying the values of the ctx given to RegisterAfterCommit, without the committed
+// transaction so its queries run autocommit, and the deadline and cancellation of the ctx given to Transaction
+func RegisterAfterCommit(ctx context.Context, fn func(ctx context.Context) error) error {
+	st := txStateFrom(ctx)
+	if st == nil {
+		return ErrNoActiveTransaction
+	}
+	values := WithoutTransaction(ctx)
+	st.afterCommit = append(st.afterCommit, func(ctx context.Context) error {
+		return fn(hookContext{Context: ctx, values: values})
+	})
 	return nil
 }
 
-func (r *RDMSSession) Transaction(ctx context.Context, fn func(context.Context) error) error {
-	tx, err := r.db.BeginTx(ctx, r.txOptions)
+// hookContext Context of an after-commit hook, values come from the registration context while deadline and
+// cancellation come from the transaction's, a timeout scoped to one statement of fn has expired by commit time
+type hookContext struct {
+	context.Context
+	values context.Context
+}
+
+func (h hookContext) Value(key interface{}) interface{} {
+	return h.values.Value(key)
+}
+
+// RegisterPreCommit Run fn inside the transaction in ctx after its fn returned nil and right before it commits
+//
+// A non-nil error from fn rolls the transaction back and is returned by Transaction. Hooks run in registration
+// order and stop at the first error. fn receives the ctx given to RegisterPreCommit, its queries still run in the
+// transaction. Hooks registered in a nested Transaction run with the ones of the enclosing transaction
+func RegisterPreCommit(ctx context.Context, fn func(ctx context.Context) error) error {
+	st := txStateFrom(ctx)
+	if st == nil {
+		return ErrNoActiveTransaction
+	}
+	st.preCommit = append(st.preCommit, func() error {
+		return fn(ctx)
+	})
+	return nil
+}
+
+func (st *txState) runPreCommit() error {
+	for _, hook := range st.preCommit {
+		if err := hook(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txEnder Transaction that can be committed or rolled back
+type txEnder interface {
+	Commit() error
+	Rollback() error
+}
+
+// gormTx Adapt a Gorm transaction to txEnder
+type gormTx struct {
+	tx *gorm.DB
+}
+
+func (g gormTx) Commit() error {
+	return g.tx.Commit().Error
+}
+
+func (g gormTx) Rollback() error {
+	return g.tx.Rollback().Error
+}
+
+func newSQLTxState(owner *sql.DB, tx *sql.Tx) *txState {
+	return &txState{owner: owner, sqlTx: tx, ender: tx}
+}
+
+func newGormTxState(owner *gorm.DB, tx *gorm.DB) *txState {
+	return &txState{owner: owner, gormTx: tx, ender: gormTx{tx}}
+}
+
+// run Call fn with st stored in the context, then commit or roll back the transaction
+//
+// A panic in fn rolls the transaction back and is re-raised
+func (o *options) run(ctx context.Context, st *txState, fn func(context.Context) error) error {
+	txCtx := o.begin(ctx, st)
+	returned := false
+	defer func() {
+		if returned {
+			return
+		}
+		p := recover()
+		st.panicked = true
+		_ = o.end(ctx, st, fmt.Errorf("cransaction: panic in transaction: %v", p))
+		if p != nil {
+			panic(p)
+		}
+	}()
+	err := fn(txCtx)
+	returned = true
+	return o.end(ctx, st, err)
+}
+
+// rollbackReason Classify the rollback of st ending with ctx
+func rollbackReason(ctx context.Context, st *txState) RollbackReason {
+	switch {
+	case st.root().expired.Load():
+		return RollbackExpired
+	case st.panicked:
+		return RollbackPanic
+	case ctx.Err() != nil:
+		return RollbackCanceled
+	}
+	return RollbackError
+}
+
+// begin Report the start of the transaction and return the context carrying st
+func (o *options) begin(ctx context.Context, st *txState) context.Context {
+	st.parent = txStateFrom(ctx)
+	st.depth = TransactionDepth(ctx) + 1
+	st.clock = o.clock
+	st.name = TransactionName(ctx)
+	st.start = o.now()
+	o.emit(ctx, Event{Type: TxBegin, Name: st.name})
+	o.registerTx(st)
+	o.startExpiry(st)
+	return context.WithValue(ctx, dbKey{}, st)
+}
+
+// end Commit the transaction when err is nil and roll it back otherwise, then report the outcome
+//
+// A transaction whose context is already done is rolled back with ctx.Err() unless WithCommitOnContextCancel is set
+func (o *options) end(ctx context.Context, st *txState, err error) error {
+	if st.outer != nil {
+		return o.endSavepoint(ctx, st, err)
+	}
+	if o.txRegistry {
+		defer unregisterTx(st)
+	}
+	expired := st.stopExpiry != nil && !st.stopExpiry()
+	if expired {
+		// The timer fired, its rollback is awaited rather than raced by a second one, a Gorm transaction is not
+		// safe for concurrent use
+		<-st.expiryDone
+		err = ErrTransactionExpired
+	}
+	if err == nil {
+		err = st.runPreCommit()
+	}
+	o.reportRowsLeaks(st)
+	for _, cleanup := range st.beforeEnd {
+		cleanup()
+	}
+	if err == nil && !o.commitOnCancel {
+		// Committing for a caller that already gave up only produces work that will be thrown away
+		err = ctx.Err()
+	}
+	if err != nil {
+		if !expired {
+			_ = st.ender.Rollback()
+		}
+		o.emit(ctx, Event{
+			Type:           TxRollback,
+			Name:           st.name,
+			Duration:       o.since(st.start),
+			Err:            err,
+			RollbackReason: rollbackReason(ctx, st),
+			Summary:        st.summary(false),
+		})
+		return st.failedAfter(err)
+	}
+	if err = st.ender.Commit(); err != nil {
+		err = fmt.Errorf("%w: %w", ErrCommitFailed, err)
+	}
+	o.emit(ctx, Event{Type: TxCommit, Name: st.name, Duration: o.since(st.start), Err: err, Summary: st.summary(err == nil)})
+	if err != nil {
+		return err
+	}
+	var hookErrs []error
+	for _, hook := range st.afterCommit {
+		if err = hook(ctx); err != nil {
+			hookErrs = append(hookErrs, err)
+		}
+	}
+	return errors.Join(hookErrs...)
+}
+
+// endSavepoint Release the savepoint when err is nil and roll back to it otherwise
+//
+// Cleanups, open rows and after-commit hooks are handed to the enclosing transaction, they only apply once it ends
+func (o *options) endSavepoint(ctx context.Context, st *txState, err error) error {
+	st.outer.beforeEnd = append(st.outer.beforeEnd, st.beforeEnd...)
+	st.outer.openRows = append(st.outer.openRows, st.openRows...)
+	if st.lastQuery != "" {
+		st.outer.lastQuery = st.lastQuery
+	}
+	st.outer.stats.Queries += st.stats.Queries
+	st.outer.stats.TotalQueryDuration += st.stats.TotalQueryDuration
+	if err == nil && !o.commitOnCancel {
+		err = ctx.Err()
+	}
 	if err != nil {
+		_ = st.ender.Rollback()
+		o.emit(ctx, Event{
+			Type:           TxRollback,
+			Name:           st.name,
+			Duration:       o.since(st.start),
+			Err:            err,
+			RollbackReason: rollbackReason(ctx, st),
+			Summary:        st.summary(false),
+		})
 		return err
 	}
-	err = fn(context.WithValue(ctx, dbKey{}, tx))
+	if err = st.ender.Commit(); err != nil {
+		err = fmt.Errorf("%w: %w", ErrCommitFailed, err)
+	}
+	// A failure the savepoint was not rolled back to leaves the enclosing transaction aborted
+	st.outer.failed = st.outer.failed || st.failed || err != nil
+	o.emit(ctx, Event{Type: TxCommit, Name: st.name, Duration: o.since(st.start), Err: err, Summary: st.summary(err == nil)})
 	if err != nil {
-		_ = tx.Rollback()
 		return err
 	}
-	return tx.Commit()
+	st.outer.stats.RowsAffected += st.stats.RowsAffected
+	st.outer.preCommit = append(st.outer.preCommit, st.preCommit...)
+	st.outer.afterCommit = append(st.outer.afterCommit, st.afterCommit...)
+	return nil
+}
+
+// finisher Return the finish func handed out by Begin, calls after the first return sql.ErrTxDone
+//
+// cancel releases the transaction timeout once the transaction ended
+func (o *options) finisher(ctx context.Context, st *txState, cancel context.CancelFunc) func(err error) error {
+	done := false
+	return func(fnErr error) (err error) {
+		defer o.mapError(&err)
+		if done {
+			return sql.ErrTxDone
+		}
+		done = true
+		defer cancel()
+		return o.end(ctx, st, fnErr)
+	}
+}
+
+func (r *RDMSSession) Transaction(ctx context.Context, fn func(context.Context) error) error {
+	_, err := r.transaction(ctx, false, fn)
+	return err
+}
+
+// transaction Run fn in a new transaction of the session, or in a savepoint when ctx already carries one, and
+// return its state once it ended. Every closure-based entry point goes through it
+func (r *RDMSSession) transaction(ctx context.Context, continueOnError bool, fn func(context.Context) error) (_ *txState, err error) {
+	defer r.opts.mapError(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := txTimeout(ctx, stateFor(ctx, r.db) != nil)
+	defer cancel()
+	st, err := r.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st.continueOnError = continueOnError
+	return st, r.opts.run(ctx, st, fn)
+}
+
+// beginTx Start the database transaction, running the configured begin hooks around it
+//
+// When ctx already carries a transaction of this session a savepoint is taken in it instead, without running
+// the begin hooks. Any failure is wrapped with ErrBeginFailed
+func (r *RDMSSession) beginTx(ctx context.Context) (*txState, error) {
+	if outer := stateFor(ctx, r.db); outer != nil {
+		return r.opts.nestedState(ctx, outer, func(ctx context.Context, stmt string) error {
+			_, err := outer.sqlTx.ExecContext(ctx, stmt)
+			return err
+		})
+	}
+	if err := runHooks(ctx, r.opts.beginHooks); err != nil {
+		return nil, beginFailed(err)
+	}
+	tx, err := r.handle(ctx).BeginTx(r.opts.beginContext(ctx), txOptionsFor(ctx, r.txOptions))
+	if err != nil {
+		return nil, beginFailed(err)
+	}
+	for _, stmt := range r.opts.setup(r.driver) {
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			_ = tx.Rollback()
+			return nil, beginFailed(err)
+		}
+	}
+	if err = runHooks(ctx, r.opts.afterBeginHooks); err != nil {
+		_ = tx.Rollback()
+		return nil, beginFailed(err)
+	}
+	return newSQLTxState(r.db, tx), nil
 }
 
-func (r *RDMSSession) ExecQuery(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
-	if tx, ok := ctx.Value(dbKey{}).(*sql.Tx); ok {
-		result, err := tx.ExecContext(ctx, query, args...)
+// TransactionContinueOnError Start transaction for best-effort batch loads where failing statements are skipped
+//
+// Every ExecQuery runs in its own savepoint. A failing statement is rolled back to that savepoint and its
+// error is both returned to the caller and collected, so fn can keep going. The successful statements are
+// committed and the collected errors are returned. An error returned by fn still rolls back everything
+func (r *RDMSSession) TransactionContinueOnError(ctx context.Context, fn func(context.Context) error) ([]error, error) {
+	st, err := r.transaction(ctx, true, fn)
+	if st == nil {
+		return nil, err
+	}
+	return st.skipped, err
+}
+
+// Begin Start transaction outside of a closure, returns the transaction context and finish
+//
+// finish(nil) commits and finish(err) rolls back and returns err. The caller must always call finish,
+// usually deferred from middleware, otherwise the transaction stays open
+func (r *RDMSSession) Begin(ctx context.Context) (_ context.Context, _ func(err error) error, err error) {
+	defer r.opts.mapError(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := txTimeout(ctx, stateFor(ctx, r.db) != nil)
+	st, err := r.beginTx(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return r.opts.begin(ctx, st), r.opts.finisher(ctx, st, cancel), nil
+}
+
+// TransactionNamed Start transaction tagged with name, the name is reported in transaction events
+//
+// Names end up as labels in traces and dashboards, keep them to a bounded set such as "transfer-funds"
+func (r *RDMSSession) TransactionNamed(ctx context.Context, name string, fn func(context.Context) error) error {
+	return r.Transaction(context.WithValue(ctx, txNameKey{}, name), fn)
+}
+
+// TransactionSQL Start transaction and pass the *sql.Tx to fn along with the context
+//
+// The context still carries the transaction, so library calls made with it join the transaction. Nested in a
+// transaction of the session, fn runs in a savepoint and receives the *sql.Tx of the enclosing transaction
+func (r *RDMSSession) TransactionSQL(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	return r.Transaction(ctx, func(ctx context.Context) error {
+		tx, _ := r.txFrom(ctx)
+		return fn(ctx, tx)
+	})
+}
+
+func (r *RDMSSession) ExecQuery(ctx context.Context, query string, args ...interface{}) (_ interface{}, err error) {
+	defer r.opts.mapError(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := r.opts.checkTx(ctx, r.db); err != nil {
+		return nil, err
+	}
+	if r.opts.autoTx(ctx, r.db, query) {
+		var result interface{}
+		err = r.Transaction(ctx, func(ctx context.Context) (err error) {
+			result, err = r.ExecQuery(ctx, query, args...)
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
 		return result, nil
 	}
-	result, err := r.db.ExecContext(ctx, query, args...)
+	query, args, err = r.opts.prepare(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	st := stateFor(ctx, r.db)
+	ctx, cancel, _ := r.opts.withQueryTimeout(ctx, st)
+	defer cancel()
+	conn := r.conn(ctx)
+	query, err = r.opts.audit(ctx, st, r, query, func(stmt string, args ...interface{}) error {
+		_, err := conn.ExecContext(ctx, stmt, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result sql.Result
+	exec := func() (err error) {
+		result, err = conn.ExecContext(ctx, query, args...)
+		return err
+	}
+	start := r.opts.now()
+	if st != nil && st.continueOnError {
+		err = st.skipOnError(ctx, func(ctx context.Context, stmt string) error {
+			_, err := conn.ExecContext(ctx, stmt)
+			return err
+		}, exec)
+	} else {
+		err = r.attempt(ctx, true, exec)
+	}
+	if explain := r.opts.afterQuery(ctx, st, query, args, start, err, r.explainer(ctx), true); explain != nil {
+		explain()
+	}
 	if err != nil {
 		return nil, err
 	}
+	if st != nil {
+		n, _ := result.RowsAffected()
+		st.stats.RowsAffected += n
+	}
+	r.opts.invalidate(st, query)
 	return result, nil
 }
 
-func (r *RDMSSession) QueryRow(ctx context.Context, query string, args ...interface{}) interface{} {
-	if tx, ok := ctx.Value(dbKey{}).(*sql.Tx); ok {
-		return tx.QueryRowContext(ctx, query, args...)
+func (r *RDMSSession) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	result, err := r.ExecQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result.(sql.Result), nil
+}
+
+// ExecAffected Execute query and return the number of affected rows
+func (r *RDMSSession) ExecAffected(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	result, err := r.ExecQuery(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.(sql.Result).RowsAffected()
+}
+
+func (r *RDMSSession) QueryRow(ctx context.Context, query string, args ...interface{}) (result interface{}) {
+	defer func() {
+		result = r.opts.mapRow(result)
+	}()
+	if ctx.Err() != nil {
+		// database/sql answers a done context without a round trip, with a *sql.Row carrying its error
+		return r.opts.releaseOnScan(r.conn(ctx).QueryRowContext(ctx, query, args...), func() {})
+	}
+	if err := r.opts.checkTx(ctx, r.db); err != nil {
+		return &errRow{err}
+	}
+	query, args, err := r.opts.prepareUncommented(ctx, query, args)
+	if err != nil {
+		return &errRow{err}
+	}
+	uncommented := query
+	query = r.opts.annotate(ctx, query)
+	st := stateFor(ctx, r.db)
+	qctx, cancel, _ := r.opts.withQueryTimeout(ctx, st)
+	conn := r.conn(qctx)
+	if r.opts.cacheable(ctx, st, query) {
+		cached, err := r.opts.cachedRows(qctx, uncommented, args, func() (*sql.Rows, func(), error) {
+			return r.fetchRows(qctx, conn, st, query, args)
+		})
+		cancel()
+		if err != nil {
+			return &errRow{err}
+		}
+		return r.opts.releaseOnScan(replayRow(ctx, cached), func() {})
+	}
+	var row *sql.Row
+	start := r.opts.now()
+	err = r.attempt(qctx, false, func() error {
+		row = conn.QueryRowContext(qctx, query, args...)
+		return row.Err()
+	})
+	explain := r.opts.afterQuery(ctx, st, query, args, start, err, r.explainer(ctx), true)
+	if err != nil {
+		cancel()
+		return r.opts.releaseOnScan(row, func() {})
+	}
+	// Scan reads the row under the timeout context, it is released and the plan fetched once Scan returns
+	return r.opts.releaseOnScan(row, andExplain(cancel, explain))
+}
+
+func (r *RDMSSession) QueryRows(ctx context.Context, query string, args ...interface{}) (_ interface{}, err error) {
+	defer r.opts.mapError(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := r.opts.checkTx(ctx, r.db); err != nil {
+		return nil, err
+	}
+	query, args, err = r.opts.prepareUncommented(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	uncommented := query
+	query = r.opts.annotate(ctx, query)
+	st := stateFor(ctx, r.db)
+	qctx, cancel, _ := r.opts.withQueryTimeout(ctx, st)
+	conn := r.conn(qctx)
+	if r.opts.cacheable(ctx, st, query) {
+		cached, err := r.opts.cachedRows(qctx, uncommented, args, func() (*sql.Rows, func(), error) {
+			return r.fetchRows(qctx, conn, st, query, args)
+		})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		rows, err := replayRows(ctx, cached)
+		if err != nil {
+			return nil, err
+		}
+		return &ContextRows{Rows: rows}, nil
+	}
+	rows, explain, err := r.fetchRows(qctx, conn, st, query, args)
+	if st != nil {
+		r.opts.trackRows(st, rows, err, query)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The timeout also bounds reading the rows, its context is released and the plan fetched once they are closed
+	return releaseOnClose(rows, andExplain(cancel, explain)), nil
+}
+
+// fetchRows Run a prepared query on conn, explain is the one of afterQuery to run once the rows are closed
+func (r *RDMSSession) fetchRows(ctx context.Context, conn sqlConn, st *txState, query string, args []interface{}) (_ *sql.Rows, explain func(), _ error) {
+	var rows *sql.Rows
+	start := r.opts.now()
+	err := r.attempt(ctx, false, func() (err error) {
+		rows, err = conn.QueryContext(ctx, query, args...)
+		return err
+	})
+	explain = r.opts.afterQuery(ctx, st, query, args, start, err, r.explainer(ctx), true)
+	return rows, explain, err
+}
+
+// sqlConn Statement executor implemented by *sql.DB, *sql.Conn and *sql.Tx
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// conn Return the transaction stored in ctx, the connection pinned by WithFreshConn or the base handle
+func (r *RDMSSession) conn(ctx context.Context) sqlConn {
+	if tx, ok := r.txFrom(ctx); ok {
+		return tx
+	}
+	return r.handle(ctx)
+}
+
+// attempt Run fn once inside a transaction, autocommit statements follow the query retry policy, write tells
+// a statement of ExecQuery from a read
+func (r *RDMSSession) attempt(ctx context.Context, write bool, fn func() error) error {
+	if _, ok := r.txFrom(ctx); ok {
+		return fn()
 	}
-	return r.db.QueryRowContext(ctx, query, args...)
+	return r.opts.retry(ctx, write, fn)
 }
 
-func (r *RDMSSession) QueryRows(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
-	if tx, ok := ctx.Value(dbKey{}).(*sql.Tx); ok {
-		return tx.QueryContext(ctx, query, args...)
+// explainer Run the statements fetching a plan on the transaction, pinned connection or base handle of ctx
+func (r *RDMSSession) explainer(ctx context.Context) explainFunc {
+	conn := r.conn(ctx)
+	return func(ctx context.Context, query string, args []interface{}) (*sql.Rows, error) {
+		return conn.QueryContext(ctx, query, args...)
 	}
-	return r.db.QueryContext(ctx, query, args...)
+}
+
+// txFrom Transaction of this session carried by ctx
+func (r *RDMSSession) txFrom(ctx context.Context) (*sql.Tx, bool) {
+	if st := stateFor(ctx, r.db); st != nil {
+		return st.sqlTx, true
+	}
+	return nil, false
 }
 
 func (g *GormSession) Transaction(ctx context.Context, fn func(context.Context) error) error {
-	tx := g.db.Begin()
-	if tx.Error != nil {
-		return tx.Error
+	_, err := g.transaction(ctx, false, fn)
+	return err
+}
+
+// transaction Run fn in a new transaction of the session, or in a savepoint when ctx already carries one, and
+// return its state once it ended. Every closure-based entry point goes through it
+func (g *GormSession) transaction(ctx context.Context, continueOnError bool, fn func(context.Context) error) (_ *txState, err error) {
+	defer g.opts.mapError(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	err := fn(context.WithValue(ctx, dbKey{}, tx))
+	ctx, cancel := txTimeout(ctx, stateFor(ctx, g.db) != nil)
+	defer cancel()
+	st, err := g.beginTx(ctx, g.db)
 	if err != nil {
+		return nil, err
+	}
+	st.continueOnError = continueOnError
+	return st, g.opts.run(ctx, st, fn)
+}
+
+// beginTx Start the transaction on db, running the configured begin hooks around it
+//
+// When ctx already carries a transaction of this session a savepoint is taken in it instead, without running
+// the begin hooks. Any failure is wrapped with ErrBeginFailed
+func (g *GormSession) beginTx(ctx context.Context, db *gorm.DB) (*txState, error) {
+	if outer := stateFor(ctx, g.db); outer != nil {
+		return g.opts.nestedState(ctx, outer, func(ctx context.Context, stmt string) error {
+			return outer.gormTx.WithContext(ctx).Exec(stmt).Error
+		})
+	}
+	if err := runHooks(ctx, g.opts.beginHooks); err != nil {
+		return nil, beginFailed(err)
+	}
+	if g.opts.autoReconnect {
+		if err := g.ping(ctx); err != nil {
+			return nil, beginFailed(err)
+		}
+	}
+	tx := g.handle(ctx, db.WithContext(g.opts.beginContext(ctx))).Begin(txOptionsFor(ctx, g.txOptions))
+	if tx.Error != nil {
+		return nil, beginFailed(tx.Error)
+	}
+	for _, stmt := range g.opts.setup(g.dialect()) {
+		if err := tx.Exec(stmt).Error; err != nil {
+			tx.Rollback()
+			return nil, beginFailed(err)
+		}
+	}
+	if err := runHooks(ctx, g.opts.afterBeginHooks); err != nil {
 		tx.Rollback()
+		return nil, beginFailed(err)
+	}
+	return newGormTxState(g.db, tx), nil
+}
+
+// TransactionContinueOnError Start transaction for best-effort batch loads where failing statements are skipped
+//
+// Every ExecQuery runs in its own savepoint. A failing statement is rolled back to that savepoint and its
+// error is both returned to the caller and collected, so fn can keep going. The successful statements are
+// committed and the collected errors are returned. An error returned by fn still rolls back everything
+func (g *GormSession) TransactionContinueOnError(ctx context.Context, fn func(context.Context) error) ([]error, error) {
+	st, err := g.transaction(ctx, true, fn)
+	if st == nil {
+		return nil, err
+	}
+	return st.skipped, err
+}
+
+// Begin Start transaction outside of a closure, returns the transaction context and finish
+//
+// finish(nil) commits and finish(err) rolls back and returns err. The caller must always call finish,
+// usually deferred from middleware, otherwise the transaction stays open
+func (g *GormSession) Begin(ctx context.Context) (_ context.Context, _ func(err error) error, err error) {
+	defer g.opts.mapError(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := txTimeout(ctx, stateFor(ctx, g.db) != nil)
+	st, err := g.beginTx(ctx, g.db)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return g.opts.begin(ctx, st), g.opts.finisher(ctx, st, cancel), nil
+}
+
+// TransactionNamed Start transaction tagged with name, the name is reported in transaction events
+//
+// Names end up as labels in traces and dashboards, keep them to a bounded set such as "transfer-funds"
+func (g *GormSession) TransactionNamed(ctx context.Context, name string, fn func(context.Context) error) error {
+	return g.Transaction(context.WithValue(ctx, txNameKey{}, name), fn)
+}
+
+// GormTransaction Start transaction and pass the transaction-scoped *gorm.DB to fn along with the context
+//
+// The *gorm.DB is bound to the transaction context. Nested in a transaction of the session, fn runs in a
+// savepoint and receives the *gorm.DB of the enclosing transaction
+func (g *GormSession) GormTransaction(ctx context.Context, fn func(ctx context.Context, tx *gorm.DB) error) error {
+	return g.Transaction(ctx, func(ctx context.Context) error {
+		tx, _ := g.txFrom(ctx)
+		return fn(ctx, tx.WithContext(ctx))
+	})
+}
+
+// TransactionGorm Same as GormTransaction, the name mirrors TransactionSQL of SQL sessions
+func (g *GormSession) TransactionGorm(ctx context.Context, fn func(ctx context.Context, tx *gorm.DB) error) error {
+	return g.GormTransaction(ctx, fn)
+}
+
+func (g *GormSession) ExecQuery(ctx context.Context, query string, args ...interface{}) (_ interface{}, err error) {
+	defer g.opts.mapError(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := g.opts.checkTx(ctx, g.db); err != nil {
+		return nil, err
+	}
+	if g.opts.autoTx(ctx, g.db, query) {
+		var result interface{}
+		err = g.Transaction(ctx, func(ctx context.Context) (err error) {
+			result, err = g.ExecQuery(ctx, query, args...)
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
+		return result, nil
+	}
+	query, args, err = g.opts.prepare(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	st := stateFor(ctx, g.db)
+	ctx, cancel, _ := g.opts.withQueryTimeout(ctx, st)
+	defer cancel()
+	conn := g.conn(ctx)
+	query, err = g.opts.audit(ctx, st, g, query, func(stmt string, args ...interface{}) error {
+		return conn.Exec(stmt, args...).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result *gorm.DB
+	exec := func() error {
+		result = conn.Exec(query, args...)
+		return result.Error
+	}
+	start := g.opts.now()
+	if st != nil && st.continueOnError {
+		err = st.skipOnError(ctx, func(ctx context.Context, stmt string) error {
+			return conn.WithContext(ctx).Exec(stmt).Error
+		}, exec)
+	} else {
+		err = g.attempt(ctx, true, exec)
+	}
+	if explain := g.opts.afterQuery(ctx, st, query, args, start, err, g.explainer(ctx), !g.opts.useGormLogger); explain != nil {
+		explain()
+	}
+	if err == nil {
+		if st != nil {
+			st.stats.RowsAffected += result.RowsAffected
+		}
+		g.opts.invalidate(st, query)
+	}
+	return result, err
+}
+
+// Exec Execute query and adapt the *gorm.DB to sql.Result, LastInsertId always fails as Gorm does not report
+// the keys generated by raw statements
+func (g *GormSession) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	result, err := g.ExecQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return gormResult{result.(*gorm.DB)}, nil
+}
+
+// ExecAffected Execute query and return the number of affected rows, without exposing the *gorm.DB
+func (g *GormSession) ExecAffected(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	result, err := g.ExecQuery(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.(*gorm.DB).RowsAffected, nil
+}
+
+func (g *GormSession) QueryRow(ctx context.Context, query string, args ...interface{}) (result interface{}) {
+	defer func() {
+		result = g.opts.mapRow(result)
+	}()
+	if err := ctx.Err(); err != nil {
+		// database/sql answers a done context without a round trip, with a *sql.Row carrying its error. Row
+		// returns nil when Gorm failed before reaching it
+		if row := g.conn(ctx).Raw(query, args...).Row(); row != nil {
+			return g.opts.releaseOnScan(row, func() {})
+		}
+		return &errRow{err}
+	}
+	if err := g.opts.checkTx(ctx, g.db); err != nil {
+		return &errRow{err}
+	}
+	query, args, err := g.opts.prepareUncommented(ctx, query, args)
+	if err != nil {
+		return &errRow{err}
+	}
+	uncommented := query
+	query = g.opts.annotate(ctx, query)
+	st := stateFor(ctx, g.db)
+	qctx, cancel, _ := g.opts.withQueryTimeout(ctx, st)
+	conn := g.conn(qctx)
+	if g.opts.cacheable(ctx, st, query) {
+		cached, err := g.opts.cachedRows(qctx, uncommented, args, func() (*sql.Rows, func(), error) {
+			return g.fetchRows(qctx, conn, st, query, args)
+		})
+		cancel()
+		if err != nil {
+			return &errRow{err}
+		}
+		return g.opts.releaseOnScan(replayRow(ctx, cached), func() {})
+	}
+	var row Row
+	start := g.opts.now()
+	err = g.attempt(qctx, false, func() error {
+		// Row returns nil instead of a row carrying the error when building or running the query failed
+		raw := conn.Raw(query, args...)
+		var sqlRow *sql.Row
+		if raw.Error == nil {
+			sqlRow = raw.Row()
+		}
+		if raw.Error != nil {
+			row = &errRow{raw.Error}
+			return raw.Error
+		}
+		row = sqlRow
+		return sqlRow.Err()
+	})
+	explain := g.opts.afterQuery(ctx, st, query, args, start, err, g.explainer(ctx), !g.opts.useGormLogger)
+	if err != nil {
+		cancel()
+		return g.opts.releaseOnScan(row, func() {})
+	}
+	// Scan reads the row under the timeout context, it is released and the plan fetched once Scan returns
+	return g.opts.releaseOnScan(row, andExplain(cancel, explain))
+}
+
+func (g *GormSession) QueryRows(ctx context.Context, query string, args ...interface{}) (_ interface{}, err error) {
+	defer g.opts.mapError(&err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := g.opts.checkTx(ctx, g.db); err != nil {
+		return nil, err
+	}
+	query, args, err = g.opts.prepareUncommented(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	uncommented := query
+	query = g.opts.annotate(ctx, query)
+	st := stateFor(ctx, g.db)
+	qctx, cancel, _ := g.opts.withQueryTimeout(ctx, st)
+	conn := g.conn(qctx)
+	if g.opts.cacheable(ctx, st, query) {
+		cached, err := g.opts.cachedRows(qctx, uncommented, args, func() (*sql.Rows, func(), error) {
+			return g.fetchRows(qctx, conn, st, query, args)
+		})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		rows, err := replayRows(ctx, cached)
+		if err != nil {
+			return nil, err
+		}
+		return &ContextRows{Rows: rows}, nil
+	}
+	rows, explain, err := g.fetchRows(qctx, conn, st, query, args)
+	if st != nil {
+		g.opts.trackRows(st, rows, err, query)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The timeout also bounds reading the rows, its context is released and the plan fetched once they are closed
+	return releaseOnClose(rows, andExplain(cancel, explain)), nil
+}
+
+// fetchRows Run a prepared query on conn, explain is the one of afterQuery to run once the rows are closed
+func (g *GormSession) fetchRows(ctx context.Context, conn *gorm.DB, st *txState, query string, args []interface{}) (_ *sql.Rows, explain func(), _ error) {
+	var rows *sql.Rows
+	start := g.opts.now()
+	err := g.attempt(ctx, false, func() (err error) {
+		rows, err = conn.Raw(query, args...).Rows()
 		return err
+	})
+	explain = g.opts.afterQuery(ctx, st, query, args, start, err, g.explainer(ctx), !g.opts.useGormLogger)
+	return rows, explain, err
+}
+
+// explainer Run the statements fetching a plan on the transaction, pinned connection or base handle of ctx
+func (g *GormSession) explainer(ctx context.Context) explainFunc {
+	conn := g.conn(ctx)
+	return func(ctx context.Context, query string, args []interface{}) (*sql.Rows, error) {
+		return conn.WithContext(ctx).Raw(query, args...).Rows()
+	}
+}
+
+// reconnectDelay Wait before the second ping of WithAutoReconnect
+const reconnectDelay = 100 * time.Millisecond
+
+// ping Check the pool has a live connection, retrying once after reconnectDelay on a connection error
+func (g *GormSession) ping(ctx context.Context) error {
+	sqlDB, err := g.db.DB()
+	if err != nil {
+		return err
+	}
+	if err = sqlDB.PingContext(ctx); !IsConnectionError(err) {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-g.opts.after(reconnectDelay):
 	}
-	return tx.Commit().Error
+	return sqlDB.PingContext(ctx)
 }
 
-func (g *GormSession) ExecQuery(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
-	if tx, ok := ctx.Value(dbKey{}).(*gorm.DB); ok {
-		return tx.Exec(query, args...), nil
+// attempt Run fn once inside a transaction, autocommit statements follow the query retry policy, write tells
+// a statement of ExecQuery from a read
+func (g *GormSession) attempt(ctx context.Context, write bool, fn func() error) error {
+	if _, ok := g.txFrom(ctx); ok {
+		return fn()
 	}
-	return g.db.Exec(query, args...), nil
+	return g.opts.retry(ctx, write, fn)
 }
 
-func (g *GormSession) QueryRow(ctx context.Context, query string, args ...interface{}) interface{} {
-	if tx, ok := ctx.Value(dbKey{}).(*gorm.DB); ok {
-		return tx.Raw(query, args...).Row()
+// conn Return the transaction stored in ctx, the connection pinned by WithFreshConn or the base handle, bound to ctx
+func (g *GormSession) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := g.txFrom(ctx); ok {
+		return tx.WithContext(ctx)
 	}
-	return g.db.Raw(query, args...).Row()
+	return g.handle(ctx, g.db.WithContext(ctx))
 }
 
-func (g *GormSession) QueryRows(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
-	if tx, ok := ctx.Value(dbKey{}).(*gorm.DB); ok {
-		return tx.Raw(query, args...).Rows()
+// txFrom Transaction of this session carried by ctx
+func (g *GormSession) txFrom(ctx context.Context) (*gorm.DB, bool) {
+	if st := stateFor(ctx, g.db); st != nil {
+		return st.gormTx, true
 	}
-	return g.db.Raw(query, args...).Rows()
+	return nil, false
 }
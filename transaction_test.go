@@ -0,0 +1,876 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+type testUser struct {
+	ID   int64
+	Name string
+}
+
+func TestGormTransactionCreatesThroughTx(t *testing.T) {
+	f, g := newFakeGorm(t, "postgres")
+	ctx := context.Background()
+	err := g.GormTransaction(ctx, func(ctx context.Context, tx *gorm.DB) error {
+		if err := tx.Create(&testUser{ID: 1, Name: "ada"}).Error; err != nil {
+			return err
+		}
+		if _, ok := g.txFrom(ctx); !ok {
+			t.Error("context does not carry the transaction")
+		}
+		return tx.Create(&testUser{ID: 2, Name: "bob"}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, f,
+		"BEGIN",
+		`INSERT INTO "test_users" ("name","id") VALUES (?,?)`,
+		`INSERT INTO "test_users" ("name","id") VALUES (?,?)`,
+		"COMMIT")
+	if args := f.argsOf("INSERT"); len(args) != 2 || args[0] != "bob" {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestGormTransactionRollsBackOnError(t *testing.T) {
+	f, g := newFakeGorm(t, "postgres")
+	err := g.GormTransaction(context.Background(), func(ctx context.Context, tx *gorm.DB) error {
+		if err := tx.Create(&testUser{ID: 1, Name: "ada"}).Error; err != nil {
+			return err
+		}
+		return errFake
+	})
+	if err != errFake {
+		t.Fatalf("err = %v", err)
+	}
+	if got := f.statements(); got[len(got)-1] != "ROLLBACK" {
+		t.Fatalf("statements = %v", got)
+	}
+}
+
+func TestTransactionNamedReportsName(t *testing.T) {
+	var events []Event
+	_, r := newFakeSession(t, "postgres", WithEventHandler(func(ctx context.Context, e Event) {
+		events = append(events, e)
+	}))
+	err := r.TransactionNamed(context.Background(), "transfer-funds", func(ctx context.Context) error {
+		if name := TransactionName(ctx); name != "transfer-funds" {
+			t.Errorf("TransactionName = %q", name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Type != TxBegin || events[1].Type != TxCommit {
+		t.Fatalf("events = %+v", events)
+	}
+	for _, e := range events {
+		if e.Name != "transfer-funds" {
+			t.Errorf("event %v named %q", e.Type, e.Name)
+		}
+	}
+}
+
+func TestNamedArgsReachDriver(t *testing.T) {
+	ctx := context.Background()
+	query := "UPDATE users SET name = @p1 WHERE id = @p2"
+	RegisterDriver("sqlserver")
+	t.Run("sql", func(t *testing.T) {
+		f, r := newFakeSession(t, "sqlserver")
+		if _, err := r.ExecQuery(ctx, query, Named("p1", "ada"), Named("p2", 7)); err != nil {
+			t.Fatal(err)
+		}
+		s := f.last("UPDATE users")
+		if s.query != query || len(s.args) != 2 || s.names[0] != "p1" || s.args[0] != "ada" || s.names[1] != "p2" || s.args[1] != int64(7) {
+			t.Fatalf("%s: args = %v, names = %v", s.query, s.args, s.names)
+		}
+	})
+	t.Run("gorm", func(t *testing.T) {
+		// Gorm binds the named args itself, with the placeholders of its dialector
+		f, g := newFakeGorm(t, "sqlserver")
+		if _, err := g.ExecQuery(ctx, query, Named("p2", 7), Named("p1", "ada")); err != nil {
+			t.Fatal(err)
+		}
+		s := f.last("UPDATE users")
+		if s.query != "UPDATE users SET name = ? WHERE id = ?" || len(s.args) != 2 || s.args[0] != "ada" || s.args[1] != int64(7) {
+			t.Fatalf("%s: args = %v", s.query, s.args)
+		}
+	})
+}
+
+func TestBeginFinish(t *testing.T) {
+	ctx := context.Background()
+	t.Run("commit", func(t *testing.T) {
+		f, r := newFakeSession(t, "postgres")
+		txCtx, finish, err := r.Begin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = r.ExecQuery(txCtx, "INSERT INTO audit VALUES (1)"); err != nil {
+			t.Fatal(err)
+		}
+		if err = finish(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err = finish(nil); !errors.Is(err, sql.ErrTxDone) {
+			t.Errorf("second finish err = %v, want sql.ErrTxDone", err)
+		}
+		wantStatements(t, f, "BEGIN", "INSERT INTO audit VALUES (1)", "COMMIT")
+	})
+	t.Run("rollback", func(t *testing.T) {
+		f, g := newFakeGorm(t, "postgres")
+		txCtx, finish, err := g.Begin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = g.ExecQuery(txCtx, "INSERT INTO audit VALUES (1)"); err != nil {
+			t.Fatal(err)
+		}
+		if err = finish(errFake); !errors.Is(err, errFake) {
+			t.Fatalf("finish err = %v", err)
+		}
+		wantStatements(t, f, "BEGIN", "INSERT INTO audit VALUES (1)", "ROLLBACK")
+	})
+}
+
+func TestSupportedDrivers(t *testing.T) {
+	for _, d := range []string{"postgres", "mysql", "gorm"} {
+		if !IsSupported(d) || !slices.Contains(SupportedDrivers(), d) {
+			t.Errorf("%s is not supported", d)
+		}
+	}
+	if IsSupported("oracle") {
+		t.Error("oracle supported before registration")
+	}
+	registered := SupportedDrivers()
+	t.Cleanup(func() {
+		supportedSQLDrivers = registered[:len(registered)-1]
+	})
+	RegisterDriver("oracle")
+	if !IsSupported("oracle") || !slices.Contains(SupportedDrivers(), "oracle") {
+		t.Error("registered driver is not supported")
+	}
+}
+
+func TestSessionDriver(t *testing.T) {
+	for _, d := range []string{"postgres", "mysql", "gorm"} {
+		t.Run(d, func(t *testing.T) {
+			var s ITransaction
+			if d == "gorm" {
+				_, s = newFakeGorm(t, "postgres")
+			} else {
+				_, s = newFakeSession(t, d)
+			}
+			if got := s.Driver(); got != d {
+				t.Errorf("Driver() = %q", got)
+			}
+			_, replica := newFakeSession(t, "mysql")
+			for name, derived := range map[string]ITransaction{
+				"replica": ReplicaSession(s, replica),
+				"multi":   MultiSession(s, replica),
+			} {
+				if got := derived.Driver(); got != d {
+					t.Errorf("%s Driver() = %q, want the primary's", name, got)
+				}
+			}
+		})
+	}
+	_, db := newFakeDB(t)
+	if got := NewAutocommitSession("mysql", db).Driver(); got != "mysql" {
+		t.Errorf("autocommit Driver() = %q", got)
+	}
+}
+
+type continueOnErrorSession interface {
+	ITransaction
+	TransactionContinueOnError(ctx context.Context, fn func(context.Context) error) ([]error, error)
+}
+
+func TestTransactionContinueOnErrorSkipsBadRow(t *testing.T) {
+	sessions := map[string]func(t *testing.T) (*fakeDB, continueOnErrorSession){
+		"sql": func(t *testing.T) (*fakeDB, continueOnErrorSession) {
+			return newFakeSession(t, "postgres")
+		},
+		"gorm": func(t *testing.T) (*fakeDB, continueOnErrorSession) {
+			return newFakeGorm(t, "postgres")
+		},
+	}
+	for name, open := range sessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t)
+			f.fail("VALUES (2)", errFake)
+			skipped, err := s.TransactionContinueOnError(context.Background(), func(ctx context.Context) error {
+				for i := 1; i <= 3; i++ {
+					_, _ = s.ExecQuery(ctx, fmt.Sprintf("INSERT INTO items VALUES (%d)", i))
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(skipped) != 1 || !errors.Is(skipped[0], errFake) {
+				t.Fatalf("skipped = %v", skipped)
+			}
+			wantStatements(t, f,
+				"BEGIN",
+				"SAVEPOINT cransaction_stmt", "INSERT INTO items VALUES (1)", "RELEASE SAVEPOINT cransaction_stmt",
+				"SAVEPOINT cransaction_stmt", "INSERT INTO items VALUES (2)", "ROLLBACK TO SAVEPOINT cransaction_stmt",
+				"SAVEPOINT cransaction_stmt", "INSERT INTO items VALUES (3)", "RELEASE SAVEPOINT cransaction_stmt",
+				"COMMIT")
+		})
+	}
+}
+
+func TestWithoutTransactionSurvivesRollback(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	err := r.Transaction(context.Background(), func(ctx context.Context) error {
+		if _, err := r.ExecQuery(ctx, "UPDATE accounts SET balance = 0"); err != nil {
+			return err
+		}
+		if TransactionDepth(WithoutTransaction(ctx)) != 0 {
+			t.Error("WithoutTransaction kept the transaction")
+		}
+		if _, err := r.ExecQuery(WithoutTransaction(ctx), "INSERT INTO audit VALUES ('reset')"); err != nil {
+			return err
+		}
+		return errFake
+	})
+	if !errors.Is(err, errFake) {
+		t.Fatalf("err = %v", err)
+	}
+	if !f.last("UPDATE accounts").inTx {
+		t.Error("the update ran outside the transaction")
+	}
+	if f.last("INSERT INTO audit").inTx {
+		t.Error("the audit row ran in the rolled back transaction")
+	}
+	if got := f.statements(); got[len(got)-1] != "ROLLBACK" {
+		t.Errorf("statements = %v", got)
+	}
+}
+
+func TestCancelledBeforeCommit(t *testing.T) {
+	t.Run("rollback", func(t *testing.T) {
+		f, r := newFakeSession(t, "postgres")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		err := r.Transaction(ctx, func(ctx context.Context) error {
+			if _, err := r.ExecQuery(ctx, "INSERT INTO orders VALUES (1)"); err != nil {
+				return err
+			}
+			cancel()
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+		if f.count("COMMIT") != 0 || f.count("ROLLBACK") != 1 {
+			t.Errorf("statements = %v", f.statements())
+		}
+	})
+	for name, open := range testSessions {
+		t.Run("commit on cancel/"+name, func(t *testing.T) {
+			f, s := open(t, "postgres", WithCommitOnContextCancel(true))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err := s.Transaction(ctx, func(ctx context.Context) error {
+				cancel()
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatements(t, f, "BEGIN", "COMMIT")
+		})
+	}
+}
+
+func TestAfterCommitErrorPropagates(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	errAck := errors.New("not acknowledged")
+	ran := 0
+	err := r.Transaction(context.Background(), func(ctx context.Context) error {
+		if err := RegisterAfterCommit(ctx, func(ctx context.Context) error {
+			ran++
+			if TransactionDepth(ctx) != 0 {
+				t.Error("after-commit hook runs in the committed transaction")
+			}
+			return errAck
+		}); err != nil {
+			return err
+		}
+		_, err := r.ExecQuery(ctx, "INSERT INTO outbox VALUES (1)")
+		return err
+	})
+	if !errors.Is(err, errAck) || ran != 1 {
+		t.Fatalf("err = %v, hook ran %d times", err, ran)
+	}
+	wantStatements(t, f, "BEGIN", "INSERT INTO outbox VALUES (1)", "COMMIT")
+
+	if err = RegisterAfterCommit(context.Background(), func(ctx context.Context) error { return nil }); !errors.Is(err, ErrNoActiveTransaction) {
+		t.Errorf("outside a transaction err = %v", err)
+	}
+}
+
+func TestGormAutoReconnect(t *testing.T) {
+	clock := &fakeClock{instant: true}
+	f, g := newFakeGorm(t, "postgres", WithAutoReconnect(true), WithClock(clock))
+	f.handle("PING", flaky(1, io.ErrUnexpectedEOF, nil))
+	if err := g.Transaction(context.Background(), func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	wantStatements(t, f, "PING", "PING", "BEGIN", "COMMIT")
+	if waits := clock.recorded(); len(waits) != 1 || waits[0] != reconnectDelay {
+		t.Errorf("waited %v", waits)
+	}
+}
+
+func TestGormAutoReconnectOnlyOnConnectionErrors(t *testing.T) {
+	f, g := newFakeGorm(t, "postgres", WithAutoReconnect(true), WithClock(&fakeClock{instant: true}))
+	errAuth := errors.New("password authentication failed")
+	f.fail("PING", errAuth)
+	err := g.Transaction(context.Background(), func(ctx context.Context) error { return nil })
+	if !errors.Is(err, errAuth) || !errors.Is(err, ErrBeginFailed) {
+		t.Fatalf("err = %v", err)
+	}
+	wantStatements(t, f, "PING")
+}
+
+func TestDoneContextSkipsDatabase(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, stop := context.WithDeadline(context.Background(), time.Unix(0, 0))
+	defer stop()
+	for backend, open := range testSessions {
+		for name, ctx := range map[string]context.Context{"cancelled": cancelled, "expired": expired} {
+			t.Run(backend+"/"+name, func(t *testing.T) {
+				f, tx := open(t, "postgres")
+				want := ctx.Err()
+				err := tx.Transaction(ctx, func(ctx context.Context) error {
+					t.Error("fn ran with a done context")
+					return nil
+				})
+				if !errors.Is(err, want) {
+					t.Errorf("Transaction err = %v, want %v", err, want)
+				}
+				if _, err = tx.ExecQuery(ctx, "UPDATE accounts SET balance = 0"); !errors.Is(err, want) {
+					t.Errorf("ExecQuery err = %v, want %v", err, want)
+				}
+				var n int
+				// Callers written against database/sql assert the row to *sql.Row
+				if err = tx.QueryRow(ctx, "SELECT 1").(*sql.Row).Scan(&n); !errors.Is(err, want) {
+					t.Errorf("QueryRow err = %v, want %v", err, want)
+				}
+				if _, err = tx.QueryRows(ctx, "SELECT 1"); !errors.Is(err, want) {
+					t.Errorf("QueryRows err = %v, want %v", err, want)
+				}
+				wantStatements(t, f)
+			})
+		}
+	}
+}
+
+type testRequestID struct{}
+
+func TestAfterCommitHookSeesRegistrationValues(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			base, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var got interface{}
+			var deadErr error
+			err := tx.Transaction(base, func(ctx context.Context) error {
+				ctx = context.WithValue(ctx, testRequestID{}, "req-42")
+				stmt, done := context.WithCancel(ctx)
+				defer done()
+				return RegisterAfterCommit(stmt, func(ctx context.Context) error {
+					got, deadErr = ctx.Value(testRequestID{}), ctx.Err()
+					_, err := tx.ExecQuery(ctx, "INSERT INTO audit VALUES ('committed')")
+					return err
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "req-42" {
+				t.Errorf("hook read %v", got)
+			}
+			if deadErr != nil {
+				t.Errorf("hook context done with %v after the registration context was cancelled", deadErr)
+			}
+			if f.last("INSERT INTO audit").inTx {
+				t.Error("hook query ran in the committed transaction")
+			}
+		})
+	}
+}
+
+func TestSessionConcurrentUse(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres", WithQueryRetry(1, RetryPolicy{}), WithQueryTimeout(time.Minute))
+			f.on("SELECT", rowsOf([]string{"n"}, []driver.Value{int64(1)}))
+			const workers, rounds = 16, 20
+			var wg sync.WaitGroup
+			errs := make(chan error, workers)
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					ctx := context.Background()
+					for i := 0; i < rounds; i++ {
+						if _, err := tx.ExecQuery(ctx, "INSERT INTO hits VALUES (?)", w); err != nil {
+							errs <- err
+							return
+						}
+						if _, err := QueryValue[int64](ctx, tx, "SELECT COUNT(*) FROM hits"); err != nil {
+							errs <- err
+							return
+						}
+						// Each goroutine runs its own transaction, only the session is shared
+						if err := tx.Transaction(ctx, func(ctx context.Context) error {
+							_, err := tx.ExecQuery(ctx, "UPDATE hits SET n = n + 1")
+							return err
+						}); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+			if got := f.count("UPDATE hits"); got != workers*rounds {
+				t.Errorf("%d updates, want %d", got, workers*rounds)
+			}
+		})
+	}
+}
+
+func TestTransactionDepth(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			var depths []int
+			record := func(ctx context.Context) {
+				depths = append(depths, TransactionDepth(ctx))
+			}
+			ctx := context.Background()
+			record(ctx)
+			err := tx.Transaction(ctx, func(ctx context.Context) error {
+				record(ctx)
+				if err := tx.Transaction(ctx, func(ctx context.Context) error {
+					record(ctx)
+					if err := tx.Transaction(ctx, func(ctx context.Context) error {
+						record(ctx)
+						return errFake
+					}); !errors.Is(err, errFake) {
+						return fmt.Errorf("innermost err = %v", err)
+					}
+					record(ctx)
+					return nil
+				}); err != nil {
+					return err
+				}
+				record(ctx)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			record(ctx)
+			if want := []int{0, 1, 2, 3, 2, 1, 0}; !slices.Equal(depths, want) {
+				t.Errorf("depths = %v, want %v", depths, want)
+			}
+			wantStatements(t, f, "BEGIN", "SAVEPOINT sp_2", "SAVEPOINT sp_3", "ROLLBACK TO SAVEPOINT sp_3",
+				"RELEASE SAVEPOINT sp_2", "COMMIT")
+		})
+	}
+}
+
+func TestExecAffected(t *testing.T) {
+	type affecter interface {
+		ExecAffected(ctx context.Context, query string, args ...interface{}) (int64, error)
+	}
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			s := tx.(affecter)
+			f.on("UPDATE users", &fakeResult{affected: 3})
+			f.fail("UPDATE orders", errFake)
+			n, err := s.ExecAffected(context.Background(), "UPDATE users SET active = ?", true)
+			if err != nil || n != 3 {
+				t.Fatalf("n = %d, err = %v", n, err)
+			}
+			if n, err = s.ExecAffected(context.Background(), "UPDATE orders SET paid = ?", true); !errors.Is(err, errFake) || n != 0 {
+				t.Fatalf("n = %d, err = %v, want the driver error", n, err)
+			}
+			err = tx.Transaction(context.Background(), func(ctx context.Context) error {
+				n, err := s.ExecAffected(ctx, "UPDATE users SET active = ?", false)
+				if n != 3 {
+					t.Errorf("in transaction n = %d", n)
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !f.last("active = ").inTx {
+				t.Error("ExecAffected ran outside the transaction in ctx")
+			}
+		})
+	}
+}
+
+func TestTransactionErrorNamesFailingQuery(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			f.fail("UPDATE accounts", errFake)
+			err := tx.Transaction(context.Background(), func(ctx context.Context) error {
+				if _, err := tx.ExecQuery(ctx, "INSERT INTO transfers VALUES (?)", "secret-iban"); err != nil {
+					return err
+				}
+				_, err := tx.ExecQuery(ctx, "UPDATE accounts SET balance = balance - ?", 10)
+				return err
+			})
+			if !errors.Is(err, errFake) {
+				t.Fatalf("err = %v", err)
+			}
+			if !strings.Contains(err.Error(), `after query "UPDATE accounts SET balance = balance - ?"`) {
+				t.Errorf("err = %v, want it to name the update", err)
+			}
+			if strings.Contains(err.Error(), "secret-iban") {
+				t.Errorf("err = %v leaks an argument", err)
+			}
+		})
+	}
+}
+
+func TestTransactionErrorTruncatesLongQuery(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	f.fail("INSERT", errFake)
+	long := "INSERT INTO t VALUES (" + strings.Repeat("é", maxErrorQueryLen) + ")"
+	err := r.Transaction(context.Background(), func(ctx context.Context) error {
+		_, err := r.ExecQuery(ctx, long)
+		return err
+	})
+	if !errors.Is(err, errFake) || strings.Contains(err.Error(), long) || !strings.Contains(err.Error(), `..."`) {
+		t.Fatalf("err = %v", err)
+	}
+	if !utf8.ValidString(err.Error()) {
+		t.Error("truncation split a character")
+	}
+}
+
+func TestTransactionAge(t *testing.T) {
+	if _, ok := TransactionAge(context.Background()); ok {
+		t.Error("age reported outside a transaction")
+	}
+	_, r := newFakeSession(t, "postgres")
+	err := r.Transaction(context.Background(), func(ctx context.Context) error {
+		before, ok := TransactionAge(ctx)
+		if !ok {
+			t.Fatal("no age inside the transaction")
+		}
+		time.Sleep(10 * time.Millisecond)
+		if after, _ := TransactionAge(ctx); after-before < 10*time.Millisecond {
+			t.Errorf("age grew by %v over a 10ms sleep", after-before)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := newFakeClock()
+	_, g := newFakeGorm(t, "postgres", WithClock(clock))
+	err = g.Transaction(context.Background(), func(ctx context.Context) error {
+		clock.Advance(time.Minute)
+		return g.Transaction(ctx, func(ctx context.Context) error {
+			clock.Advance(time.Second)
+			// A savepoint reports the age of the database transaction holding the locks
+			if age, ok := TransactionAge(ctx); !ok || age != time.Minute+time.Second {
+				t.Errorf("age = %v, %v", age, ok)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreCommitVetoRollsBack(t *testing.T) {
+	errInvariant := errors.New("balance below zero")
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres")
+			var ran []string
+			err := tx.Transaction(context.Background(), func(ctx context.Context) error {
+				if _, err := tx.ExecQuery(ctx, "UPDATE accounts SET balance = balance - 100"); err != nil {
+					return err
+				}
+				for _, hook := range []struct {
+					name string
+					err  error
+				}{{"check", nil}, {"veto", errInvariant}, {"after veto", nil}} {
+					if err := RegisterPreCommit(ctx, func(ctx context.Context) error {
+						ran = append(ran, hook.name)
+						if TransactionDepth(ctx) == 0 {
+							t.Error("pre-commit hook runs outside the transaction")
+						}
+						_, err := tx.ExecQuery(ctx, "SELECT balance FROM accounts")
+						if err != nil {
+							return err
+						}
+						return hook.err
+					}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if !errors.Is(err, errInvariant) {
+				t.Fatalf("err = %v", err)
+			}
+			if !slices.Equal(ran, []string{"check", "veto"}) {
+				t.Errorf("hooks ran %v", ran)
+			}
+			if !f.last("SELECT balance").inTx {
+				t.Error("the hook query ran outside the transaction")
+			}
+			if f.count("COMMIT") != 0 || f.count("ROLLBACK") != 1 {
+				t.Errorf("statements = %v", f.statements())
+			}
+		})
+	}
+}
+
+func TestPreCommitNotRunOnFailure(t *testing.T) {
+	_, r := newFakeSession(t, "postgres")
+	ran := false
+	err := r.Transaction(context.Background(), func(ctx context.Context) error {
+		if err := RegisterPreCommit(ctx, func(context.Context) error { ran = true; return nil }); err != nil {
+			return err
+		}
+		return errFake
+	})
+	if !errors.Is(err, errFake) || ran {
+		t.Errorf("err = %v, hook ran = %v", err, ran)
+	}
+	if err = RegisterPreCommit(context.Background(), func(context.Context) error { return nil }); !errors.Is(err, ErrNoActiveTransaction) {
+		t.Errorf("outside a transaction err = %v", err)
+	}
+}
+
+func TestGormQueryRowSurfacesQueryError(t *testing.T) {
+	errSyntax := &pgError{"42601"}
+	f, g := newFakeGorm(t, "postgres")
+	f.fail("SELEC", errSyntax)
+	row := g.QueryRow(context.Background(), "SELEC id FROM users WHERE")
+	r, ok := row.(Row)
+	if !ok || r == nil {
+		t.Fatalf("QueryRow returned %T", row)
+	}
+	if err := r.Err(); !errors.Is(err, errSyntax) {
+		t.Errorf("Err = %v", err)
+	}
+	var id int64
+	if err := r.Scan(&id); !errors.Is(err, errSyntax) {
+		t.Errorf("Scan err = %v", err)
+	}
+}
+
+func TestRollbackReasons(t *testing.T) {
+	cases := []struct {
+		name string
+		fn   func(ctx context.Context, cancel context.CancelFunc) error
+		want RollbackReason
+	}{
+		{"error", func(context.Context, context.CancelFunc) error { return errFake }, RollbackError},
+		{"pre-commit veto", func(ctx context.Context, _ context.CancelFunc) error {
+			return RegisterPreCommit(ctx, func(context.Context) error { return errFake })
+		}, RollbackError},
+		{"panic", func(context.Context, context.CancelFunc) error { panic("boom") }, RollbackPanic},
+		{"canceled", func(_ context.Context, cancel context.CancelFunc) error {
+			cancel()
+			return nil
+		}, RollbackCanceled},
+	}
+	for backend, open := range testSessions {
+		for _, c := range cases {
+			t.Run(backend+"/"+c.name, func(t *testing.T) {
+				var rollbacks []RollbackReason
+				_, tx := open(t, "postgres", WithEventHandler(func(ctx context.Context, e Event) {
+					if e.Type == TxRollback {
+						rollbacks = append(rollbacks, e.RollbackReason)
+					} else if e.RollbackReason != RollbackNone {
+						t.Errorf("%v event has reason %v", e.Type, e.RollbackReason)
+					}
+				}))
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				func() {
+					defer func() {
+						if p := recover(); (p != nil) != (c.want == RollbackPanic) {
+							t.Errorf("recovered %v", p)
+						}
+					}()
+					_ = tx.Transaction(ctx, func(ctx context.Context) error { return c.fn(ctx, cancel) })
+				}()
+				if len(rollbacks) != 1 || rollbacks[0] != c.want {
+					t.Errorf("rollback reasons = %v, want %v", rollbacks, c.want)
+				}
+			})
+		}
+	}
+}
+
+func TestSavepointNamer(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, tx := open(t, "postgres", WithSavepointNamer(func(depth int) string {
+				return fmt.Sprintf("import_level_%d", depth)
+			}))
+			err := tx.Transaction(context.Background(), func(ctx context.Context) error {
+				return tx.Transaction(ctx, func(ctx context.Context) error {
+					_ = tx.Transaction(ctx, func(ctx context.Context) error { return errFake })
+					return nil
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatements(t, f, "BEGIN", "SAVEPOINT import_level_2", "SAVEPOINT import_level_3",
+				"ROLLBACK TO SAVEPOINT import_level_3", "RELEASE SAVEPOINT import_level_2", "COMMIT")
+		})
+	}
+}
+
+func TestSavepointNamerRejectsUnsafeNames(t *testing.T) {
+	f, r := newFakeSession(t, "postgres", WithSavepointNamer(func(int) string { return "sp; DROP TABLE users" }))
+	var nestedErr error
+	err := r.Transaction(context.Background(), func(ctx context.Context) error {
+		nestedErr = r.Transaction(ctx, func(ctx context.Context) error {
+			t.Error("nested fn ran without its savepoint")
+			return nil
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(nestedErr, ErrBeginFailed) {
+		t.Errorf("nested err = %v, want ErrBeginFailed", nestedErr)
+	}
+	wantStatements(t, f, "BEGIN", "COMMIT")
+}
+
+func TestTransactionSQLPassesTx(t *testing.T) {
+	f, r := newFakeSession(t, "postgres")
+	err := r.TransactionSQL(context.Background(), func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE accounts SET balance = balance - 10 WHERE id = $1", 1); err != nil {
+			return err
+		}
+		if _, err := r.ExecQuery(ctx, "INSERT INTO ledger VALUES (1)"); err != nil {
+			return err
+		}
+		return r.TransactionSQL(ctx, func(ctx context.Context, inner *sql.Tx) error {
+			if inner != tx {
+				t.Error("nested TransactionSQL got another *sql.Tx")
+			}
+			_, err := inner.ExecContext(ctx, "DELETE FROM holds")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{"UPDATE", "INSERT", "DELETE"} {
+		if !f.last(q).inTx {
+			t.Errorf("%s ran outside the transaction", q)
+		}
+	}
+	wantStatements(t, f, "BEGIN", "UPDATE accounts SET balance = balance - 10 WHERE id = $1", "INSERT INTO ledger VALUES (1)",
+		"SAVEPOINT sp_2", "DELETE FROM holds", "RELEASE SAVEPOINT sp_2", "COMMIT")
+
+	err = r.TransactionSQL(context.Background(), func(ctx context.Context, tx *sql.Tx) error {
+		return errFake
+	})
+	if !errors.Is(err, errFake) || f.count("ROLLBACK") != 1 {
+		t.Errorf("err = %v, statements = %v", err, f.statements())
+	}
+}
+
+func TestTransactionGormPassesTx(t *testing.T) {
+	f, g := newFakeGorm(t, "postgres")
+	err := g.TransactionGorm(context.Background(), func(ctx context.Context, tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE accounts SET balance = 0 WHERE id = ?", 1).Error; err != nil {
+			return err
+		}
+		_, err := g.ExecQuery(ctx, "INSERT INTO ledger VALUES (1)")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.last("UPDATE").inTx || !f.last("INSERT").inTx {
+		t.Errorf("statements ran outside the transaction")
+	}
+	wantStatements(t, f, "BEGIN", "UPDATE accounts SET balance = 0 WHERE id = ?", "INSERT INTO ledger VALUES (1)", "COMMIT")
+}
+
+func TestSavepointCleanupAfterCancel(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			f.handle("ROLLBACK TO SAVEPOINT", func(ctx context.Context, _ []driver.NamedValue) (*fakeResult, error) {
+				if _, ok := ctx.Deadline(); ctx.Err() != nil || !ok {
+					t.Errorf("rollback to savepoint ran under a done or unbounded context: %v", ctx.Err())
+				}
+				return nil, nil
+			})
+			err := s.Transaction(context.Background(), func(ctx context.Context) error {
+				inner, cancel := context.WithCancel(ctx)
+				err := s.Transaction(inner, func(ctx context.Context) error {
+					if _, err := s.ExecQuery(ctx, "DELETE FROM holds"); err != nil {
+						return err
+					}
+					cancel()
+					return nil
+				})
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("savepoint err = %v, want the cancellation", err)
+				}
+				_, err = s.ExecQuery(ctx, "INSERT INTO ledger VALUES (1)")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatements(t, f, "BEGIN", "SAVEPOINT sp_2", "DELETE FROM holds", "ROLLBACK TO SAVEPOINT sp_2",
+				"INSERT INTO ledger VALUES (1)", "COMMIT")
+		})
+	}
+}
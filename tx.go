@@ -0,0 +1,88 @@
+package cransaction
+
+import (
+	"context"
+	"errors"
+	"runtime"
+)
+
+// Tx Handle on a transaction kept open across calls, see BeginTx
+//
+// Exactly one of Commit and Rollback must be called, the calls after the first return sql.ErrTxDone. A Tx
+// garbage collected while still open is reported through the session logger
+type Tx struct {
+	ctx     context.Context
+	session ITransaction
+	finish  func(err error) error
+	done    bool
+}
+
+// errRollback Reason given to finish by Tx.Rollback
+var errRollback = errors.New("cransaction: transaction rolled back")
+
+// BeginTx Start a transaction and return a handle to run statements in it and commit or roll it back
+func (r *RDMSSession) BeginTx(ctx context.Context) (*Tx, error) {
+	return beginHandle(ctx, r)
+}
+
+// BeginTx Start a transaction and return a handle to run statements in it and commit or roll it back
+func (g *GormSession) BeginTx(ctx context.Context) (*Tx, error) {
+	return beginHandle(ctx, g)
+}
+
+func beginHandle(ctx context.Context, session beginner) (*Tx, error) {
+	txCtx, finish, err := session.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tx{ctx: txCtx, session: session, finish: finish}
+	o := optionsOf(session)
+	name := TransactionName(ctx)
+	runtime.SetFinalizer(t, func(t *Tx) {
+		if !t.done {
+			o.logf("cransaction: transaction %q was garbage collected without Commit or Rollback", name)
+		}
+	})
+	return t, nil
+}
+
+// Context Context carrying the transaction, for the helpers taking an ITransaction
+func (t *Tx) Context() context.Context {
+	return t.ctx
+}
+
+// Exec Execute query in the transaction
+func (t *Tx) Exec(query string, args ...interface{}) (ExecResult, error) {
+	result, err := t.session.ExecQuery(t.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return execResult(result)
+}
+
+// QueryRow Query single row in the transaction, the returned value implements Row
+func (t *Tx) QueryRow(query string, args ...interface{}) interface{} {
+	return t.session.QueryRow(t.ctx, query, args...)
+}
+
+// QueryRows Query multiple rows in the transaction
+func (t *Tx) QueryRows(query string, args ...interface{}) (interface{}, error) {
+	return t.session.QueryRows(t.ctx, query, args...)
+}
+
+// Commit Commit the transaction
+func (t *Tx) Commit() error {
+	err := t.finish(nil)
+	t.done = true
+	return err
+}
+
+// Rollback Roll the transaction back
+func (t *Tx) Rollback() error {
+	err := t.finish(errRollback)
+	t.done = true
+	if errors.Is(err, errRollback) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// handleSession Session offering BeginTx, both backends do
+type handleSession interface {
+	BeginTx(ctx context.Context) (*Tx, error)
+}
+
+func TestTxHandleCommit(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			f.on("SELECT balance", rowsOf([]string{"balance"}, []driver.Value{int64(90)}))
+			f.on("UPDATE", &fakeResult{affected: 1})
+			tx, err := s.(handleSession).BeginTx(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if TransactionDepth(tx.Context()) != 1 {
+				t.Error("the handle context carries no transaction")
+			}
+			res, err := tx.Exec("UPDATE accounts SET balance = balance - 10")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, _ := res.RowsAffected(); n != 1 {
+				t.Errorf("affected = %d", n)
+			}
+			var balance int64
+			if err = tx.QueryRow("SELECT balance FROM accounts").(Row).Scan(&balance); err != nil || balance != 90 {
+				t.Errorf("balance = %d, err = %v", balance, err)
+			}
+			rows, err := tx.QueryRows("SELECT balance FROM accounts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = rows.(*ContextRows).Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err = tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if err = tx.Commit(); !errors.Is(err, sql.ErrTxDone) {
+				t.Errorf("second Commit err = %v", err)
+			}
+			if err = tx.Rollback(); !errors.Is(err, sql.ErrTxDone) {
+				t.Errorf("Rollback after Commit err = %v", err)
+			}
+			wantStatements(t, f, "BEGIN", "UPDATE accounts SET balance = balance - 10", "SELECT balance FROM accounts",
+				"SELECT balance FROM accounts", "COMMIT")
+		})
+	}
+}
+
+func TestTxHandleRollback(t *testing.T) {
+	for backend, open := range testSessions {
+		t.Run(backend, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			tx, err := s.(handleSession).BeginTx(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = tx.Exec("DELETE FROM carts"); err != nil {
+				t.Fatal(err)
+			}
+			if err = tx.Rollback(); err != nil {
+				t.Fatalf("Rollback err = %v", err)
+			}
+			if err = tx.Commit(); !errors.Is(err, sql.ErrTxDone) {
+				t.Errorf("Commit after Rollback err = %v", err)
+			}
+			wantStatements(t, f, "BEGIN", "DELETE FROM carts", "ROLLBACK")
+		})
+	}
+}
+
+func TestTxHandleLeakWarning(t *testing.T) {
+	log := &testLogger{}
+	_, r := newFakeSession(t, "postgres", WithLogger(log))
+	func() {
+		if _, err := r.BeginTx(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for deadline := time.Now().Add(time.Second); len(log.logged("garbage collected")) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("no warning for the leaked transaction")
+		}
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+}
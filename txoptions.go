@@ -0,0 +1,92 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// TxOptionsBuilder Fluent builder for the *sql.TxOptions passed to NewSession
+//
+// The builder is a value, every call returns an updated copy
+type TxOptionsBuilder struct {
+	opts sql.TxOptions
+}
+
+// TxOpts Start building transaction options, for example TxOpts().ReadOnly().Isolation(sql.LevelSerializable).Build()
+func TxOpts() TxOptionsBuilder {
+	return TxOptionsBuilder{}
+}
+
+// ReadOnly Mark the transaction read-only
+func (b TxOptionsBuilder) ReadOnly() TxOptionsBuilder {
+	b.opts.ReadOnly = true
+	return b
+}
+
+// Isolation Set the isolation level
+func (b TxOptionsBuilder) Isolation(level sql.IsolationLevel) TxOptionsBuilder {
+	b.opts.Isolation = level
+	return b
+}
+
+// Build Return the options
+func (b TxOptionsBuilder) Build() *sql.TxOptions {
+	opts := b.opts
+	return &opts
+}
+
+// TxDefaults Request-scoped transaction settings, see WithTxDefaults
+type TxDefaults struct {
+	// Isolation Isolation level, sql.LevelDefault keeps the one of the session
+	Isolation sql.IsolationLevel
+	// ReadOnly Run read-only, false keeps the setting of the session unless ReadWrite is set
+	ReadOnly bool
+	// ReadWrite Run read-write on a session whose TxOptions are read-only, ignored when ReadOnly is set
+	ReadWrite bool
+	// Timeout Bound on the whole transaction, commit included, zero for none
+	Timeout time.Duration
+}
+
+type txDefaultsKey struct{}
+
+// WithTxDefaults Return a context whose transactions use d over the TxOptions given to NewSession
+//
+// The defaults apply to the database transactions begun with the context, a Transaction nested in one runs in
+// its savepoint and is not bound by a new timeout. There is no per-call layer above them: to give a single
+// transaction other options, wrap the context it is begun with in WithTxDefaults again
+func WithTxDefaults(ctx context.Context, d TxDefaults) context.Context {
+	return context.WithValue(ctx, txDefaultsKey{}, d)
+}
+
+// txOptionsFor TxOptions of a transaction begun with ctx on a session configured with session
+func txOptionsFor(ctx context.Context, session *sql.TxOptions) *sql.TxOptions {
+	d, ok := ctx.Value(txDefaultsKey{}).(TxDefaults)
+	if !ok || (d.Isolation == sql.LevelDefault && !d.ReadOnly && !d.ReadWrite) {
+		return session
+	}
+	var opts sql.TxOptions
+	if session != nil {
+		opts = *session
+	}
+	if d.Isolation != sql.LevelDefault {
+		opts.Isolation = d.Isolation
+	}
+	switch {
+	case d.ReadOnly:
+		opts.ReadOnly = true
+	case d.ReadWrite:
+		opts.ReadOnly = false
+	}
+	return &opts
+}
+
+// txTimeout Apply the Timeout of the context defaults to a transaction about to begin, nested is true when ctx
+// already carries a transaction of the session
+func txTimeout(ctx context.Context, nested bool) (context.Context, context.CancelFunc) {
+	d, _ := ctx.Value(txDefaultsKey{}).(TxDefaults)
+	if nested || d.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d.Timeout)
+}
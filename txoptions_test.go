@@ -0,0 +1,196 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestTxOptsBuilder(t *testing.T) {
+	cases := []struct {
+		name string
+		got  *sql.TxOptions
+		want sql.TxOptions
+	}{
+		{"default", TxOpts().Build(), sql.TxOptions{}},
+		{"read only", TxOpts().ReadOnly().Build(), sql.TxOptions{ReadOnly: true}},
+		{"isolation", TxOpts().Isolation(sql.LevelSerializable).Build(), sql.TxOptions{Isolation: sql.LevelSerializable}},
+		{"both", TxOpts().Isolation(sql.LevelRepeatableRead).ReadOnly().Build(), sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}},
+		{"last isolation wins", TxOpts().Isolation(sql.LevelSerializable).Isolation(sql.LevelReadCommitted).Build(), sql.TxOptions{Isolation: sql.LevelReadCommitted}},
+	}
+	for _, c := range cases {
+		if *c.got != c.want {
+			t.Errorf("%s: got %+v, want %+v", c.name, *c.got, c.want)
+		}
+	}
+
+	base := TxOpts().ReadOnly()
+	if base.Isolation(sql.LevelSerializable); base.Build().Isolation != sql.LevelDefault {
+		t.Error("builder changed by a call whose copy was dropped")
+	}
+	if a, b := base.Build(), base.Build(); a == b {
+		t.Error("Build returned the same pointer twice")
+	}
+}
+
+func TestTxOptsReachDriver(t *testing.T) {
+	f, db := newFakeDB(t)
+	r := NewSession("postgres", db, TxOpts().ReadOnly().Isolation(sql.LevelSerializable).Build(), context.Background())
+	if err := r.Transaction(context.Background(), func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	want := driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelSerializable), ReadOnly: true}
+	if len(f.txOpts) != 1 || f.txOpts[0] != want {
+		t.Errorf("driver got %+v", f.txOpts)
+	}
+}
+
+func TestGormTxOptionsReachDriver(t *testing.T) {
+	f, base := newFakeGorm(t, "postgres")
+	g := NewSession("gorm", base.db, TxOpts().Isolation(sql.LevelSerializable).Build(), context.Background()).(*GormSession)
+	if err := g.Transaction(context.Background(), func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.GormTransaction(context.Background(), func(ctx context.Context, tx *gorm.DB) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithTxDefaults(context.Background(), TxDefaults{ReadOnly: true})
+	if err := g.Transaction(ctx, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	serializable := driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelSerializable)}
+	readOnly := driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelSerializable), ReadOnly: true}
+	if len(f.txOpts) != 3 || f.txOpts[0] != serializable || f.txOpts[1] != serializable || f.txOpts[2] != readOnly {
+		t.Errorf("driver got %+v", f.txOpts)
+	}
+}
+
+func TestTxDefaultsReadWriteOverridesSession(t *testing.T) {
+	f, db := newFakeDB(t)
+	fg, base := newFakeGorm(t, "postgres")
+	readOnly := TxOpts().ReadOnly().Build()
+	sessions := map[string]struct {
+		f *fakeDB
+		s ITransaction
+	}{
+		"sql":  {f, NewSession("postgres", db, readOnly, context.Background())},
+		"gorm": {fg, NewSession("gorm", base.db, readOnly, context.Background())},
+	}
+	for name, c := range sessions {
+		t.Run(name, func(t *testing.T) {
+			for _, d := range []TxDefaults{{}, {ReadWrite: true}, {ReadOnly: true, ReadWrite: true}} {
+				if err := c.s.Transaction(WithTxDefaults(context.Background(), d), func(ctx context.Context) error { return nil }); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := []driver.TxOptions{{ReadOnly: true}, {}, {ReadOnly: true}}
+			if !slices.Equal(c.f.txOpts, want) {
+				t.Errorf("driver got %+v, want %+v", c.f.txOpts, want)
+			}
+		})
+	}
+}
+
+// txEntryPoints Ways of running fn in a new transaction of a session, all honoring the same defaults
+var txEntryPoints = map[string]func(tx ITransaction, ctx context.Context, fn func(context.Context) error) error{
+	"Transaction": func(tx ITransaction, ctx context.Context, fn func(context.Context) error) error {
+		return tx.Transaction(ctx, fn)
+	},
+	"TransactionContinueOnError": func(tx ITransaction, ctx context.Context, fn func(context.Context) error) error {
+		_, err := tx.(continueOnErrorSession).TransactionContinueOnError(ctx, fn)
+		return err
+	},
+	"Begin": func(tx ITransaction, ctx context.Context, fn func(context.Context) error) error {
+		ctx, finish, err := tx.(beginner).Begin(ctx)
+		if err != nil {
+			return err
+		}
+		return finish(fn(ctx))
+	},
+	"GormTransaction": func(tx ITransaction, ctx context.Context, fn func(context.Context) error) error {
+		g, ok := tx.(*GormSession)
+		if !ok {
+			return tx.Transaction(ctx, fn)
+		}
+		return g.GormTransaction(ctx, func(ctx context.Context, _ *gorm.DB) error { return fn(ctx) })
+	},
+}
+
+func TestTxDefaultsOnEveryEntryPoint(t *testing.T) {
+	errMapped := errors.New("mapped")
+	mapper := WithErrorMapper(func(err error) error {
+		if errors.Is(err, errFake) {
+			return fmt.Errorf("%w: %w", errMapped, err)
+		}
+		return err
+	})
+	for backend, open := range testSessions {
+		for name, run := range txEntryPoints {
+			t.Run(backend+"/"+name, func(t *testing.T) {
+				f, tx := open(t, "postgres", mapper, WithTxOptions(TxOpts().Isolation(sql.LevelSerializable).Build()))
+				ctx := WithTxDefaults(context.Background(), TxDefaults{
+					Isolation: sql.LevelRepeatableRead,
+					ReadOnly:  true,
+					Timeout:   time.Minute,
+				})
+				err := run(tx, ctx, func(ctx context.Context) error {
+					deadline, ok := ctx.Deadline()
+					if !ok || time.Until(deadline) > time.Minute {
+						t.Errorf("deadline = %v, %v, want one within the minute of the defaults", deadline, ok)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelRepeatableRead), ReadOnly: true}
+				if len(f.txOpts) != 1 || f.txOpts[0] != want {
+					t.Errorf("driver got %+v, want %+v", f.txOpts, want)
+				}
+
+				if err = run(tx, ctx, func(context.Context) error { return errFake }); !errors.Is(err, errMapped) {
+					t.Errorf("err = %v, want it mapped", err)
+				}
+
+				cancelled, cancel := context.WithCancel(ctx)
+				cancel()
+				begun := len(f.txOpts)
+				if err = run(tx, cancelled, func(context.Context) error { return nil }); !errors.Is(err, context.Canceled) {
+					t.Errorf("cancelled context err = %v", err)
+				}
+				if len(f.txOpts) != begun {
+					t.Error("a transaction began with a cancelled context")
+				}
+			})
+		}
+	}
+}
+
+func TestGormTxDefaultsTimeoutAbortsTransaction(t *testing.T) {
+	f, g := newFakeGorm(t, "postgres")
+	ctx := WithTxDefaults(context.Background(), TxDefaults{Timeout: 10 * time.Millisecond})
+	err := g.GormTransaction(ctx, func(ctx context.Context, tx *gorm.DB) error {
+		<-ctx.Done()
+		// database/sql rolls back a transaction whose context is done without waiting for fn
+		for wait := time.Now().Add(time.Second); f.count("ROLLBACK") == 0 && time.Now().Before(wait); {
+			time.Sleep(time.Millisecond)
+		}
+		if f.count("ROLLBACK") == 0 {
+			t.Error("transaction still open after its timeout")
+		}
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the timeout", err)
+	}
+	if f.count("COMMIT") != 0 {
+		t.Errorf("statements = %v", f.statements())
+	}
+}
@@ -0,0 +1,138 @@
+package cransaction
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// BulkUpsert Insert rows into table, updating the rows whose conflictCols already exist, and return how many
+// rows were inserted and how many were updated
+//
+// On Postgres the counts are exact, read from RETURNING (xmax = 0), and a statement must not carry the same key
+// twice. On MySQL they are derived from the affected rows, 1 per insert and 2 per update: a row updated to the
+// values it already had affects 0 rows and is counted as neither, which can skew the split, and connections
+// with CLIENT_FOUND_ROWS set report such rows as inserted. When every column is a conflict column existing
+// rows are left as is and not counted. Other drivers are not supported
+func BulkUpsert(ctx context.Context, tx ITransaction, table string, columns []string, conflictCols []string, rows [][]interface{}) (inserted int64, updated int64, err error) {
+	if err = checkTable(table); err != nil {
+		return 0, 0, err
+	}
+	for _, column := range append(append([]string(nil), columns...), conflictCols...) {
+		if err = checkIdentifier(column); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(conflictCols) == 0 {
+		return 0, 0, fmt.Errorf("cransaction: upsert into %s needs conflict columns", table)
+	}
+	d := dialectOf(tx)
+	if dialect := d.dialect(); dialect != "postgres" && dialect != "mysql" {
+		return 0, 0, fmt.Errorf("cransaction: upsert is not supported on %q", dialect)
+	}
+	if len(rows) == 0 {
+		return 0, 0, nil
+	}
+	err = inTransaction(ctx, tx, func(ctx context.Context) error {
+		size := BulkOptions{}.chunkSize(len(columns))
+		for start := 0; start < len(rows); start += size {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			chunk := rows[start:min(start+size, len(rows))]
+			query, args, err := insertStatement(d, table, columns, chunk)
+			if err != nil {
+				return err
+			}
+			query += upsertClause(d.dialect(), columns, conflictCols)
+			var ins, upd int64
+			if d.dialect() == "postgres" {
+				ins, upd, err = countReturnedInserts(ctx, tx, query, args)
+			} else {
+				ins, upd, err = countAffectedUpserts(ctx, tx, query, args, len(chunk))
+			}
+			if err != nil {
+				return err
+			}
+			inserted += ins
+			updated += upd
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return inserted, updated, nil
+}
+
+// upsertClause Conflict handling appended to the INSERT, the columns outside conflictCols take the new values
+func upsertClause(dialect string, columns, conflictCols []string) string {
+	conflict := make(map[string]bool, len(conflictCols))
+	for _, column := range conflictCols {
+		conflict[strings.ToLower(column)] = true
+	}
+	var set []string
+	for _, column := range columns {
+		if conflict[strings.ToLower(column)] {
+			continue
+		}
+		if dialect == "postgres" {
+			set = append(set, column+" = EXCLUDED."+column)
+		} else {
+			set = append(set, column+" = VALUES("+column+")")
+		}
+	}
+	if dialect == "postgres" {
+		target := " ON CONFLICT (" + strings.Join(conflictCols, ", ") + ")"
+		if len(set) == 0 {
+			return target + " DO NOTHING RETURNING (xmax = 0) AS inserted"
+		}
+		return target + " DO UPDATE SET " + strings.Join(set, ", ") + " RETURNING (xmax = 0) AS inserted"
+	}
+	if len(set) == 0 {
+		// A no-op assignment keeps existing rows untouched, they affect 0 rows
+		set = append(set, conflictCols[0]+" = "+conflictCols[0])
+	}
+	return " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+}
+
+// countReturnedInserts Run a Postgres upsert and split the returned rows into inserted and updated ones
+func countReturnedInserts(ctx context.Context, tx ITransaction, query string, args []interface{}) (int64, int64, error) {
+	rows, err := queryRows(ctx, tx, query, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	var inserted, updated int64
+	for rows.Next() {
+		var isInsert bool
+		if err = rows.Scan(&isInsert); err != nil {
+			return 0, 0, err
+		}
+		if isInsert {
+			inserted++
+		} else {
+			updated++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	return inserted, updated, rows.Close()
+}
+
+// countAffectedUpserts Run a MySQL upsert of n rows and derive the split from the affected rows
+func countAffectedUpserts(ctx context.Context, tx ITransaction, query string, args []interface{}, n int) (int64, int64, error) {
+	result, err := tx.ExecQuery(ctx, query, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	affected, err := rowsAffected(result)
+	if err != nil {
+		return 0, 0, err
+	}
+	// affected = inserted + 2*updated, assuming every row was either inserted or changed
+	updated := max(affected-int64(n), 0)
+	inserted := max(affected-2*updated, 0)
+	return inserted, updated, nil
+}
@@ -0,0 +1,89 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+// upsertStore Fake table keyed by the first of two columns per row, answering upserts as the dialect would
+type upsertStore struct {
+	keys map[int64]bool
+}
+
+// split Record the keys of the args and tell for each row whether it was inserted
+func (s *upsertStore) split(args []driver.NamedValue) []bool {
+	var inserted []bool
+	for i := 0; i < len(args); i += 2 {
+		key := args[i].Value.(int64)
+		inserted = append(inserted, !s.keys[key])
+		s.keys[key] = true
+	}
+	return inserted
+}
+
+func (s *upsertStore) returning(_ context.Context, args []driver.NamedValue) (*fakeResult, error) {
+	res := rowsOf([]string{"inserted"})
+	for _, inserted := range s.split(args) {
+		res.rows = append(res.rows, []driver.Value{inserted})
+	}
+	return res, nil
+}
+
+func (s *upsertStore) affected(_ context.Context, args []driver.NamedValue) (*fakeResult, error) {
+	res := &fakeResult{}
+	for _, inserted := range s.split(args) {
+		if inserted {
+			res.affected++
+		} else {
+			res.affected += 2
+		}
+	}
+	return res, nil
+}
+
+func TestBulkUpsertCounts(t *testing.T) {
+	cases := []struct {
+		dialect string
+		query   string
+	}{
+		{"postgres", "INSERT INTO users (id, name) VALUES ($1, $2), ($3, $4), ($5, $6) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name RETURNING (xmax = 0) AS inserted"},
+		{"mysql", "INSERT INTO users (id, name) VALUES (?, ?), (?, ?), (?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name)"},
+	}
+	for _, c := range cases {
+		t.Run(c.dialect, func(t *testing.T) {
+			f, r := newFakeSession(t, c.dialect)
+			store := &upsertStore{keys: map[int64]bool{1: true, 2: true}}
+			if c.dialect == "postgres" {
+				f.handle("INSERT INTO users", store.returning)
+			} else {
+				f.handle("INSERT INTO users", store.affected)
+			}
+			rows := [][]interface{}{{int64(1), "ada"}, {int64(3), "bob"}, {int64(4), "eve"}}
+			inserted, updated, err := BulkUpsert(context.Background(), r, "users", []string{"id", "name"}, []string{"id"}, rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inserted != 2 || updated != 1 {
+				t.Errorf("inserted %d, updated %d, want 2 and 1", inserted, updated)
+			}
+			wantStatements(t, f, "BEGIN", c.query, "COMMIT")
+		})
+	}
+}
+
+func TestBulkUpsertRejects(t *testing.T) {
+	_, r := newFakeSession(t, "mysql")
+	ctx := context.Background()
+	rows := [][]interface{}{{int64(1), "ada"}}
+	if _, _, err := BulkUpsert(ctx, r, "users", []string{"id", "name"}, nil, rows); err == nil {
+		t.Error("upsert without conflict columns accepted")
+	}
+	if _, _, err := BulkUpsert(ctx, r, "users; DROP TABLE users", []string{"id", "name"}, []string{"id"}, rows); err == nil {
+		t.Error("invalid table name accepted")
+	}
+	_, g := newFakeGorm(t, "sqlite")
+	if _, _, err := BulkUpsert(ctx, g, "users", []string{"id", "name"}, []string{"id"}, rows); err == nil {
+		t.Error("upsert accepted on sqlite")
+	}
+}
@@ -0,0 +1,57 @@
+package cransaction
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// UpdateWithVersion Set the columns of set on the row of table whose keyCol is keyVal, provided its versionCol
+// still holds expectedVersion, and increment versionCol
+//
+// Returns ErrVersionConflict when no row is updated, because another writer changed the row since it was read
+// or removed it. Columns are set in name order. The statement runs in the transaction of ctx if any
+func UpdateWithVersion(ctx context.Context, tx ITransaction, table string, set map[string]interface{}, keyCol string, keyVal interface{}, versionCol string, expectedVersion int64) error {
+	if err := checkTable(table); err != nil {
+		return err
+	}
+	columns := make([]string, 0, len(set))
+	for column := range set {
+		if err := checkIdentifier(column); err != nil {
+			return err
+		}
+		if column == versionCol {
+			return fmt.Errorf("cransaction: version column %q cannot be set, it is incremented", column)
+		}
+		columns = append(columns, column)
+	}
+	for _, column := range []string{keyCol, versionCol} {
+		if err := checkIdentifier(column); err != nil {
+			return err
+		}
+	}
+	sort.Strings(columns)
+	d := dialectOf(tx)
+	var b strings.Builder
+	fmt.Fprintf(&b, "UPDATE %s SET ", table)
+	args := make([]interface{}, 0, len(columns)+2)
+	for _, column := range columns {
+		args = append(args, set[column])
+		fmt.Fprintf(&b, "%s = %s, ", column, d.bindVar(len(args)))
+	}
+	args = append(args, keyVal, expectedVersion)
+	fmt.Fprintf(&b, "%s = %s + 1 WHERE %s = %s AND %s = %s", versionCol, versionCol, keyCol, d.bindVar(len(args)-1), versionCol, d.bindVar(len(args)))
+	result, err := tx.ExecQuery(ctx, b.String(), args...)
+	if err != nil {
+		return err
+	}
+	n, err := rowsAffected(result)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
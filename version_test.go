@@ -0,0 +1,74 @@
+package cransaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// versionedRow Emulate a row at version, updated only by statements expecting that version
+func versionedRow(f *fakeDB, version int64) {
+	f.handle("UPDATE", func(_ context.Context, args []driver.NamedValue) (*fakeResult, error) {
+		if args[len(args)-1].Value != version {
+			return &fakeResult{}, nil
+		}
+		version++
+		return &fakeResult{affected: 1}, nil
+	})
+}
+
+func TestUpdateWithVersion(t *testing.T) {
+	for name, open := range testSessions {
+		t.Run(name, func(t *testing.T) {
+			f, s := open(t, "postgres")
+			versionedRow(f, 3)
+			set := map[string]interface{}{"title": "Draft 2", "body": "..."}
+			err := s.Transaction(context.Background(), func(ctx context.Context) error {
+				return UpdateWithVersion(ctx, s, "documents", set, "id", 7, "version", 3)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			update := f.last("UPDATE")
+			want := "UPDATE documents SET body = $1, title = $2, version = version + 1 WHERE id = $3 AND version = $4"
+			if name == "gorm" {
+				want = "UPDATE documents SET body = ?, title = ?, version = version + 1 WHERE id = ? AND version = ?"
+			}
+			if update.query != want || !update.inTx {
+				t.Errorf("update = %q in a transaction: %v", update.query, update.inTx)
+			}
+			if !slices.Equal(update.args, []driver.Value{"...", "Draft 2", int64(7), int64(3)}) {
+				t.Errorf("args = %v", update.args)
+			}
+
+			// A second writer that read version 3 too loses
+			if err = UpdateWithVersion(context.Background(), s, "documents", set, "id", 7, "version", 3); !errors.Is(err, ErrVersionConflict) {
+				t.Errorf("stale update err = %v, want ErrVersionConflict", err)
+			}
+			if err = UpdateWithVersion(context.Background(), s, "documents", set, "id", 7, "version", 4); err != nil {
+				t.Errorf("update at the current version: %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdateWithVersionRejects(t *testing.T) {
+	f, r := newFakeSession(t, "mysql")
+	for name, set := range map[string]map[string]interface{}{
+		"version column": {"version": 9},
+		"unsafe column":  {"title = 1; --": "x"},
+	} {
+		if err := UpdateWithVersion(context.Background(), r, "documents", set, "id", 7, "version", 3); err == nil || errors.Is(err, ErrVersionConflict) {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+	f.fail("UPDATE", errFake)
+	if err := UpdateWithVersion(context.Background(), r, "documents", map[string]interface{}{"title": "x"}, "id", 7, "version", 3); !errors.Is(err, errFake) {
+		t.Errorf("err = %v, want the statement error", err)
+	}
+	if got := f.count("UPDATE"); got != 1 {
+		t.Errorf("%d statements, want only the valid one", got)
+	}
+}